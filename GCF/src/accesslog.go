@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Access logs go to stdout as structured entries, apart from the application logs on stderr
+var accessLogEnabled = true
+
+// accessEntry holds the fields of an access log entry, filled in while handling the request
+type accessEntry struct {
+	TeamID         string
+	EventType      string
+	PublishLatency time.Duration
+}
+
+// httpRequestLog is the request as understood by Cloud Logging
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#httprequest
+type httpRequestLog struct {
+	RequestMethod string `json:"requestMethod"`
+	RequestURL    string `json:"requestUrl"`
+	Status        int    `json:"status"`
+	RequestSize   string `json:"requestSize"`
+	ResponseSize  string `json:"responseSize"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+	Latency       string `json:"latency"`
+}
+
+// setupAccessLog configures the access log from the environment
+func setupAccessLog() {
+	accessLogEnabled = getenv("ACCESS_LOG") != "false"
+}
+
+// responseRecorder records the status and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush sends the buffered response, if the underlying writer supports it
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+type accessEntryContextKey struct{}
+
+// accessEntryFromContext returns the access log entry of the request, nil if disabled
+func accessEntryFromContext(ctx context.Context) *accessEntry {
+	entry, _ := ctx.Value(accessEntryContextKey{}).(*accessEntry)
+	return entry
+}
+
+// annotateAccessLog records the payload fields in the request's access log entry
+func annotateAccessLog(ctx context.Context, payload *slackPayload) {
+	if entry := accessEntryFromContext(ctx); entry != nil {
+		entry.TeamID = payload.TeamID
+		entry.EventType = payload.Event.Type
+		if entry.EventType == "" {
+			entry.EventType = payload.Type
+		}
+	}
+}
+
+// recordPublishLatency records the time the backend took to accept the message
+func recordPublishLatency(ctx context.Context, latency time.Duration) {
+	if entry := accessEntryFromContext(ctx); entry != nil {
+		entry.PublishLatency = latency
+	}
+}
+
+// withAccessLog wraps a handler, writing an access log entry once it returns
+func withAccessLog(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !accessLogEnabled {
+			handler(w, r)
+			return
+		}
+
+		start := time.Now()
+		entry := &accessEntry{}
+		rec := &responseRecorder{ResponseWriter: w}
+
+		// Deferred in order to log panicking requests as well
+		defer func() {
+			writeAccessLog(r, rec, entry, time.Since(start))
+		}()
+
+		handler(rec, r.WithContext(context.WithValue(r.Context(), accessEntryContextKey{}, entry)))
+	}
+}
+
+// writeAccessLog writes a single access log entry
+func writeAccessLog(r *http.Request, rec *responseRecorder, fields *accessEntry, latency time.Duration) {
+	status := rec.status
+	if status == 0 {
+		// Nothing was written, which happens when panicking
+		status = http.StatusInternalServerError
+	}
+
+	severity := severityInfo
+	if status >= http.StatusInternalServerError {
+		severity = severityError
+	} else if status >= http.StatusBadRequest {
+		severity = severityWarning
+	}
+
+	labels := map[string]string{"log": "access"}
+	for key, value := range logLabels {
+		labels[key] = value
+	}
+
+	entry := map[string]any{
+		"httpRequest": httpRequestLog{
+			RequestMethod: r.Method,
+			RequestURL:    r.URL.String(),
+			Status:        status,
+			RequestSize:   fmt.Sprint(r.ContentLength),
+			ResponseSize:  fmt.Sprint(rec.bytes),
+			UserAgent:     r.UserAgent(),
+			RemoteIP:      clientIP(r),
+			Latency:       fmt.Sprintf("%.9fs", latency.Seconds()),
+		},
+		"message":                       fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, status),
+		"latency_ms":                    float64(latency.Microseconds()) / 1000,
+		"logging.googleapis.com/labels": labels,
+	}
+	if fields.TeamID != "" {
+		entry["team_id"] = fields.TeamID
+	}
+	if fields.EventType != "" {
+		entry["event_type"] = fields.EventType
+	}
+	if fields.PublishLatency != 0 {
+		entry["publish_latency_ms"] = float64(fields.PublishLatency.Microseconds()) / 1000
+	}
+
+	writeStructured(r.Context(), os.Stdout, severity, entry)
+}
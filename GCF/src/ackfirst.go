@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Whether events are acknowledged to Slack before being published
+var ackFirst bool
+
+var lostEvents = newCounterVec("slack_proxy_acknowledged_events_lost_total",
+	"Events acknowledged to Slack whose publish then failed, by event type.", "event_type")
+
+// setupAckFirst configures the early acknowledgement from the environment.
+// Slack's 3 seconds then only cover the stages before the publish, at the cost of losing
+// events whose publish fails after the acknowledgement, as Slack won't retry them.
+func setupAckFirst() {
+	ackFirst = getenv("ACK_FIRST") == "true"
+	if ackFirst && backend != backendPubSub {
+		configErrorf("ACK_FIRST is only supported by the pubsub backend.")
+		ackFirst = false
+	}
+}
+
+// acknowledgeEarly answers Slack with a 200 before the event is published.
+// Returns the context to publish with, detached from the request so the publish
+// isn't canceled once Slack closes the connection. The publish is waited for by Flush until canceled.
+func acknowledgeEarly(ctx context.Context, w http.ResponseWriter, e *Event) (context.Context, context.CancelFunc) {
+	w.WriteHeader(http.StatusOK)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	e.acked = true
+
+	startBackground()
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, publishBudget)
+	return ctx, func() {
+		cancel()
+		finishBackground()
+	}
+}
+
+// detachedContext keeps the values of its parent, but not its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+// detachedContextKey is set by detached contexts, whose deadline no longer is the request's
+type detachedContextKey struct{}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (ctx detachedContext) Value(key any) any {
+	if key == (detachedContextKey{}) {
+		return true
+	}
+	return ctx.parent.Value(key)
+}
+
+// isDetached returns true if the context is detached from the request
+func isDetached(ctx context.Context) bool {
+	return ctx.Value(detachedContextKey{}) != nil
+}
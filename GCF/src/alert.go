@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Failures alerted on
+const (
+	alertPublishFailure   = "publish_failure"
+	alertSignatureFailure = "signature_failure"
+	alertAppRateLimited   = "app_rate_limited"
+)
+
+const (
+	defaultAlertThreshold = 10
+	defaultAlertWindow    = 5 * time.Minute
+)
+
+var (
+	// Webhook receiving the alerts, alerting is disabled if empty.
+	// A Slack incoming webhook posts the alerts to an ops channel.
+	alertWebhookURL string
+
+	alertThreshold = defaultAlertThreshold
+	alertWindow    = defaultAlertWindow
+	alertClient    = &http.Client{Timeout: 2 * time.Second}
+
+	alertMu       sync.Mutex
+	alertTrackers = map[string]*failureTracker{}
+)
+
+// failureTracker counts failures in a fixed window, alerting once per window
+type failureTracker struct {
+	windowStart time.Time
+	count       int
+	alerted     bool
+}
+
+// setupAlerts configures the failure alerts from the environment
+func setupAlerts() {
+	alertWebhookURL = getenv("ALERT_WEBHOOK_URL")
+	if alertWebhookURL == "" {
+		return
+	}
+
+	alertThreshold = configInt("ALERT_THRESHOLD", defaultAlertThreshold)
+	alertWindow = configDuration("ALERT_WINDOW", defaultAlertWindow, false)
+}
+
+// recordFailure counts a failure of the given kind, alerting once the threshold is reached
+func recordFailure(ctx context.Context, kind string) {
+	if alertWebhookURL == "" {
+		return
+	}
+
+	if countFailure(kind, alertThreshold) {
+		sendAlert(ctx, kind, fmt.Sprintf(":rotating_light: %s: %d %s events in the last %s.",
+			serviceName(), alertThreshold, kind, alertWindow))
+	}
+}
+
+// countFailure counts a failure of the given kind in its window,
+// returning true once per window when the threshold is reached
+func countFailure(kind string, threshold int) bool {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+
+	tracker, ok := alertTrackers[kind]
+	if !ok {
+		tracker = &failureTracker{}
+		alertTrackers[kind] = tracker
+	}
+
+	now := time.Now()
+	if now.Sub(tracker.windowStart) > alertWindow {
+		*tracker = failureTracker{windowStart: now}
+	}
+	tracker.count++
+
+	fire := tracker.count >= threshold && !tracker.alerted
+	if fire {
+		tracker.alerted = true
+	}
+	return fire
+}
+
+// sendAlert posts the alert to the webhook in the background, off the request path.
+// The body is compatible with Slack incoming webhooks.
+func sendAlert(ctx context.Context, kind, text string) {
+	body, err := json.Marshal(map[string]any{
+		"text":      text,
+		"kind":      kind,
+		"threshold": alertThreshold,
+		"window":    alertWindow.String(),
+		"service":   serviceName(),
+	})
+	if err != nil {
+		return
+	}
+
+	goBackground(func() { postAlert(ctx, body) })
+}
+
+// postAlert posts an encoded alert to the webhook
+func postAlert(logCtx context.Context, body []byte) {
+	// Detached from the request, so the alert isn't cancelled along with it
+	ctx, cancel := context.WithTimeout(context.Background(), alertClient.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, alertWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		logError(logCtx, "Failed creating alert: %s", err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		logError(logCtx, "Failed sending alert: %s", err.Error())
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		logError(logCtx, "Alert webhook returned status %d", resp.StatusCode)
+	}
+}
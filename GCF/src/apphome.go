@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"time"
+)
+
+// Event type of users opening the app's App Home, fired on every visit of its tabs
+const eventAppHomeOpened = "app_home_opened"
+
+var (
+	// Time window in which repeated openings of a tab by a user are suppressed, 0 if disabled
+	appHomeWindow time.Duration
+	appHomeKeys   Store
+
+	// Topic receiving the app_home_opened events instead of the destination topic, nil if unset
+	appHomeTopic pubsubTopic
+
+	suppressedAppHomeOpenings = newCounterVec("slack_proxy_app_home_opened_suppressed_total",
+		"Repeated app_home_opened events suppressed by the window, by tab.", "tab")
+)
+
+// setupAppHome configures the app_home_opened preset from the environment
+func setupAppHome() {
+	if topicName := getenv("APP_HOME_OPENED_TOPIC"); topicName != "" {
+		appHomeTopic = openExistingTopic(topicName)
+	}
+
+	if appHomeWindow = configDuration("APP_HOME_OPENED_WINDOW", 0, false); appHomeWindow == 0 {
+		return
+	}
+	appHomeKeys = newStoreFromEnv("APP_HOME_OPENED_STORE")
+}
+
+// claimAppHome returns the key of the user's opening of the tab, or false if already seen within the window.
+// Store failures let the event through.
+func claimAppHome(ctx context.Context, payload *slackPayload) (string, bool) {
+	key := "app_home:" + payload.TeamID + ":" + rawString(payload.Event.User) + ":" + payload.Event.Tab
+
+	claimed, err := appHomeKeys.SetNX(ctx, key, "1", appHomeWindow)
+	if err != nil {
+		logError(ctx, "Failed claiming app_home_opened key: %s", err.Error())
+		return "", true
+	}
+
+	if !claimed {
+		suppressedAppHomeOpenings.Inc(payload.Event.Tab)
+		return "", false
+	}
+
+	return key, true
+}
+
+// releaseAppHome releases a claimed opening, so a retry isn't suppressed
+func releaseAppHome(ctx context.Context, key string) {
+	if err := appHomeKeys.Delete(ctx, key); err != nil {
+		logError(ctx, "Failed releasing app_home_opened key: %s", err.Error())
+	}
+}
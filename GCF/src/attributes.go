@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pub/Sub limits of the message attributes
+const (
+	maxAttributes         = 100
+	maxAttributeKeySize   = 256
+	maxAttributeValueSize = 1024
+)
+
+var (
+	// Attributes to attach, nil to attach all of them
+	attributeAllowlist map[string]bool
+
+	// Allowed attribute prefixes, from allowlist entries ending with *
+	attributeAllowedPrefixes []string
+)
+
+var (
+	// Attributes of the proxy, kept ahead of the others when over the limit
+	proxyAttributes = setOf(
+		attrProxyVersion, attrIdempotencyKey, attrBodySHA256, attrPayloadFormat, attrPayloadEncoding,
+		attrReceivedAt, attrExpiresAt, attrStageTimings, attrPayloadEncryption, attrEncryptionKeyID, attrCanary,
+	)
+
+	// Attributes the proxy attaches once the others are guarded, which are always attached
+	lateAttributes = []string{attrPublishAttempt, attrChainInstance, attrChainSeq, attrChainPrev, attrChainHash, attrPublishRegion}
+)
+
+var (
+	truncatedAttributes = newCounterVec("slack_proxy_attributes_truncated_total",
+		"Attribute values truncated to the Pub/Sub size limit, by attribute.", "attribute")
+	droppedAttributes = newCounterVec("slack_proxy_attributes_dropped_total",
+		"Attributes dropped for exceeding the Pub/Sub limits, by attribute.", "attribute")
+)
+
+// setupAttributeAllowlist configures the attribute allowlist from the environment
+func setupAttributeAllowlist() {
+	// ATTRIBUTE_ALLOWLIST is a comma separated list of attribute names, e.g. idempotency_key,query_*
+	allowlist := getenv("ATTRIBUTE_ALLOWLIST")
+	if allowlist == "" {
+		return
+	}
+
+	attributeAllowlist = map[string]bool{}
+	for _, name := range strings.Split(allowlist, ",") {
+		name = strings.TrimSpace(name)
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			attributeAllowedPrefixes = append(attributeAllowedPrefixes, prefix)
+		} else if name != "" {
+			attributeAllowlist[name] = true
+		}
+	}
+}
+
+// isAllowedAttribute returns true if the attribute may be attached
+func isAllowedAttribute(name string) bool {
+	if attributeAllowlist == nil || attributeAllowlist[name] {
+		return true
+	}
+	for _, prefix := range attributeAllowedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// guardAttributes removes the attributes that aren't allowed, and fits the rest within Pub/Sub's limits,
+// leaving room for the late attributes. Runs right before forwarding, once all other attributes are attached.
+// Oversized values are truncated and oversized keys dropped, rather than failing the publish.
+func guardAttributes(ctx context.Context, attributes map[string]string) {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		if !isAllowedAttribute(name) {
+			delete(attributes, name)
+			continue
+		}
+		names = append(names, name)
+	}
+
+	// Keep a deterministic set of attributes when over the limit, starting with the proxy's
+	sort.Slice(names, func(i, j int) bool {
+		if proxyAttributes[names[i]] != proxyAttributes[names[j]] {
+			return proxyAttributes[names[i]]
+		}
+		return names[i] < names[j]
+	})
+
+	kept := 0
+	for _, name := range names {
+		if len(name) > maxAttributeKeySize || kept == maxAttributes-len(lateAttributes) {
+			delete(attributes, name)
+			droppedAttributes.Inc(name)
+			logWarning(ctx, "Dropped attribute %.64s exceeding Pub/Sub's limits.", name)
+			continue
+		}
+		kept++
+
+		if value := attributes[name]; len(value) > maxAttributeValueSize {
+			attributes[name] = truncateUTF8(value, maxAttributeValueSize)
+			truncatedAttributes.Inc(name)
+			logWarning(ctx, "Truncated attribute %s of %d bytes.", name, len(value))
+		}
+	}
+}
+
+// truncateUTF8 truncates the string to at most size bytes, without splitting a character
+func truncateUTF8(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+	return s[:size]
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+)
+
+const (
+	// Records written at once, beyond which rejections are counted but not recorded
+	auditMaxInFlight = 100
+
+	// Longest time the write of a record may take
+	auditWriteTimeout = 2 * time.Second
+)
+
+var (
+	// Audit sinks, nil if disabled
+	auditTopic  pubsubTopic
+	auditBucket *storage.BucketHandle
+	auditPrefix string
+
+	// Slots of the records being written
+	auditSlots chan struct{}
+
+	droppedAuditRecords = newCounterVec("slack_proxy_audit_records_dropped_total",
+		"Rejected requests not recorded, as too many records were being written, by reason.", "reason")
+)
+
+// auditRecord describes a rejected request
+type auditRecord struct {
+	Time       time.Time         `json:"time"`
+	Reason     string            `json:"reason"`
+	Status     int               `json:"status"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	SourceIP   string            `json:"source_ip"`
+	Headers    map[string]string `json:"headers"`
+	BodyLength int64             `json:"body_length"`
+	BodyHash   string            `json:"body_sha256,omitempty"`
+}
+
+// setupAudit configures the audit sinks from the environment.
+// AUDIT_TOPIC is a Pub/Sub topic id, AUDIT_GCS_PREFIX is a gs://bucket/prefix path.
+func setupAudit() {
+	if topicName := getenv("AUDIT_TOPIC"); topicName != "" {
+		auditTopic = openExistingTopic(topicName)
+	}
+
+	if prefix := getenv("AUDIT_GCS_PREFIX"); prefix != "" {
+		auditBucket, auditPrefix = openBucketPrefix("AUDIT_GCS_PREFIX", prefix)
+	}
+
+	if auditTopic != nil || auditBucket != nil {
+		auditSlots = make(chan struct{}, auditMaxInFlight)
+	}
+}
+
+// openBucketPrefix opens the bucket of a gs://bucket/prefix setting, returning the prefix ending with a slash.
+// Records a configuration error and returns a nil bucket if the setting is invalid.
+func openBucketPrefix(name, value string) (*storage.BucketHandle, string) {
+	path, ok := strings.CutPrefix(value, "gs://")
+	bucket, path, _ := strings.Cut(path, "/")
+	if !ok || bucket == "" {
+		configErrorf("%s must be of the form gs://bucket/prefix.", name)
+		return nil, ""
+	}
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	client, err := storage.NewClient(context.Background(), egressClientOptions()...)
+	if err != nil {
+		log.Panicf("Failed creating a Storage client: %s.", err.Error())
+	}
+	return client.Bucket(bucket), path
+}
+
+// auditRejection records a rejected request to the audit sinks, before it's answered:
+// the instance may be frozen once the response is sent.
+// The body is the one already read, if any, and is hashed, never stored. Rejections beyond the records
+// being written are only counted, so a flood of invalid requests can't turn into a flood of writes.
+func auditRejection(r *http.Request, body []byte, status int, reason string) {
+	if auditSlots == nil {
+		return
+	}
+
+	select {
+	case auditSlots <- struct{}{}:
+		defer func() { <-auditSlots }()
+	default:
+		droppedAuditRecords.Inc(reason)
+		return
+	}
+
+	record := auditRecord{
+		Time:       time.Now().UTC(),
+		Reason:     reason,
+		Status:     status,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		SourceIP:   clientIP(r),
+		Headers:    map[string]string{},
+		BodyLength: r.ContentLength,
+	}
+
+	for name := range r.Header {
+		if !isSensitiveHeader(name) {
+			record.Headers[name] = r.Header.Get(name)
+		}
+	}
+
+	// Oversized bodies are not read at all
+	if body != nil {
+		record.BodyHash = bodyHash(body)
+	} else if r.Body != nil && reason != reasonOversized {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, io.LimitReader(r.Body, maxBodySize)); err == nil {
+			record.BodyHash = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(detachedContext{r.Context()}, auditWriteTimeout)
+	defer cancel()
+	writeAuditRecord(ctx, record, requestID(r))
+}
+
+// writeAuditRecord publishes the record to the audit topic, and writes it as a JSON object to the bucket,
+// named by its time and the request id
+func writeAuditRecord(ctx context.Context, record auditRecord, id string) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		logError(ctx, "Failed encoding audit record: %s", err.Error())
+		return
+	}
+
+	if auditTopic != nil {
+		err := auditTopic.Publish(ctx, &pubsub.Message{
+			Data:       data,
+			Attributes: map[string]string{"reason": record.Reason},
+		})
+		if err != nil {
+			logError(ctx, "Failed publishing audit record: %s", err.Error())
+		}
+	}
+
+	if auditBucket != nil {
+		name := fmt.Sprintf("%s%s-%s.json", auditPrefix, record.Time.Format("2006/01/02/150405.000000000"), id)
+		writer := auditBucket.Object(name).NewWriter(ctx)
+		writer.ContentType = "application/json"
+		if _, err := writer.Write(data); err != nil {
+			writer.Close()
+			logError(ctx, "Failed writing audit record: %s", err.Error())
+			return
+		}
+		if err := writer.Close(); err != nil {
+			logError(ctx, "Failed writing audit record: %s", err.Error())
+		}
+	}
+}
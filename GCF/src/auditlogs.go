@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const slackAuditLogsURL = "https://api.slack.com/audit/v1/logs"
+
+const (
+	defaultAuditLogsCollection = "slack-proxy-state"
+	defaultAuditLogsLookback   = time.Hour
+
+	// Document of the poller's cursor
+	auditLogsStateDoc = "audit-logs"
+
+	auditLogsPageSize = 200
+)
+
+// Message attributes of the published audit log entries
+const (
+	attrAuditAction = "audit_action"
+)
+
+var (
+	// Org-level token with the auditlogs:read scope, empty if the poller is disabled
+	slackAuditToken string
+
+	auditLogsTopic    pubsubTopic
+	auditLogsState    *firestore.DocumentRef
+	auditLogsLookback = defaultAuditLogsLookback
+)
+
+// auditLogsCursor is the state of the poller between runs
+type auditLogsCursor struct {
+	// Creation time of the newest published entry
+	Latest int64 `firestore:"latest"`
+
+	// IDs of the published entries created at Latest, which the next run returns again
+	LatestIDs []string `firestore:"latest_ids"`
+}
+
+// auditLogEntry holds the fields of an audit log entry the poller looks at
+type auditLogEntry struct {
+	ID         string `json:"id"`
+	DateCreate int64  `json:"date_create"`
+	Action     string `json:"action"`
+
+	raw json.RawMessage
+}
+
+// setupAuditLogs configures the audit logs poller from the environment.
+// The poller is served by the AuditLogPoller function, meant to be triggered by Cloud Scheduler.
+func setupAuditLogs() {
+	slackAuditToken = getenv("SLACK_AUDIT_TOKEN")
+	if slackAuditToken == "" {
+		return
+	}
+
+	if topicName := getenv("AUDIT_LOGS_TOPIC"); topicName != "" {
+		auditLogsTopic = openExistingTopic(topicName)
+	} else {
+		configErrorf("AUDIT_LOGS_TOPIC env var must be set when SLACK_AUDIT_TOKEN is set.")
+	}
+
+	collection := getenv("AUDIT_LOGS_STATE_COLLECTION")
+	if collection == "" {
+		collection = defaultAuditLogsCollection
+	}
+	auditLogsState = firestoreClient().Collection(collection).Doc(auditLogsStateDoc)
+
+	auditLogsLookback = configDuration("AUDIT_LOGS_LOOKBACK", defaultAuditLogsLookback, false)
+}
+
+// AuditLogPoller publishes the Slack Enterprise audit log entries created since its last run.
+// The cursor is only advanced once all entries were published, so a failed run is retried by the next one.
+func AuditLogPoller(w http.ResponseWriter, r *http.Request) {
+	Setup()
+
+	if slackAuditToken == "" {
+		http.Error(w, "Audit log polling is not configured.", http.StatusNotFound)
+		return
+	}
+
+	published, err := pollAuditLogs(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "audit_logs_failed")
+		logError(r.Context(), "Failed polling audit logs: %s", err.Error())
+		reportError(fmt.Errorf("failed polling audit logs: %w", err), r)
+		return
+	}
+
+	logInfo(r.Context(), "Published %d audit log entries.", published)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"published":%d}`, published)
+}
+
+// pollAuditLogs publishes the new entries, returning how many were published
+func pollAuditLogs(ctx context.Context) (int, error) {
+	var cursor auditLogsCursor
+	doc, err := auditLogsState.Get(ctx)
+	switch {
+	case status.Code(err) == codes.NotFound:
+		cursor.Latest = clock.Now().Add(-auditLogsLookback).Unix()
+	case err != nil:
+		return 0, fmt.Errorf("failed reading the cursor: %w", err)
+	default:
+		if err := doc.DataTo(&cursor); err != nil {
+			return 0, fmt.Errorf("failed decoding the cursor: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool, len(cursor.LatestIDs))
+	for _, id := range cursor.LatestIDs {
+		seen[id] = true
+	}
+
+	entries, err := fetchAuditLogs(ctx, cursor.Latest)
+	if err != nil {
+		return 0, err
+	}
+
+	next := cursor
+	published := 0
+	for _, entry := range entries {
+		if seen[entry.ID] {
+			continue
+		}
+
+		err := auditLogsTopic.Publish(ctx, &pubsub.Message{
+			Data: entry.raw,
+			Attributes: map[string]string{
+				attrIdempotencyKey: entry.ID,
+				attrAuditAction:    entry.Action,
+			},
+		})
+		if err != nil {
+			return published, fmt.Errorf("failed publishing entry %s: %w", entry.ID, err)
+		}
+		published++
+
+		if entry.DateCreate > next.Latest {
+			next = auditLogsCursor{Latest: entry.DateCreate}
+		}
+		if entry.DateCreate == next.Latest {
+			next.LatestIDs = append(next.LatestIDs, entry.ID)
+		}
+	}
+
+	if published == 0 {
+		return 0, nil
+	}
+	if _, err := auditLogsState.Set(ctx, next); err != nil {
+		return published, fmt.Errorf("failed saving the cursor: %w", err)
+	}
+	return published, nil
+}
+
+// fetchAuditLogs fetches all the entries created since oldest, going through every page
+func fetchAuditLogs(ctx context.Context, oldest int64) ([]auditLogEntry, error) {
+	var entries []auditLogEntry
+	pageCursor := ""
+
+	for {
+		args := url.Values{
+			"oldest": {strconv.FormatInt(oldest, 10)},
+			"limit":  {strconv.Itoa(auditLogsPageSize)},
+		}
+		if pageCursor != "" {
+			args.Set("cursor", pageCursor)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, slackAuditLogsURL+"?"+args.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+slackAuditToken)
+
+		resp, err := slackAPIClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Entries          []json.RawMessage `json:"entries"`
+			Error            string            `json:"error"`
+			ResponseMetadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("audit logs API returned status %d %s", resp.StatusCode, page.Error)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding audit logs: %w", err)
+		}
+
+		for _, raw := range page.Entries {
+			var entry auditLogEntry
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return nil, fmt.Errorf("failed decoding audit log entry: %w", err)
+			}
+			entry.raw = raw
+			entries = append(entries, entry)
+		}
+
+		if pageCursor = page.ResponseMetadata.NextCursor; pageCursor == "" {
+			return entries, nil
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"golang.org/x/net/http/httpguts"
+)
+
+// Backends messages can be forwarded to
+const (
+	backendPubSub  = "pubsub"
+	backendWebhook = "webhook"
+
+	// Set by SetPublisher
+	backendCustom = "custom"
+)
+
+// Prefix of the headers carrying the message attributes to a webhook
+const webhookAttributeHeader = "X-Slack-Proxy-"
+
+const defaultWebhookTimeout = 2 * time.Second
+
+// Publisher forwards messages in place of the configured backend
+type Publisher interface {
+	// Publish returns once the message was accepted
+	Publish(ctx context.Context, msg *pubsub.Message) error
+}
+
+var (
+	backend = backendPubSub
+
+	// Custom publisher, nil if unset
+	publisher Publisher
+
+	webhookURL         string
+	webhookContentType = "application/json"
+	webhookTimeout     = defaultWebhookTimeout
+
+	// Client of the webhook, and of the view submission and unfurl backends.
+	// Each bounds its requests by its own timeout.
+	webhookClient = &http.Client{}
+
+	// Transformation of the payload into the webhook body, nil to forward it as is
+	webhookTemplate *template.Template
+)
+
+// Functions available to webhook templates
+var webhookTemplateFuncs = template.FuncMap{
+	// json encodes a value, for safely embedding payload fields in JSON bodies
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// default returns the fallback if the value is empty
+	"default": func(fallback, v any) any {
+		if v == nil || v == "" {
+			return fallback
+		}
+		return v
+	},
+}
+
+// SetPublisher forwards messages to the publisher rather than to the configured backend,
+// e.g. to capture them in-memory. Must be called before Setup.
+func SetPublisher(p Publisher) {
+	publisher = p
+}
+
+// setupBackend configures the backend from the environment
+func setupBackend() {
+	// The signatures and client certificate of the webhook requests,
+	// also used by the view submission and unfurl backends
+	setupWebhookSigning()
+	setupWebhookTLS()
+
+	if publisher != nil {
+		backend = backendCustom
+		return
+	}
+
+	switch backend = getenv("BACKEND"); backend {
+	case "":
+		backend = backendPubSub
+	case backendPubSub:
+	case backendWebhook:
+		setupWebhook()
+	default:
+		configErrorf("Unknown BACKEND: %s.", backend)
+	}
+}
+
+// setupWebhook configures the webhook backend from the environment
+func setupWebhook() {
+	webhookURL = getenv("WEBHOOK_URL")
+	if u, err := url.Parse(webhookURL); err != nil || u.Host == "" {
+		configErrorf("WEBHOOK_URL env var must be set to a valid URL.")
+	}
+
+	webhookTimeout = configDuration("WEBHOOK_TIMEOUT", defaultWebhookTimeout, false)
+
+	if text := getenv("WEBHOOK_TEMPLATE"); text != "" {
+		var err error
+		webhookTemplate, err = template.New("webhook").Funcs(webhookTemplateFuncs).Parse(text)
+		if err != nil {
+			configErrorf("Invalid WEBHOOK_TEMPLATE: %s.", err.Error())
+		}
+	}
+
+	if contentType := getenv("WEBHOOK_CONTENT_TYPE"); contentType != "" {
+		webhookContentType = contentType
+	}
+}
+
+// forward sends the message to the configured backend, returning once it was accepted.
+// The message must be fresh on each attempt, see withPublishAttempt.
+func forward(ctx context.Context, msg *pubsub.Message) error {
+	if chaosEnabled && flagEnabled(flagFaultInjection) {
+		if drop, err := injectFault(ctx); drop || err != nil {
+			return err
+		}
+	}
+
+	switch backend {
+	case backendWebhook:
+		return forwardToWebhook(ctx, msg)
+	case backendCustom:
+		return publisher.Publish(ctx, msg)
+	default:
+		return publishReplicated(ctx, destinationTopic(ctx, msg), msg)
+	}
+}
+
+// forwardToWebhook posts the (optionally transformed) payload to the webhook.
+// Attributes are sent as X-Slack-Proxy-<attribute> headers, see webhookAttributeHeaders.
+func forwardToWebhook(ctx context.Context, msg *pubsub.Message) error {
+	body := msg.Data
+	if webhookTemplate != nil {
+		var err error
+		if body, err = transformPayload(msg.Data); err != nil {
+			return fmt.Errorf("failed transforming payload: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", webhookContentType)
+	webhookAttributeHeaders(ctx, req.Header, msg.Attributes)
+	if webhookSigningSecret != nil {
+		signWebhookRequest(req, body)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &webhookStatusError{status: resp.StatusCode}
+	}
+	return nil
+}
+
+// webhookAttributeHeaders sets the attributes as X-Slack-Proxy-<attribute> headers.
+// Values that aren't valid header values, e.g. holding line breaks, are sent RFC 2047 encoded.
+// Attributes whose names can't be header names are left out.
+func webhookAttributeHeaders(ctx context.Context, header http.Header, attributes map[string]string) {
+	for name, value := range attributes {
+		name = webhookAttributeHeader + strings.ReplaceAll(name, "_", "-")
+		if !httpguts.ValidHeaderFieldName(name) {
+			logWarning(ctx, "Attribute header %q isn't a valid header name, not sent.", name)
+			continue
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			value = mime.QEncoding.Encode("utf-8", value)
+		}
+		header.Set(name, value)
+	}
+}
+
+// webhookStatusError is returned when the webhook responds with a non-2xx status
+type webhookStatusError struct {
+	status int
+}
+
+func (err *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook returned status %d", err.status)
+}
+
+// transformPayload renders the webhook template over the payload
+func transformPayload(data []byte) ([]byte, error) {
+	fields, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := webhookTemplate.Execute(&body, fields); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
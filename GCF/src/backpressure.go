@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+)
+
+const (
+	errorBackpressure = "backpressure"
+
+	// Share of the limits past which the shed priorities are rejected
+	shedFraction = 0.75
+
+	// Seconds Slack is asked to wait before retrying
+	backpressureRetryAfter = "1"
+)
+
+var (
+	// Limits of the messages being published at once, and of their size. Zero if unlimited.
+	maxOutstandingMessages int64
+	maxOutstandingBytes    int64
+
+	// Priorities rejected first, once past shedFraction of the limits. Nil if none.
+	shedPriorities map[string]bool
+
+	// Messages being published at once and their size, checked and reserved together
+	outstandingMu       sync.Mutex
+	outstandingMessages int64
+	outstandingBytes    int64
+
+	backpressureRejected = newCounterVec("slack_proxy_backpressure_rejected_total",
+		"Requests answered with a 503 while the backend falls behind, by priority.", "priority")
+)
+
+// setupBackpressure configures the limits of the outstanding publishes from the environment
+func setupBackpressure() {
+	maxOutstandingMessages = int64(configInt("BACKPRESSURE_MAX_MESSAGES", 0))
+	maxOutstandingBytes = int64(configInt("BACKPRESSURE_MAX_BYTES", 0))
+
+	shedPriorities = nil
+	if priorities := getenv("BACKPRESSURE_SHED_PRIORITIES"); priorities != "" {
+		if maxOutstandingMessages == 0 && maxOutstandingBytes == 0 {
+			configErrorf("BACKPRESSURE_SHED_PRIORITIES requires BACKPRESSURE_MAX_MESSAGES or BACKPRESSURE_MAX_BYTES.")
+		}
+		shedPriorities = map[string]bool{}
+		for _, priority := range strings.Split(priorities, ",") {
+			shedPriorities[strings.TrimSpace(priority)] = true
+		}
+	}
+}
+
+// backpressureEnabled returns true if the outstanding publishes are limited
+func backpressureEnabled() bool {
+	return maxOutstandingMessages != 0 || maxOutstandingBytes != 0
+}
+
+// reservePublish reserves room for a message of the given size, unless the outstanding publishes
+// exceed the given share of the limits. Returns the outstanding messages and bytes, before reserving.
+func reservePublish(share float64, size int64) (bool, int64, int64) {
+	outstandingMu.Lock()
+	defer outstandingMu.Unlock()
+
+	messages, bytes := outstandingMessages, outstandingBytes
+	if (maxOutstandingMessages != 0 && float64(messages) >= share*float64(maxOutstandingMessages)) ||
+		(maxOutstandingBytes != 0 && float64(bytes) >= share*float64(maxOutstandingBytes)) {
+		return false, messages, bytes
+	}
+
+	outstandingMessages++
+	outstandingBytes += size
+	return true, messages, bytes
+}
+
+// admitPublish reserves room for publishing the message, returning false if the backend falls behind.
+// Messages of the shed priorities are rejected first. Admitted publishes must be finished with finishPublish.
+func admitPublish(w http.ResponseWriter, e *Event) bool {
+	priority := e.Message.Attributes[attrPriority]
+	share := 1.0
+	if shedPriorities[priority] {
+		share = shedFraction
+	}
+
+	ok, messages, bytes := reservePublish(share, int64(len(e.Message.Data)))
+	if !ok {
+		logWarning(e.Request.Context(), "Backend falling behind with %d messages (%d bytes) outstanding, rejecting %s event.",
+			messages, bytes, e.EventType())
+		rejectBackpressure(w, priority)
+		return false
+	}
+	return true
+}
+
+// rejectBackpressure answers a request while the backend falls behind, asking Slack to retry shortly
+func rejectBackpressure(w http.ResponseWriter, priority string) {
+	if priority == "" {
+		priority = "none"
+	}
+	backpressureRejected.Inc(priority)
+
+	w.Header().Set("Retry-After", backpressureRetryAfter)
+	writeError(w, http.StatusServiceUnavailable, errorBackpressure)
+}
+
+// finishPublish releases the room reserved for the message
+func finishPublish(e *Event) {
+	outstandingMu.Lock()
+	defer outstandingMu.Unlock()
+
+	outstandingMessages--
+	outstandingBytes -= int64(len(e.Message.Data))
+}
+
+// publisherFlowControl returns the flow control of the gRPC publishers, failing publishes past the limits
+// rather than blocking them. The publishers' own count then covers every publish of the instance.
+func publisherFlowControl() pubsub.FlowControlSettings {
+	return pubsub.FlowControlSettings{
+		MaxOutstandingMessages: int(maxOutstandingMessages),
+		MaxOutstandingBytes:    int(maxOutstandingBytes),
+		LimitExceededBehavior:  pubsub.FlowControlSignalError,
+	}
+}
+
+// isFlowControlled returns true if the publisher rejected the message for exceeding its flow control limits
+func isFlowControlled(err error) bool {
+	return errors.Is(err, pubsub.ErrFlowControllerMaxOutstandingMessages) ||
+		errors.Is(err, pubsub.ErrFlowControllerMaxOutstandingBytes)
+}
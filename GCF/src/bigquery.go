@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+const (
+	defaultUsageExportInterval = time.Minute
+
+	// Rows of failed exports kept for the next one, beyond which the oldest are dropped
+	maxFailedUsageRows = 10000
+)
+
+var (
+	// Table receiving the usage rows, nil if the export is disabled
+	usageTable *bigqueryTable
+
+	usageExportInterval = defaultUsageExportInterval
+
+	// Usage counted by this instance since the last export, by team
+	usageMu      sync.Mutex
+	usagePending = map[string]*teamUsage{}
+	usageSince   time.Time
+
+	// Rows of failed exports, sent again with the next one
+	usageFailedRows []*bigquery.TableDataInsertAllRequestRows
+
+	// Identifies the rows of this instance
+	usageInstance string
+)
+
+// teamUsage is the usage of a team within an export window
+type teamUsage struct {
+	forwarded int64
+	overQuota int64
+}
+
+// bigqueryTable is a table of the BigQuery API
+type bigqueryTable struct {
+	service   *bigquery.Service
+	project   string
+	dataset   string
+	table     string
+	reference string
+}
+
+// setupUsageExport configures the export of the usage to BigQuery from the environment.
+// USAGE_BIGQUERY_TABLE is a project.dataset.table or dataset.table id.
+func setupUsageExport() {
+	reference := getenv("USAGE_BIGQUERY_TABLE")
+	if reference == "" {
+		return
+	}
+	if !meteringEnabled {
+		configErrorf("METERING must be enabled when USAGE_BIGQUERY_TABLE is set.")
+		return
+	}
+
+	parts := strings.Split(reference, ".")
+	if len(parts) == 2 {
+		parts = append([]string{getenv("GCP_PROJECT")}, parts...)
+	}
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		configErrorf("USAGE_BIGQUERY_TABLE must be of the form project.dataset.table.")
+		return
+	}
+
+	service, err := bigquery.NewService(context.Background(), egressClientOptions()...)
+	if err != nil {
+		configErrorf("Failed creating a BigQuery client: %s.", err.Error())
+		return
+	}
+
+	instance := make([]byte, 8)
+	rand.Read(instance)
+	usageInstance = hex.EncodeToString(instance)
+
+	usageExportInterval = configDuration("USAGE_EXPORT_INTERVAL", defaultUsageExportInterval, false)
+	usageSince = clock.Now()
+	usageTable = &bigqueryTable{service: service, project: parts[0], dataset: parts[1], table: parts[2], reference: reference}
+
+	go exportUsagePeriodically()
+}
+
+// countUsage counts an event of the team towards the next export
+func countUsage(teamID string, overQuota bool) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	usage, ok := usagePending[teamID]
+	if !ok {
+		usage = &teamUsage{}
+		usagePending[teamID] = usage
+	}
+	if overQuota {
+		usage.overQuota++
+	} else {
+		usage.forwarded++
+	}
+}
+
+// exportUsagePeriodically exports the usage every export interval
+func exportUsagePeriodically() {
+	ticker := time.NewTicker(usageExportInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), usageExportInterval)
+		if err := exportUsage(ctx); err != nil {
+			logError(ctx, "Failed exporting usage: %s", err.Error())
+		}
+		cancel()
+	}
+}
+
+// exportUsage streams a row per team with the usage counted since the last export.
+// Rows are identified by their content, so BigQuery drops those of retried inserts.
+// Rows of a failed export are sent again as-is with the next one, keeping their ids,
+// so a failed insert that reached BigQuery isn't counted twice.
+func exportUsage(ctx context.Context) error {
+	usageMu.Lock()
+	pending, since, until := usagePending, usageSince, clock.Now()
+	usagePending, usageSince = map[string]*teamUsage{}, until
+	rows := usageFailedRows
+	usageFailedRows = nil
+	usageMu.Unlock()
+
+	for teamID, usage := range pending {
+		rows = append(rows, usageRow(teamID, usage, since, until))
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	request := &bigquery.TableDataInsertAllRequest{Rows: rows}
+	resp, err := usageTable.service.Tabledata.InsertAll(usageTable.project, usageTable.dataset, usageTable.table, request).
+		Context(ctx).Do()
+	if err == nil && len(resp.InsertErrors) > 0 {
+		// Rejected rows won't fare better when retried, e.g. with a mismatching schema
+		logError(ctx, "BigQuery rejected %d usage rows of %s.", len(resp.InsertErrors), usageTable.reference)
+		return nil
+	}
+	if err != nil {
+		// Send the same rows again with the next export, dropping the oldest past the limit
+		usageMu.Lock()
+		usageFailedRows = append(rows, usageFailedRows...)
+		if dropped := len(usageFailedRows) - maxFailedUsageRows; dropped > 0 {
+			logError(ctx, "Dropped %d usage rows of failed exports to %s.", dropped, usageTable.reference)
+			usageFailedRows = usageFailedRows[dropped:]
+		}
+		usageMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// usageRow returns the row of the team's usage within the window, identified by its content
+func usageRow(teamID string, usage *teamUsage, since, until time.Time) *bigquery.TableDataInsertAllRequestRows {
+	windowStart, windowEnd := since.UTC().Format(time.RFC3339Nano), until.UTC().Format(time.RFC3339Nano)
+
+	id := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n%s\n%d\n%d",
+		usageInstance, teamID, windowStart, windowEnd, usage.forwarded, usage.overQuota)))
+
+	return &bigquery.TableDataInsertAllRequestRows{
+		InsertId: hex.EncodeToString(id[:]),
+		Json: map[string]bigquery.JsonValue{
+			"team_id":      teamID,
+			"day":          since.UTC().Format("2006-01-02"),
+			"window_start": windowStart,
+			"window_end":   windowEnd,
+			"instance":     usageInstance,
+			"forwarded":    usage.forwarded,
+			"over_quota":   usage.overQuota,
+		},
+	}
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestBudget tracks the time left to respond to Slack, and what consumed it
+type requestBudget struct {
+	start    time.Time
+	deadline time.Time
+
+	mu     sync.Mutex
+	stages []stageTiming
+}
+
+// stageTiming is the time spent by a stage of a request
+type stageTiming struct {
+	stage    string
+	duration time.Duration
+}
+
+// budgetOption configures a request budget
+type budgetOption func(*requestBudget)
+
+// withBudgetTotal sets the time allowed from the request's arrival
+func withBudgetTotal(total time.Duration) budgetOption {
+	return func(b *requestBudget) {
+		b.deadline = b.start.Add(total)
+	}
+}
+
+// newRequestBudget creates the budget of a request arriving now
+func newRequestBudget(opts ...budgetOption) *requestBudget {
+	now := time.Now()
+	b := &requestBudget{start: now, deadline: now}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// remaining returns the time left until the deadline
+func (b *requestBudget) remaining() time.Duration {
+	return time.Until(b.deadline)
+}
+
+// record records the time spent by a stage
+func (b *requestBudget) record(stage string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stages = append(b.stages, stageTiming{stage: stage, duration: d})
+}
+
+// String lists the time spent by each stage, e.g. "verify 2ms, route 850ms"
+func (b *requestBudget) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	timings := make([]string, len(b.stages))
+	for i, timing := range b.stages {
+		timings[i] = fmt.Sprintf("%s %s", timing.stage, timing.duration.Round(time.Millisecond))
+	}
+	return strings.Join(timings, ", ")
+}
+
+// logIfOverrun logs a warning with the time spent by each stage, if the request ran past its deadline
+func (b *requestBudget) logIfOverrun(ctx context.Context) {
+	if b.remaining() < 0 {
+		logWarning(ctx, "Request took %s, past its budget of %s: %s",
+			time.Since(b.start).Round(time.Millisecond), b.deadline.Sub(b.start), b)
+	}
+}
+
+type budgetContextKey struct{}
+
+// withBudget attaches a budget to the request, starting at its arrival
+func withBudget(r *http.Request, opts ...budgetOption) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), budgetContextKey{}, newRequestBudget(opts...)))
+}
+
+// budgetFrom returns the budget of the request, or a fresh one if it has none
+func budgetFrom(ctx context.Context) *requestBudget {
+	if b, ok := ctx.Value(budgetContextKey{}).(*requestBudget); ok {
+		return b
+	}
+	return newRequestBudget(withBudgetTotal(publishBudget))
+}
+
+// requestStart returns when the request arrived, or now if unknown
+func requestStart(ctx context.Context) time.Time {
+	return budgetFrom(ctx).start
+}
+
+// stageTimeout returns the timeout of a stage preceding the publish, given its own limit.
+// The stage is allowed at most half the remaining budget, leaving the rest for the publish.
+func stageTimeout(ctx context.Context, limit time.Duration) time.Duration {
+	if half := budgetFrom(ctx).remaining() / 2; half < limit {
+		return half
+	}
+	return limit
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"context"
+	"time"
+)
+
+// cache stores string values with a fixed TTL.
+// Errors are logged and treated as cache misses, as a cache must never fail a request.
+type cache interface {
+	Get(ctx context.Context, key string) (string, bool)
+	Set(ctx context.Context, key, value string)
+}
+
+// newCacheFromEnv creates a cache over the store selected by the <prefix> env var
+func newCacheFromEnv(prefix string, ttl time.Duration) cache {
+	return &storeCache{store: newStoreFromEnv(prefix), ttl: ttl}
+}
+
+// storeCache caches entries in a store
+type storeCache struct {
+	store Store
+	ttl   time.Duration
+}
+
+// Get returns the cached value and whether it was found
+func (c *storeCache) Get(ctx context.Context, key string) (string, bool) {
+	value, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		logError(ctx, "Failed reading from cache: %s", err.Error())
+		return "", false
+	}
+	return value, ok
+}
+
+// Set caches the value until the TTL expires
+func (c *storeCache) Set(ctx context.Context, key, value string) {
+	if err := c.store.Set(ctx, key, value, c.ttl); err != nil {
+		logError(ctx, "Failed writing to cache: %s", err.Error())
+	}
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strconv"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Message attribute set on the messages routed to the canary topic
+const attrCanary = "canary"
+
+var (
+	// Topic receiving a share of the events of the primary topic, nil if disabled
+	canaryTopic pubsubTopic
+
+	// Share of the events routed to the canary topic, in percent
+	canaryPercent float64
+)
+
+// setupCanary configures the canary routing from the environment
+func setupCanary() {
+	topicName := getenv("CANARY_TOPIC")
+	if topicName == "" {
+		return
+	}
+	if backend != backendPubSub {
+		configErrorf("CANARY_TOPIC is only supported by the pubsub backend.")
+		return
+	}
+
+	percent, err := strconv.ParseFloat(getenv("CANARY_PERCENT"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		configErrorf("CANARY_PERCENT must be a number between 0 and 100 when CANARY_TOPIC is set.")
+		return
+	}
+
+	canaryPercent = percent
+	canaryTopic = openExistingTopic(topicName)
+}
+
+// isCanary returns true if the message belongs to the canary's share of the events.
+// Decided by the idempotency key, so Slack's retries of an event take the same route.
+func isCanary(msg *pubsub.Message) bool {
+	hash := sha256.Sum256([]byte(msg.Attributes[attrIdempotencyKey]))
+	return float64(binary.BigEndian.Uint64(hash[:8])%10000) < canaryPercent*100
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	// Longest capture allowed, so a forgotten setting doesn't record traffic indefinitely
+	maxCaptureWindow = 24 * time.Hour
+
+	// Size of the response body kept in the captures
+	maxCapturedResponse = 64 * 1024
+
+	redactedHeader = "[redacted]"
+)
+
+var (
+	// Capture sink, nil if disabled
+	captureBucket *storage.BucketHandle
+	capturePrefix string
+
+	// End of the capture window
+	captureUntil time.Time
+	captureEnded sync.Once
+)
+
+// Capture entries follow the entry format of HAR 1.2, http://www.softwareishard.com/blog/har-12-spec/#entries
+type (
+	captureEntry struct {
+		StartedDateTime time.Time       `json:"startedDateTime"`
+		Time            float64         `json:"time"`
+		Request         captureRequest  `json:"request"`
+		Response        captureResponse `json:"response"`
+		Comment         string          `json:"comment,omitempty"`
+	}
+
+	captureRequest struct {
+		Method      string          `json:"method"`
+		URL         string          `json:"url"`
+		HTTPVersion string          `json:"httpVersion"`
+		Headers     []captureHeader `json:"headers"`
+		PostData    *capturePost    `json:"postData,omitempty"`
+		BodySize    int             `json:"bodySize"`
+	}
+
+	capturePost struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+	}
+
+	captureResponse struct {
+		Status  int             `json:"status"`
+		Headers []captureHeader `json:"headers"`
+		Content captureContent  `json:"content"`
+	}
+
+	captureContent struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+	}
+
+	captureHeader struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+)
+
+// setupCapture configures the request capture from the environment.
+// CAPTURE_GCS_PREFIX is a gs://bucket/prefix path, CAPTURE_UNTIL the RFC 3339 end of the capture window.
+func setupCapture() {
+	prefix := getenv("CAPTURE_GCS_PREFIX")
+	if prefix == "" {
+		return
+	}
+
+	until, err := time.Parse(time.RFC3339, getenv("CAPTURE_UNTIL"))
+	if err != nil {
+		configErrorf("CAPTURE_GCS_PREFIX requires CAPTURE_UNTIL, an RFC 3339 time such as 2024-05-01T12:30:00Z.")
+		return
+	}
+	if time.Until(until) > maxCaptureWindow {
+		configErrorf("CAPTURE_UNTIL must be within %s.", maxCaptureWindow)
+		return
+	}
+	if time.Now().After(until) {
+		// Left over from a past capture
+		return
+	}
+
+	captureBucket, capturePrefix = openBucketPrefix("CAPTURE_GCS_PREFIX", prefix)
+	captureUntil = until
+	logWarning(context.Background(), "Capturing full requests to %s until %s.", prefix, until.Format(time.RFC3339))
+}
+
+// capturing returns true while requests are captured, logging once the window is over
+func capturing() bool {
+	if captureBucket == nil {
+		return false
+	}
+	if time.Now().Before(captureUntil) {
+		return true
+	}
+
+	captureEnded.Do(func() {
+		logInfo(context.Background(), "Request capture ended at %s.", captureUntil.Format(time.RFC3339))
+	})
+	return false
+}
+
+// captureWriter records the response of a captured request, up to maxCapturedResponse bytes of its body
+type captureWriter struct {
+	responseRecorder
+	body bytes.Buffer
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if room := maxCapturedResponse - cw.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		cw.body.Write(b[:room])
+	}
+	return cw.responseRecorder.Write(b)
+}
+
+// withCapture wraps a handler, recording the full request and its response to the capture sink.
+// Captured requests hold private Slack messages, so capturing stops by itself once past CAPTURE_UNTIL.
+func withCapture(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !capturing() {
+			handler(w, r)
+			return
+		}
+
+		// Read the body up to the limit, leaving the rest to the rejection of oversized requests
+		var body []byte
+		if r.Body != nil && r.ContentLength <= maxBodySize {
+			var err error
+			if body, err = io.ReadAll(io.LimitReader(r.Body, maxBodySize)); err != nil {
+				logWarning(r.Context(), "Failed capturing request body: %s", err.Error())
+			}
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+
+		start := time.Now()
+		cw := &captureWriter{responseRecorder: responseRecorder{ResponseWriter: w}}
+		defer func() {
+			writeCapture(r, body, cw, start)
+		}()
+
+		handler(cw, r)
+	}
+}
+
+// readCloser reads from a reader, and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// writeCapture writes the capture entry of the request to the sink.
+// The entry is uploaded in the background, so the response isn't held back by the upload.
+func writeCapture(r *http.Request, body []byte, cw *captureWriter, start time.Time) {
+	status := cw.status
+	if status == 0 {
+		// Nothing was written, which happens when panicking
+		status = http.StatusInternalServerError
+	}
+
+	url := "https://" + r.Host + r.URL.RequestURI()
+	entry := captureEntry{
+		StartedDateTime: start.UTC(),
+		Time:            float64(time.Since(start).Microseconds()) / 1000,
+		Request: captureRequest{
+			Method:      r.Method,
+			URL:         url,
+			HTTPVersion: r.Proto,
+			Headers:     captureHeaders(r.Header),
+			BodySize:    len(body),
+		},
+		Response: captureResponse{
+			Status:  status,
+			Headers: captureHeaders(cw.Header()),
+			Content: captureContent{
+				Size:     cw.bytes,
+				MimeType: cw.Header().Get("Content-Type"),
+				Text:     cw.body.String(),
+			},
+		},
+		Comment: requestID(r),
+	}
+	if len(body) != 0 {
+		entry.Request.PostData = &capturePost{MimeType: r.Header.Get("Content-Type"), Text: string(body)}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		logError(r.Context(), "Failed encoding capture: %s", err.Error())
+		return
+	}
+
+	name := fmt.Sprintf("%s%s-%d.json", capturePrefix, entry.StartedDateTime.Format("2006/01/02/150405.000000000"), status)
+	goBackground(func() { uploadCapture(r.Context(), name, data) })
+}
+
+// uploadCapture uploads the capture entry to the sink
+func uploadCapture(logCtx context.Context, name string, data []byte) {
+	// Detached from the request, which is over by now
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	writer := captureBucket.Object(name).NewWriter(ctx)
+	writer.ContentType = "application/json"
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		logError(logCtx, "Failed writing capture: %s", err.Error())
+		return
+	}
+	if err := writer.Close(); err != nil {
+		logError(logCtx, "Failed writing capture: %s", err.Error())
+	}
+}
+
+// captureHeaders lists the headers in name order, redacting the signature and credentials
+func captureHeaders(header http.Header) []captureHeader {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := make([]captureHeader, 0, len(names))
+	for _, name := range names {
+		for _, value := range header[name] {
+			if isSensitiveHeader(name) {
+				value = redactedHeader
+			}
+			headers = append(headers, captureHeader{Name: name, Value: value})
+		}
+	}
+	return headers
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Message attributes set by the integrity chain
+const (
+	attrChainInstance = "chain_instance"
+	attrChainSeq      = "chain_seq"
+	attrChainPrev     = "chain_prev"
+	attrChainHash     = "chain_hash"
+)
+
+const (
+	// Default number of links between checkpoints
+	defaultCheckpointInterval = 100
+
+	// Time allowed to publish a checkpoint, within the request's budget
+	checkpointTimeout = time.Second
+)
+
+// hashChain links every forwarded message to the one before it.
+// Each instance keeps its own chain, identified by a random instance id.
+type hashChain struct {
+	mu       sync.Mutex
+	instance string
+	seq      uint64
+	prev     string
+
+	// Checkpoint every interval links
+	interval uint64
+	topic    pubsubTopic
+}
+
+// chainCheckpoint records the head of a chain, or a link that was never published
+type chainCheckpoint struct {
+	Time     time.Time `json:"time"`
+	Instance string    `json:"chain_instance"`
+	Seq      uint64    `json:"chain_seq"`
+	Hash     string    `json:"chain_hash"`
+	Gap      bool      `json:"gap,omitempty"`
+}
+
+// Integrity chain, nil if disabled
+var integrityChain *hashChain
+
+// setupIntegrityChain configures the integrity chain from the environment
+func setupIntegrityChain() {
+	if getenv("INTEGRITY_CHAIN") != "true" {
+		return
+	}
+
+	instance := make([]byte, 8)
+	if _, err := rand.Read(instance); err != nil {
+		log.Panicf("Failed generating a chain instance id: %s.", err.Error())
+	}
+
+	integrityChain = &hashChain{
+		instance: hex.EncodeToString(instance),
+		prev:     hex.EncodeToString(make([]byte, sha256.Size)),
+		interval: defaultCheckpointInterval,
+	}
+
+	if interval := getenv("INTEGRITY_CHECKPOINT_INTERVAL"); interval != "" {
+		n, err := strconv.ParseUint(interval, 10, 64)
+		if err != nil || n == 0 {
+			configErrorf("INTEGRITY_CHECKPOINT_INTERVAL must be a positive integer.")
+		} else {
+			integrityChain.interval = n
+		}
+	}
+
+	if topicName := getenv("INTEGRITY_CHECKPOINT_TOPIC"); topicName != "" {
+		integrityChain.topic = openExistingTopic(topicName)
+	}
+
+	logInfo(context.Background(), "Integrity chain enabled. Instance: %s", integrityChain.instance)
+}
+
+// attributesHash hashes the attributes as sorted "key=value\n" lines.
+// The late attributes are left out, as they are set after the link or differ between attempts and replicas.
+func attributesHash(attributes map[string]string) [sha256.Size]byte {
+	late := setOf(lateAttributes...)
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		if !late[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key + "=" + attributes[key] + "\n"))
+	}
+
+	var sum [sha256.Size]byte
+	hash.Sum(sum[:0])
+	return sum
+}
+
+// link appends the message to the chain, stamping it with the chain attributes.
+// hash = sha256(prev_hash + sha256(payload) + sha256(attributes))
+// Returns the checkpoint to record once the message is published, nil if none is due.
+func (c *hashChain) link(msg *pubsub.Message) *chainCheckpoint {
+	payloadHash := sha256.Sum256(msg.Data)
+	attrsHash := attributesHash(msg.Attributes)
+
+	c.mu.Lock()
+	hash := sha256.New()
+	hash.Write(stringToByteSlice(&c.prev))
+	hash.Write(payloadHash[:])
+	hash.Write(attrsHash[:])
+
+	prev := c.prev
+	c.prev = hex.EncodeToString(hash.Sum(nil))
+	c.seq++
+	checkpoint := chainCheckpoint{Instance: c.instance, Seq: c.seq, Hash: c.prev}
+	c.mu.Unlock()
+
+	msg.Attributes[attrChainInstance] = c.instance
+	msg.Attributes[attrChainSeq] = strconv.FormatUint(checkpoint.Seq, 10)
+	msg.Attributes[attrChainPrev] = prev
+	msg.Attributes[attrChainHash] = checkpoint.Hash
+
+	if checkpoint.Seq%c.interval != 0 {
+		return nil
+	}
+	checkpoint.Time = time.Now().UTC()
+	return &checkpoint
+}
+
+// gap records a linked message that failed to publish.
+// The sequence number is already taken by the next links, so the gap carries the hash the chain continues from.
+func (c *hashChain) gap(ctx context.Context, msg *pubsub.Message) {
+	seq, err := strconv.ParseUint(msg.Attributes[attrChainSeq], 10, 64)
+	if err != nil {
+		return
+	}
+
+	checkpoint := chainCheckpoint{
+		Time:     time.Now().UTC(),
+		Instance: c.instance,
+		Seq:      seq,
+		Hash:     msg.Attributes[attrChainHash],
+		Gap:      true,
+	}
+	c.checkpoint(ctx, checkpoint)
+}
+
+// checkpoint logs the head of the chain or a gap, and publishes it if a topic is set.
+// Runs before answering the request, so it isn't lost to an instance stopped or throttled after the response.
+// One failing to publish is only left in the logs, logged apart so a lost checkpoint isn't taken for a gap.
+func (c *hashChain) checkpoint(ctx context.Context, checkpoint chainCheckpoint) {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		logError(ctx, "Failed encoding chain checkpoint: %s", err.Error())
+		return
+	}
+
+	if checkpoint.Gap {
+		logError(ctx, "Integrity chain link %d was not published: %s", checkpoint.Seq, data)
+	} else {
+		logInfo(ctx, "Integrity chain checkpoint: %s", data)
+	}
+
+	if c.topic == nil {
+		return
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, checkpointTimeout)
+	defer cancel()
+
+	if err := c.topic.Publish(publishCtx, &pubsub.Message{Data: data}); err != nil {
+		if checkpoint.Gap {
+			logError(ctx, "Failed publishing the gap at chain link %d, it's only logged: %s", checkpoint.Seq, err.Error())
+		} else {
+			logError(ctx, "Failed publishing the chain checkpoint at link %d, it's only logged: %s", checkpoint.Seq, err.Error())
+		}
+		reportError(err, nil)
+	}
+}
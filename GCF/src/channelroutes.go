@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// channelRoute routes the events of the channels matching its pattern to a topic
+type channelRoute struct {
+	pattern string
+	topic   string
+}
+
+// setupChannelRoutes configures the channel routes from the environment.
+// CHANNEL_ROUTES is a comma separated list of pattern=topic, e.g. #incidents-*=incidents,C0123ABCD=support.
+func setupChannelRoutes(live *liveConfig) {
+	routes := getenv("CHANNEL_ROUTES")
+	if routes == "" {
+		return
+	}
+
+	for _, rule := range strings.Split(routes, ",") {
+		pattern, topicName, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok || pattern == "" || !topicNamePattern.MatchString(topicName) {
+			configErrorf("Invalid CHANNEL_ROUTES rule: %s.", rule)
+			continue
+		}
+		if err := checkChannelPattern(pattern); err != nil {
+			configErrorf("Invalid CHANNEL_ROUTES pattern: %s.", pattern)
+			continue
+		}
+		live.channelRoutes = append(live.channelRoutes, channelRoute{pattern: pattern, topic: topicName})
+	}
+}
+
+// checkChannelPattern validates a glob of channel ids, or of channel names if prefixed with #
+func checkChannelPattern(pattern string) error {
+	glob := strings.TrimPrefix(pattern, "#")
+	if glob == "" {
+		return errors.New("empty pattern")
+	}
+	_, err := path.Match(glob, "")
+	return err
+}
+
+// matchesChannel returns true if the pattern matches the channel id, or the channel name if prefixed with #
+func matchesChannel(pattern, channel, name string) bool {
+	if glob, ok := strings.CutPrefix(pattern, "#"); ok {
+		pattern, channel = glob, name
+	}
+	if channel == "" {
+		return false
+	}
+	ok, _ := path.Match(pattern, channel)
+	return ok
+}
+
+// channelName returns the name of the payload's channel, as resolved by the enrichment or given by slash commands
+func channelName(payload *slackPayload, attributes map[string]string) string {
+	if name := attributes[attrChannelName]; name != "" {
+		return name
+	}
+	return payload.ChannelName
+}
+
+// channelRouteTopic returns the topic of the first route matching the channel of the message's payload.
+// Names are matched against the channel_name attribute, so routes by name of events require enrichment.
+func (live *liveConfig) channelRouteTopic(msg *pubsub.Message) (string, bool) {
+	payload := parsePayload(msg.Data)
+	channel, name := payload.channelID(), channelName(payload, msg.Attributes)
+
+	for _, route := range live.channelRoutes {
+		if matchesChannel(route.pattern, channel, name) {
+			return route.topic, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// Faults injected into every forwarding attempt, all disabled by default
+	chaosEnabled     bool
+	chaosLatency     time.Duration
+	chaosFailureRate float64
+	chaosDropRate    float64
+)
+
+var injectedFaults = newCounterVec("slack_proxy_injected_faults_total",
+	"Faults injected for resilience testing, by fault.", "fault")
+
+// setupChaos configures the fault injection from the environment
+func setupChaos() {
+	chaosLatency = configDuration("CHAOS_PUBLISH_LATENCY", 0, true)
+
+	chaosFailureRate = parseChaosRate("CHAOS_PUBLISH_FAILURE_RATE")
+	chaosDropRate = parseChaosRate("CHAOS_DROP_RATE")
+
+	chaosEnabled = chaosLatency > 0 || chaosFailureRate > 0 || chaosDropRate > 0
+	if chaosEnabled {
+		logWarning(context.Background(), "Fault injection enabled. Latency: %s, failure rate: %g, drop rate: %g",
+			chaosLatency, chaosFailureRate, chaosDropRate)
+	}
+}
+
+// parseChaosRate parses a rate between 0 and 1 from the environment
+func parseChaosRate(name string) float64 {
+	value := getenv(name)
+	if value == "" {
+		return 0
+	}
+
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		configErrorf("%s must be between 0 and 1.", name)
+		return 0
+	}
+	return rate
+}
+
+// injectFault delays a forwarding attempt, and may fail or drop it.
+// Returns true if the attempt should be dropped, acknowledging it without forwarding.
+// Failures are Unavailable errors, so they're retried like real ones.
+func injectFault(ctx context.Context) (bool, error) {
+	if chaosLatency > 0 {
+		injectedFaults.Inc("latency")
+		select {
+		case <-time.After(chaosLatency):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	if rand.Float64() < chaosFailureRate {
+		injectedFaults.Inc("failure")
+		return false, status.Error(codes.Unavailable, "injected publish failure")
+	}
+
+	if rand.Float64() < chaosDropRate {
+		injectedFaults.Inc("drop")
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Message attribute holding the address of the client
+const attrClientIP = "client_ip"
+
+// Networks of the load balancers and proxies in front of the proxy, whose forwarding headers are trusted
+var trustedProxies []*net.IPNet
+
+// setupClientIP configures the trusted proxies from the environment.
+// TRUSTED_PROXIES is a comma separated list of CIDRs or addresses, e.g. 35.191.0.0/16,130.211.0.0/22
+func setupClientIP() {
+	value := getenv("TRUSTED_PROXIES")
+	if value == "" {
+		return
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			configErrorf("Invalid TRUSTED_PROXIES entry: %s.", entry)
+			continue
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+}
+
+// isTrustedProxy returns true if the address belongs to a trusted proxy
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent the request.
+// Forwarding headers are walked from the closest hop, until the first address that isn't a trusted proxy.
+// Without trusted proxies, the headers are ignored, as anyone can set them.
+func clientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(remote)
+	if ip == nil || !isTrustedProxy(ip) {
+		return remote
+	}
+
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		if hop == nil {
+			// Obfuscated or malformed, can't go any further
+			break
+		}
+		remote = hops[i]
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return remote
+}
+
+// forwardedFor returns the client addresses of the forwarding headers, farthest first.
+// Prefers the standard Forwarded header over X-Forwarded-For.
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+
+	if headers := r.Header.Values("Forwarded"); len(headers) > 0 {
+		// Forwarded: for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"
+		for _, header := range headers {
+			for _, element := range strings.Split(header, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if ok && strings.EqualFold(key, "for") {
+						hops = append(hops, forwardedHost(strings.Trim(value, `"`)))
+					}
+				}
+			}
+		}
+		return hops
+	}
+
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedHost strips the port and brackets of a Forwarded node, e.g. [2001:db8::1]:4711
+func forwardedHost(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.Trim(node, "[]")
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const reasonStaleTimestamp = "stale_timestamp"
+
+const (
+	// Slack recommends rejecting requests older than 5 minutes, to prevent replays
+	defaultTimestampTolerance = 5 * time.Minute
+
+	// Skew of signed timestamps suggesting the local clock is wrong
+	clockSkewWarningThreshold = time.Minute
+)
+
+// Clock is the time source of the timestamp validation and the expiry of dedup windows, caches and quotas
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the local wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var (
+	clock Clock = systemClock{}
+
+	// Maximum age of a request's timestamp, 0 if unchecked
+	timestampTolerance = defaultTimestampTolerance
+
+	// Set once the clock skew was warned about
+	clockSkewWarned atomic.Bool
+)
+
+// SetClock replaces the time source, e.g. to simulate skew and expiry in tests.
+// Must be called before serving requests.
+func SetClock(c Clock) {
+	clock = c
+}
+
+// setupClock configures the timestamp validation from the environment
+func setupClock() {
+	timestampTolerance = configDuration("SLACK_TIMESTAMP_TOLERANCE", timestampTolerance, true)
+}
+
+// isFreshTimestamp returns true if the request's timestamp is within the tolerance.
+// Only called once the signature is valid, so a large skew means either a replay or a wrong local clock,
+// which is warned about once per instance.
+func isFreshTimestamp(r *http.Request) bool {
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	skew := clock.Now().Sub(time.Unix(timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+
+	if skew > clockSkewWarningThreshold && clockSkewWarned.CompareAndSwap(false, true) {
+		logWarning(context.Background(), "Local time differs from Slack's by %s, the clock may be wrong.", skew.Round(time.Second))
+	}
+
+	return timestampTolerance == 0 || skew <= timestampTolerance
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	proxy "github.com/bharel/SlackFunctionsProxy"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newCertManager creates the ACME certificate manager of the configuration,
+// nil if ACME_DOMAINS isn't set
+func newCertManager(config proxy.Config) *autocert.Manager {
+	if config.ACMEDomains == "" {
+		return nil
+	}
+
+	var hosts []string
+	for _, domain := range strings.Split(config.ACMEDomains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			hosts = append(hosts, domain)
+		}
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Email:      config.ACMEEmail,
+	}
+
+	// Certificates must survive restarts, or the ACME rate limits are quickly hit
+	// (LoadConfig requires either cache)
+	if config.ACMECacheBucket != "" {
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			log.Fatalf("storage.NewClient: %v\n", err)
+		}
+		m.Cache = &gcsCertCache{bucket: client.Bucket(config.ACMECacheBucket)}
+	} else {
+		m.Cache = autocert.DirCache(config.ACMECacheDir)
+	}
+
+	return m
+}
+
+// serveACMEChallenges serves the HTTP-01 challenges on ACME_HTTP_PORT, redirecting other requests to HTTPS.
+// Not needed when port 443 is reachable, as TLS-ALPN-01 challenges are answered by the TLS listener.
+func serveACMEChallenges(m *autocert.Manager, port string) {
+	if port == "" {
+		return
+	}
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           m.HTTPHandler(nil),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("server.ListenAndServe: %v\n", err)
+		}
+	}()
+}
+
+// gcsCertCache stores the certificates in a Cloud Storage bucket, shared by all instances
+type gcsCertCache struct {
+	bucket *storage.BucketHandle
+}
+
+func (c *gcsCertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := c.bucket.Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (c *gcsCertCache) Put(ctx context.Context, key string, data []byte) error {
+	w := c.bucket.Object(key).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (c *gcsCertCache) Delete(ctx context.Context, key string) error {
+	err := c.bucket.Object(key).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
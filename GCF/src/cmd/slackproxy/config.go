@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	proxy "github.com/bharel/SlackFunctionsProxy"
+)
+
+// configFlags collects the --set NAME=VALUE flags
+type configFlags map[string]string
+
+func (c configFlags) String() string {
+	return ""
+}
+
+func (c configFlags) Set(value string) error {
+	name, v, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("must be of the form NAME=VALUE")
+	}
+	c[name] = v
+	return nil
+}
+
+// configure parses the configuration flags of the command, passing them to the proxy
+func configure(name string, args []string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	file := flags.String("config", "", "Configuration file, either a JSON object or NAME=value lines, sets CONFIG_FILE")
+	values := configFlags{}
+	flags.Var(values, "set", "Configuration value of the form NAME=VALUE, taking precedence over the environment. Repeatable")
+	flags.Parse(args)
+
+	if *file != "" {
+		values["CONFIG_FILE"] = *file
+	}
+	proxy.Configure(proxy.Config{Values: values})
+}
+
+// loadConfig resolves the typed configuration from all sources, exiting with every invalid setting
+func loadConfig() proxy.Config {
+	config, err := proxy.LoadConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return config
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bharel/SlackFunctionsProxy/slackfixture"
+)
+
+// loadResults collects the outcome of the load test requests
+type loadResults struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    int
+	dropped   int
+}
+
+// loadtest fires signed synthetic events at a proxy, and reports latency percentiles and error rates.
+func loadtest(args []string) {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	target := flags.String("target", "http://localhost:8080/", "URL of the proxy")
+	rps := flags.Int("rps", 100, "Requests per second")
+	duration := flags.Duration("duration", time.Minute, "Duration of the test")
+	secret := flags.String("secret", os.Getenv("SLACK_SIGNING_SECRET"), "Signing secret of the requests, defaults to SLACK_SIGNING_SECRET")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout of each request")
+	maxInFlight := flags.Int("max-in-flight", 1000, "Maximum concurrent requests, requests beyond it are dropped")
+	flags.Parse(args)
+
+	if *rps <= 0 || *duration <= 0 || *maxInFlight <= 0 {
+		log.Fatalln("--rps, --duration and --max-in-flight must be positive.")
+	}
+	if *secret == "" {
+		log.Fatalln("--secret or SLACK_SIGNING_SECRET must be set.")
+	}
+
+	fixture, _ := slackfixture.Get("message")
+	var payload map[string]any
+	if err := json.Unmarshal(fixture.Body, &payload); err != nil {
+		log.Fatalf("Failed decoding the synthetic event: %v\n", err)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	results := &loadResults{statuses: map[int]int{}}
+	inFlight := make(chan struct{}, *maxInFlight)
+	var seq atomic.Int64
+	var wg sync.WaitGroup
+
+	log.Printf("Sending %d requests per second to %s for %s.", *rps, *target, *duration)
+
+	ticker := time.NewTicker(time.Second / time.Duration(*rps))
+	defer ticker.Stop()
+	deadline := time.After(*duration)
+	start := time.Now()
+
+loop:
+	for {
+		select {
+		case <-deadline:
+			break loop
+		case <-ticker.C:
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			results.mu.Lock()
+			results.dropped++
+			results.mu.Unlock()
+			continue
+		}
+
+		// Unique event ids, so the proxy doesn't drop them as duplicates
+		event := make(map[string]any, len(payload))
+		for k, v := range payload {
+			event[k] = v
+		}
+		event["event_id"] = fmt.Sprintf("EvLOAD%010d", seq.Add(1))
+		body, _ := json.Marshal(event)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			results.send(client, *target, *secret, body)
+		}()
+	}
+
+	wg.Wait()
+	results.report(os.Stdout, time.Since(start))
+}
+
+// send sends a single signed event, recording its outcome
+func (res *loadResults) send(client *http.Client, target, secret string, body []byte) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("Invalid --target: %v\n", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	slackfixture.Sign(req, body, secret, time.Now())
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if err != nil {
+		res.errors++
+		return
+	}
+	res.latencies = append(res.latencies, latency)
+	res.statuses[resp.StatusCode]++
+}
+
+// report writes the latency percentiles and error rates
+func (res *loadResults) report(w io.Writer, elapsed time.Duration) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+
+	total := len(res.latencies) + res.errors
+	fmt.Fprintf(w, "Requests:  %d in %s (%.1f/s)\n", total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+	if total == 0 {
+		return
+	}
+
+	failed := res.errors
+	for status, count := range res.statuses {
+		if status < 200 || status >= 300 {
+			failed += count
+		}
+	}
+	fmt.Fprintf(w, "Errors:    %d (%.2f%%), of which %d transport errors\n", failed, 100*float64(failed)/float64(total), res.errors)
+	if res.dropped > 0 {
+		fmt.Fprintf(w, "Dropped:   %d, over the in-flight limit\n", res.dropped)
+	}
+
+	statuses := make([]int, 0, len(res.statuses))
+	for status := range res.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "Status %d: %d\n", status, res.statuses[status])
+	}
+
+	if len(res.latencies) == 0 {
+		return
+	}
+	sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+	percentile := func(p float64) time.Duration {
+		return res.latencies[int(p*float64(len(res.latencies)-1))].Round(time.Microsecond)
+	}
+	fmt.Fprintf(w, "Latency:   p50 %s, p90 %s, p99 %s, max %s\n",
+		percentile(0.5), percentile(0.9), percentile(0.99), res.latencies[len(res.latencies)-1].Round(time.Microsecond))
+}
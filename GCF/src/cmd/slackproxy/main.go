@@ -0,0 +1,112 @@
+// Command slackproxy runs the proxy as a standalone HTTP server,
+// for long-running deployments on VMs or containers.
+//
+// Usage:
+//
+//	slackproxy [...]             Serve the proxy, see slackproxy -h
+//	slackproxy loadtest [...]    Fire signed synthetic events at a proxy, see slackproxy loadtest -h
+//	slackproxy redrive [...]     Republish dead-lettered messages, see slackproxy redrive -h
+//	slackproxy socketmode [...]  Receive events over Socket Mode rather than serving HTTP
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	proxy "github.com/bharel/SlackFunctionsProxy"
+)
+
+func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "loadtest":
+			loadtest(os.Args[2:])
+			return
+		case "redrive":
+			redrive(os.Args[2:])
+			return
+		case "socketmode":
+			configure("socketmode", os.Args[2:])
+			socketMode()
+			return
+		default:
+			log.Fatalf("Unknown command: %s\n", os.Args[1])
+		}
+	}
+
+	configure("slackproxy", os.Args[1:])
+	serve()
+}
+
+// serve runs the proxy server
+func serve() {
+	config := loadConfig()
+
+	// Serve HTTPS directly if ACME is configured
+	certManager := newCertManager(config)
+
+	// Use PORT, or default to 8080 (443 over HTTPS).
+	port := "8080"
+	if certManager != nil {
+		port = "443"
+	}
+	if config.Port != "" {
+		port = config.Port
+	}
+
+	// Fail fast on invalid configuration, including the steps and hooks of the plugins
+	loadPlugins(config.Plugins)
+	proxy.Setup()
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", proxy.DebugHandler())
+	mux.HandleFunc("/", proxy.Proxy)
+
+	server := newServer(":"+port, mux, config)
+
+	// Sidecar deployments serve on a Unix socket, with TLS terminated by the sidecar
+	if config.UnixSocket != "" {
+		// Closing the listener on shutdown removes the socket
+		listener := listenUnix(config.UnixSocket, config.UnixSocketMode)
+		log.Printf("Listening on %s.", config.UnixSocket)
+		runServer(server, listener, false, config.ShutdownTimeout)
+		return
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("net.Listen: %v\n", err)
+	}
+
+	if certManager != nil {
+		serveACMEChallenges(certManager, config.ACMEHTTPPort)
+		server.TLSConfig = certManager.TLSConfig()
+
+		log.Printf("Listening on port %s over HTTPS.", port)
+		runServer(server, listener, true, config.ShutdownTimeout)
+		return
+	}
+
+	log.Printf("Listening on port %s.", port)
+	runServer(server, listener, false, config.ShutdownTimeout)
+}
+
+// socketMode runs the Socket Mode bridge until interrupted
+func socketMode() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	loadPlugins(loadConfig().Plugins)
+
+	log.Println("Connecting using Socket Mode.")
+	if err := proxy.ServeSocketMode(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("proxy.ServeSocketMode: %v\n", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"plugin"
+	"strings"
+)
+
+// pluginRegister is the symbol plugins export, registering their hooks and transform steps
+const pluginRegister = "Register"
+
+// loadPlugins opens the Go plugins of the comma-separated paths of PLUGINS, and calls their Register function.
+// Plugins register pipeline hooks with proxy.Use, and transform steps with proxy.RegisterTransformer,
+// so they must be built with -buildmode=plugin against the same proxy version as the server.
+// Must be called before the proxy is set up.
+func loadPlugins(paths string) {
+	if paths == "" {
+		return
+	}
+
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		p, err := plugin.Open(path)
+		if err != nil {
+			log.Fatalf("Failed loading plugin %s: %v\n", path, err)
+		}
+
+		symbol, err := p.Lookup(pluginRegister)
+		if err != nil {
+			log.Fatalf("Plugin %s doesn't export %s: %v\n", path, pluginRegister, err)
+		}
+
+		register, ok := symbol.(func() error)
+		if !ok {
+			log.Fatalf("Plugin %s exports %s as %T, expected func() error.\n", path, pluginRegister, symbol)
+		}
+		if err := register(); err != nil {
+			log.Fatalf("Plugin %s failed registering: %v\n", path, err)
+		}
+		log.Printf("Loaded plugin %s.", path)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Attribute marking redriven messages, holding the time of the redrive
+const attrRedrivenAt = "redriven_at"
+
+// Prefix of the attributes Pub/Sub adds to dead-lettered messages
+const deadLetterAttributePrefix = "CloudPubSubDeadLetter"
+
+// Attributes letting their holder act as the app, redacted from the dry-run logs
+var secretAttributes = map[string]bool{"response_url": true, "trigger_id": true}
+
+// redriveFilter selects the messages to redrive
+type redriveFilter struct {
+	eventTypes   map[string]bool
+	since, until time.Time
+}
+
+// match returns true if a message with the data, published at the given time, should be redriven
+func (f *redriveFilter) match(data []byte, published time.Time) bool {
+	if !f.since.IsZero() && published.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && published.After(f.until) {
+		return false
+	}
+	if len(f.eventTypes) == 0 {
+		return true
+	}
+
+	eventType, payloadType := payloadTypes(data)
+	return f.eventTypes[eventType] || f.eventTypes[payloadType]
+}
+
+// payloadTypes returns the Events API event type and the payload type of the data, empty if missing
+func payloadTypes(data []byte) (string, string) {
+	var payload struct {
+		Type  string `json:"type"`
+		Event struct {
+			Type string `json:"type"`
+		} `json:"event"`
+	}
+	json.Unmarshal(data, &payload)
+	return payload.Event.Type, payload.Type
+}
+
+// redrive republishes dead-lettered messages to the primary topic
+func redrive(args []string) {
+	flags := flag.NewFlagSet("redrive", flag.ExitOnError)
+	project := flags.String("project", os.Getenv("GCP_PROJECT"), "GCP project, defaults to GCP_PROJECT")
+	topicName := flags.String("topic", os.Getenv("PUBSUB_TOPIC"), "Topic to republish to, defaults to PUBSUB_TOPIC")
+	subscription := flags.String("subscription", "", "Dead-letter subscription to read from")
+	spool := flags.String("gcs", "", "GCS spool to read from instead, as gs://bucket/prefix, holding an object per message")
+	eventTypes := flags.String("event-types", "", "Comma separated event types to redrive, all if unset")
+	since := flags.String("since", "", "Only redrive messages published since, as RFC 3339 or a duration ago, e.g. 24h")
+	until := flags.String("until", "", "Only redrive messages published until, as RFC 3339 or a duration ago")
+	idle := flags.Duration("idle", 10*time.Second, "Stop reading a subscription once no new messages arrived for this long")
+	dryRun := flags.Bool("dry-run", false, "Report the messages that would be redriven, without republishing them")
+	flags.Parse(args)
+
+	if *project == "" || *topicName == "" {
+		log.Fatalln("--project and --topic must be set.")
+	}
+	if (*subscription == "") == (*spool == "") {
+		log.Fatalln("Exactly one of --subscription or --gcs must be set.")
+	}
+
+	filter := &redriveFilter{since: parseRedriveTime("--since", *since), until: parseRedriveTime("--until", *until)}
+	if *eventTypes != "" {
+		filter.eventTypes = map[string]bool{}
+		for _, eventType := range strings.Split(*eventTypes, ",") {
+			filter.eventTypes[strings.TrimSpace(eventType)] = true
+		}
+	}
+
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, *project)
+	if err != nil {
+		log.Fatalf("Failed creating a Pub/Sub client: %v\n", err)
+	}
+	defer client.Close()
+
+	topic := client.Topic(*topicName)
+	defer topic.Stop()
+	if exists, err := topic.Exists(ctx); err != nil || !exists {
+		log.Fatalf("Topic %s doesn't exist.\n", *topicName)
+	}
+
+	r := &redriver{topic: topic, filter: filter, dryRun: *dryRun, redrivenAt: time.Now().UTC().Format(time.RFC3339)}
+	if *subscription != "" {
+		r.fromSubscription(ctx, client.Subscription(*subscription), *idle)
+	} else {
+		r.fromSpool(ctx, *spool)
+	}
+
+	action := "Redrove"
+	if *dryRun {
+		action = "Would redrive"
+	}
+	log.Printf("%s %d messages, skipped %d, failed %d.", action, r.redriven.Load(), r.skipped.Load(), r.failed.Load())
+	if r.failed.Load() > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseRedriveTime parses a time flag, either RFC 3339 or a duration ago
+func parseRedriveTime(name, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d)
+	}
+	log.Fatalf("%s must be an RFC 3339 time or a duration.\n", name)
+	return time.Time{}
+}
+
+// redriver republishes the messages matching the filter
+type redriver struct {
+	topic      *pubsub.Topic
+	filter     *redriveFilter
+	dryRun     bool
+	redrivenAt string
+
+	redriven, skipped, failed atomic.Int64
+}
+
+// republish republishes a single message, returning true if it was republished
+func (r *redriver) republish(ctx context.Context, id string, data []byte, attributes map[string]string, published time.Time) bool {
+	if !r.filter.match(data, published) {
+		r.skipped.Add(1)
+		return false
+	}
+
+	// Payloads hold private messages and tokens, so only their type is logged
+	if r.dryRun {
+		eventType, payloadType := payloadTypes(data)
+		if eventType == "" {
+			eventType = payloadType
+		}
+
+		logged := make(map[string]string, len(attributes))
+		for name, value := range attributes {
+			if secretAttributes[name] {
+				value = "[REDACTED]"
+			}
+			logged[name] = value
+		}
+		log.Printf("Would redrive %s (type %q), attributes: %v", id, eventType, logged)
+		r.redriven.Add(1)
+		return false
+	}
+
+	redriven := map[string]string{attrRedrivenAt: r.redrivenAt}
+	for name, value := range attributes {
+		if !strings.HasPrefix(name, deadLetterAttributePrefix) {
+			redriven[name] = value
+		}
+	}
+
+	if _, err := r.topic.Publish(ctx, &pubsub.Message{Data: data, Attributes: redriven}).Get(ctx); err != nil {
+		log.Printf("Failed redriving %s: %v", id, err)
+		r.failed.Add(1)
+		return false
+	}
+	r.redriven.Add(1)
+	return true
+}
+
+// fromSubscription redrives the messages of the subscription until it's idle.
+// Redriven messages are acked, so they leave the dead-letter subscription.
+// Skipped and failed ones are nacked, and stay.
+func (r *redriver) fromSubscription(ctx context.Context, sub *pubsub.Subscription, idle time.Duration) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	lastNew := time.Now()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			mu.Lock()
+			idleFor := time.Since(lastNew)
+			mu.Unlock()
+			if idleFor > idle {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		// Nacked or unacked messages come back, handle each once
+		mu.Lock()
+		if seen[msg.ID] {
+			mu.Unlock()
+			msg.Nack()
+			return
+		}
+		seen[msg.ID] = true
+		lastNew = time.Now()
+		mu.Unlock()
+
+		if r.republish(ctx, msg.ID, msg.Data, msg.Attributes, msg.PublishTime) {
+			msg.Ack()
+		} else {
+			msg.Nack()
+		}
+	})
+	if err != nil {
+		log.Fatalf("Failed reading the subscription: %v\n", err)
+	}
+}
+
+// fromSpool redrives the objects under a GCS prefix, each holding the data of a message.
+// Object metadata is carried over as attributes. Redriven objects are kept, so delete them once done.
+func (r *redriver) fromSpool(ctx context.Context, spool string) {
+	bucketName, prefix, ok := strings.Cut(strings.TrimPrefix(spool, "gs://"), "/")
+	if !strings.HasPrefix(spool, "gs://") || !ok || bucketName == "" {
+		log.Fatalln("--gcs must be formatted as gs://bucket/prefix.")
+	}
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("Failed creating a storage client: %v\n", err)
+	}
+	defer client.Close()
+
+	bucket := client.Bucket(bucketName)
+	objects := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := objects.Next()
+		if err == iterator.Done {
+			return
+		}
+		if err != nil {
+			log.Fatalf("Failed listing %s: %v\n", spool, err)
+		}
+
+		data, err := readObject(ctx, bucket.Object(attrs.Name))
+		if err != nil {
+			log.Printf("Failed reading %s: %v", attrs.Name, err)
+			r.failed.Add(1)
+			continue
+		}
+		r.republish(ctx, attrs.Name, data, attrs.Metadata, attrs.Created)
+	}
+}
+
+// readObject reads the content of a GCS object
+func readObject(ctx context.Context, object *storage.ObjectHandle) ([]byte, error) {
+	reader, err := object.NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening: %w", err)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	proxy "github.com/bharel/SlackFunctionsProxy"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newServer creates the HTTP server of the handler, with the server settings of the configuration
+func newServer(addr string, handler http.Handler, config proxy.Config) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       config.ServerReadTimeout,
+		WriteTimeout:      config.ServerWriteTimeout,
+		MaxHeaderBytes:    config.ServerMaxHeaderBytes,
+	}
+
+	// HTTP/2 without TLS, for load balancers terminating TLS and speaking HTTP/2 to the backend
+	if config.H2C {
+		server.Handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	return server
+}
+
+// Time allowed for in-flight requests to complete on shutdown
+const defaultShutdownTimeout = 10 * time.Second
+
+// runServer serves on the listener until SIGTERM or interrupt, then drains the in-flight requests
+// and flushes the work left off the request path.
+// Notifies systemd once ready and when stopping, reloads the configuration on SIGHUP and flushes on SIGUSR1.
+// The timeout bounds the shutdown, defaulting to 10s if zero.
+func runServer(server *http.Server, listener net.Listener, tls bool, timeout time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	go reloadOnHangup()
+	go flushOnSignal(timeout)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+
+		sdNotify("STOPPING=1")
+		log.Println("Draining in-flight requests.")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("server.Shutdown: %v", err)
+		}
+		if err := proxy.Flush(shutdownCtx); err != nil {
+			log.Printf("Failed flushing: %v", err)
+		}
+	}()
+
+	sdNotify("READY=1")
+
+	var err error
+	if tls {
+		err = server.ServeTLS(listener, "", "")
+	} else {
+		err = server.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("server.Serve: %v\n", err)
+	}
+	<-done
+}
+
+// reloadOnHangup reloads the configuration on every SIGHUP, e.g. of systemctl reload
+func reloadOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		sdNotify("RELOADING=1")
+		if err := proxy.Reload(); err != nil {
+			log.Printf("Failed reloading the configuration: %v", err)
+		}
+		sdNotify("READY=1")
+	}
+}
+
+// flushOnSignal flushes the work left off the request path on every SIGUSR1,
+// letting operators check nothing is left before stopping the instance
+func flushOnSignal(timeout time.Duration) {
+	flushes := make(chan os.Signal, 1)
+	signal.Notify(flushes, syscall.SIGUSR1)
+
+	for range flushes {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := proxy.Flush(ctx); err != nil {
+			log.Printf("Failed flushing: %v", err)
+		} else {
+			log.Println("Flushed the work left off the request path.")
+		}
+		cancel()
+	}
+}
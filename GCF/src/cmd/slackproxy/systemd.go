@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+)
+
+// sdNotify sends the state to systemd, e.g. READY=1, if running as a Type=notify service
+// https://www.freedesktop.org/software/systemd/man/sd_notify.html
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+
+	// Abstract sockets are prefixed with @
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if socket[0] == '@' {
+		addr.Name = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		log.Printf("Failed notifying systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("Failed notifying systemd: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strconv"
+)
+
+const defaultUnixSocketMode = 0o660
+
+// listenUnix listens on the Unix socket at the path, which is removed once the listener is closed.
+// The mode holds the octal permissions of the socket, validated by LoadConfig, defaulting to 0660 if empty.
+func listenUnix(path, socketMode string) net.Listener {
+	removeStaleSocket(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		log.Fatalf("net.Listen: %v\n", err)
+	}
+
+	// Restricts the socket to the owner and group by default, e.g. of the nginx or Envoy sidecar
+	mode := fs.FileMode(defaultUnixSocketMode)
+	if socketMode != "" {
+		m, _ := strconv.ParseUint(socketMode, 8, 32)
+		mode = fs.FileMode(m)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		log.Fatalf("os.Chmod: %v\n", err)
+	}
+
+	return listener
+}
+
+// removeStaleSocket removes the socket left at the path by a previous process that didn't shut down cleanly.
+// Exits if another process is still listening on it, or if the path isn't a socket.
+func removeStaleSocket(path string) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("os.Stat: %v\n", err)
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		log.Fatalf("%s exists and isn't a socket.\n", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		log.Fatalf("%s is in use by another process.\n", path)
+	}
+	if err := os.Remove(path); err != nil {
+		log.Fatalf("os.Remove: %v\n", err)
+	}
+}
@@ -0,0 +1,522 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of the configuration values, as shown by /debug/config
+const (
+	configSourceSet     = "set"
+	configSourceRemote  = "remote"
+	configSourceEnv     = "env"
+	configSourceKMS     = "kms"
+	configSourceVault   = "vault"
+	configSourceFile    = "file"
+	configSourceProfile = "profile"
+	configSourceDefault = "default"
+)
+
+// Parts of the names of variables holding secrets, whose values are redacted.
+// Exporter headers carry API keys.
+var secretConfigNames = []string{
+	"SECRET", "TOKEN", "PASSWORD", "KEY", "DSN", "CREDENTIALS", "OTLP_HEADERS",
+}
+
+// Suffix of the names of URL variables, redacted as well: webhook and backend URLs often embed their token
+const secretConfigURLSuffix = "_URL"
+
+// Config holds the configuration values set by programs embedding the proxy.
+// Each field sets the variable named by its env tag, and zero values leave it to the other sources.
+type Config struct {
+	GCPProject         string `env:"GCP_PROJECT"`
+	SlackSigningSecret string `env:"SLACK_SIGNING_SECRET"`
+	PubSubTopic        string `env:"PUBSUB_TOPIC"`
+	Backend            string `env:"BACKEND"`
+	WebhookURL         string `env:"WEBHOOK_URL"`
+	Environment        string `env:"ENVIRONMENT"`
+	ConfigFile         string `env:"CONFIG_FILE"`
+	LogFormat          string `env:"LOG_FORMAT"`
+
+	PublishAttempts int           `env:"PUBLISH_ATTEMPTS"`
+	PublishBudget   time.Duration `env:"PUBLISH_BUDGET"`
+	RequestTimeout  time.Duration `env:"REQUEST_TIMEOUT"`
+	DedupWindow     time.Duration `env:"DEDUP_WINDOW"`
+	MaxEventAge     time.Duration `env:"MAX_EVENT_AGE"`
+
+	// Standalone server
+	Port                 string        `env:"PORT"`
+	H2C                  bool          `env:"H2C"`
+	UnixSocket           string        `env:"UNIX_SOCKET"`
+	UnixSocketMode       string        `env:"UNIX_SOCKET_MODE"`
+	ServerReadTimeout    time.Duration `env:"SERVER_READ_TIMEOUT"`
+	ServerWriteTimeout   time.Duration `env:"SERVER_WRITE_TIMEOUT"`
+	ServerMaxHeaderBytes int           `env:"SERVER_MAX_HEADER_BYTES"`
+	ShutdownTimeout      time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	Plugins              string        `env:"PLUGINS"`
+	ACMEDomains          string        `env:"ACME_DOMAINS"`
+	ACMEEmail            string        `env:"ACME_EMAIL"`
+	ACMECacheDir         string        `env:"ACME_CACHE_DIR"`
+	ACMECacheBucket      string        `env:"ACME_CACHE_BUCKET"`
+	ACMEHTTPPort         string        `env:"ACME_HTTP_PORT"`
+
+	// Other variables by env var name, e.g. {"ENRICH": "true"}, overridden by the fields above.
+	// Also sets the variables whose zero value is meaningful, e.g. {"REQUEST_TIMEOUT": "0"}.
+	Values map[string]string
+}
+
+// values returns the variables set by the config, by env var name
+func (c Config) values() configValues {
+	values := configValues{}
+	for name, value := range c.Values {
+		values[name] = value
+	}
+
+	fields := reflect.ValueOf(c)
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Type().Field(i).Tag.Get("env")
+		if name == "" || fields.Field(i).IsZero() {
+			continue
+		}
+
+		switch field := fields.Field(i).Interface().(type) {
+		case string:
+			values[name] = field
+		case bool:
+			values[name] = strconv.FormatBool(field)
+		case int:
+			values[name] = strconv.Itoa(field)
+		case time.Duration:
+			values[name] = field.String()
+		}
+	}
+	return values
+}
+
+// LoadConfig reads all configuration sources, and resolves the typed fields from them as Setup does,
+// leaving unset fields zero and Values empty.
+// Returns the configuration errors found so far, all at once, along with those of the typed fields.
+func LoadConfig() (Config, error) {
+	loadConfigSources()
+
+	var c Config
+	problems := append([]string(nil), configErrors...)
+
+	fields := reflect.ValueOf(&c).Elem()
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Type().Field(i).Tag.Get("env")
+		value := ""
+		if name != "" {
+			value = getenv(name)
+		}
+		if value == "" {
+			continue
+		}
+
+		switch field := fields.Field(i); field.Interface().(type) {
+		case string:
+			field.SetString(value)
+		case bool:
+			if value != "true" && value != "false" {
+				problems = append(problems, fmt.Sprintf("%s must be true or false.", name))
+			}
+			field.SetBool(value == "true")
+		case int:
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				problems = append(problems, fmt.Sprintf("%s must be a positive integer.", name))
+			}
+			field.SetInt(int64(n))
+		case time.Duration:
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				problems = append(problems, fmt.Sprintf("%s must be a non-negative duration.", name))
+			}
+			field.SetInt(int64(d))
+		}
+	}
+
+	if c.UnixSocketMode != "" {
+		if m, err := strconv.ParseUint(c.UnixSocketMode, 8, 32); err != nil || m > 0o777 {
+			problems = append(problems, "UNIX_SOCKET_MODE must be octal permissions, e.g. 0660.")
+		}
+	}
+	if c.ACMEDomains != "" && c.ACMECacheDir == "" && c.ACMECacheBucket == "" {
+		problems = append(problems, "ACME_CACHE_DIR or ACME_CACHE_BUCKET must be set when ACME_DOMAINS is set.")
+	}
+	if c.ACMEDomains != "" && c.UnixSocket != "" {
+		problems = append(problems, "ACME_DOMAINS can't be set when UNIX_SOCKET is set.")
+	}
+
+	if len(problems) != 0 {
+		return c, fmt.Errorf("invalid configuration:\n- %s", strings.Join(problems, "\n- "))
+	}
+	return c, nil
+}
+
+// configValues holds configuration values by env var name, e.g. {"PUBSUB_TOPIC": "slack-events"}
+type configValues map[string]string
+
+var (
+	// Values set by Configure, taking precedence over the environment
+	configOverrides configValues
+
+	// Values of CONFIG_FILE, used for the variables missing from the environment
+	configFile configValues
+
+	// Defaults of the ENVIRONMENT profile, used for the variables missing from all sources
+	configProfile configValues
+
+	// Configuration errors found by setup, reported all at once
+	configErrors []string
+
+	// Serializes reloads, which reuse configErrors
+	reloadMu sync.Mutex
+
+	// Values of the remote configuration document, taking precedence over the environment
+	configRemote configValues
+
+	// Secrets decrypted from the <NAME>_ENC variables, used for the variables missing from the environment
+	configDecrypted configValues
+
+	// Secrets read from Vault, used for the variables missing from the environment and decrypted ones
+	configVault configValues
+
+	// Guards the sources and configSources, as Reload replaces them at runtime
+	configMu sync.Mutex
+
+	// Loads the sources once, for LoadConfig and Setup, whichever runs first
+	configSourcesOnce sync.Once
+
+	// Sources of the variables read so far, by name
+	configSources = map[string]string{}
+)
+
+// Configure sets configuration values, taking precedence over all other sources.
+// Must be called before Setup.
+func Configure(c Config) {
+	if configOverrides == nil {
+		configOverrides = configValues{}
+	}
+	for name, value := range c.values() {
+		configOverrides[name] = value
+	}
+}
+
+// getenv returns the configuration value of the variable, empty if unset
+func getenv(name string) string {
+	value, _ := lookupenv(name)
+	return value
+}
+
+// lookupenv returns the configuration value of the variable, and whether it's set.
+// Set values take precedence over the remote configuration, then the environment,
+// then KMS-decrypted values, then Vault, then CONFIG_FILE, then the defaults of the ENVIRONMENT profile.
+func lookupenv(name string) (string, bool) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	value, source := resolveConfig(name)
+	configSources[name] = source
+	return value, source != configSourceDefault
+}
+
+// resolveConfig returns the value of the variable and its source.
+// Must be called with configMu held.
+func resolveConfig(name string) (string, string) {
+	if value, ok := configOverrides[name]; ok {
+		return value, configSourceSet
+	}
+	if value, ok := configRemote[name]; ok {
+		return value, configSourceRemote
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value, configSourceEnv
+	}
+	if value, ok := configDecrypted[name]; ok {
+		return value, configSourceKMS
+	}
+	if value, ok := configVault[name]; ok {
+		return value, configSourceVault
+	}
+	if value, ok := configFile[name]; ok {
+		return value, configSourceFile
+	}
+	if value, ok := configProfile[name]; ok {
+		return value, configSourceProfile
+	}
+	return "", configSourceDefault
+}
+
+// loadConfigSources reads the configuration sources beyond the environment and the set values, once:
+// CONFIG_FILE, the ENVIRONMENT profile, Vault, the KMS-encrypted values and the remote configuration.
+// Reload reads them again.
+func loadConfigSources() {
+	configSourcesOnce.Do(func() {
+		loadConfigFile()
+		loadProfile()
+
+		// Trust the CAs of TLS-inspecting egress proxies, before creating any client
+		setupEgressCA()
+
+		// Read the secrets and the remote configuration
+		loadVaultSecrets()
+		decryptConfigSecrets()
+		loadRemoteConfig()
+	})
+}
+
+// loadConfigFile reads the file at CONFIG_FILE, either a JSON object or NAME=value lines
+func loadConfigFile() {
+	path := getenv("CONFIG_FILE")
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		configErrorf("Failed reading CONFIG_FILE: %s.", err.Error())
+		return
+	}
+
+	sections, err := parseConfigFile(data)
+	if err != nil {
+		configErrorf("Invalid CONFIG_FILE: %s.", err.Error())
+		return
+	}
+
+	configMu.Lock()
+	configFile = sections[""]
+	configMu.Unlock()
+
+	// The section of the environment overrides the top-level values, and ENVIRONMENT may be set by either
+	environment := getenv("ENVIRONMENT")
+	if environment == "" {
+		return
+	}
+
+	config := configValues{}
+	for name, value := range sections[""] {
+		config[name] = value
+	}
+	for name, value := range sections[environment] {
+		config[name] = value
+	}
+
+	configMu.Lock()
+	configFile = config
+	configMu.Unlock()
+}
+
+// Reload re-reads all configuration sources, and applies the settings that can change at runtime:
+// the feature flags, payload attributes, filters, sampling rules, priorities, tenant registry, topic template, channel routes
+// and routing rules.
+// Other settings take effect on restart. Returns the configuration errors found, if any, which are reported as well.
+func Reload() error {
+	Setup()
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	configErrors = nil
+	loadConfigFile()
+	loadProfile()
+	loadVaultSecrets()
+	decryptConfigSecrets()
+	loadRemoteConfig()
+	if err := applyLiveConfig(); err != nil {
+		reportError(err, nil)
+		return err
+	}
+	return nil
+}
+
+// applyLiveConfig applies the settings that can change at runtime, returning the configuration errors found.
+// In-flight requests finish with the previous settings. Invalid rules are skipped. Must be called with reloadMu held.
+func applyLiveConfig() error {
+	live := &liveConfig{}
+	for _, setup := range liveSetups {
+		setup(live)
+	}
+	currentLiveConfig.Store(live)
+
+	setLocalFlags(parseFlags(getenv("FLAGS")))
+
+	logInfo(context.Background(), "Applied configuration. Feature flags: %s.", formatFlags(flagsSnapshot()))
+
+	if len(configErrors) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("invalid configuration: %s", strings.Join(configErrors, " "))
+	configErrors = nil
+	return err
+}
+
+// parseConfigFile parses a JSON object of values, or NAME=value lines skipping # comments.
+// Returns the values by environment section, where the top-level values are under "".
+// Sections are under "profiles" in JSON, e.g. {"profiles": {"dev": {...}}}, and start with [dev] lines otherwise.
+func parseConfigFile(data []byte) (map[string]configValues, error) {
+	if data = bytes.TrimSpace(data); bytes.HasPrefix(data, []byte("{")) {
+		var values map[string]any
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+
+		var profiles map[string]map[string]any
+		if raw, ok := values["profiles"]; ok {
+			delete(values, "profiles")
+			encoded, _ := json.Marshal(raw)
+			if err := json.Unmarshal(encoded, &profiles); err != nil {
+				return nil, fmt.Errorf("profiles must be an object of objects")
+			}
+		}
+
+		sections := map[string]configValues{"": configFromMap(values)}
+		for environment, values := range profiles {
+			sections[environment] = configFromMap(values)
+		}
+		return sections, nil
+	}
+
+	sections := map[string]configValues{"": {}}
+	section := sections[""]
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			environment := strings.TrimSpace(text[1 : len(text)-1])
+			if sections[environment] == nil {
+				sections[environment] = configValues{}
+			}
+			section = sections[environment]
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("line %d isn't of the form NAME=value", line)
+		}
+		section[strings.TrimSpace(name)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return sections, scanner.Err()
+}
+
+// configFromMap converts decoded values to configuration values, formatting non-strings
+func configFromMap(values map[string]any) configValues {
+	config := make(configValues, len(values))
+	for name, value := range values {
+		if s, ok := value.(string); ok {
+			config[name] = s
+		} else {
+			config[name] = fmt.Sprint(value)
+		}
+	}
+	return config
+}
+
+// configErrorf records a configuration error, reported along with all others once setup ends
+func configErrorf(format string, args ...any) {
+	configErrors = append(configErrors, fmt.Sprintf(format, args...))
+}
+
+// checkConfig panics listing all the configuration errors, if any
+func checkConfig() {
+	if len(configErrors) == 0 {
+		return
+	}
+	log.Panicf("Invalid configuration:\n- %s", strings.Join(configErrors, "\n- "))
+}
+
+// configDuration returns the duration of the variable, or the fallback if unset.
+// Must be positive, or non-negative if zero is allowed.
+func configDuration(name string, fallback time.Duration, allowZero bool) time.Duration {
+	value := getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	switch {
+	case err == nil && (d > 0 || d == 0 && allowZero):
+		return d
+	case allowZero:
+		configErrorf("%s must be a non-negative duration.", name)
+	default:
+		configErrorf("%s must be a positive duration.", name)
+	}
+	return fallback
+}
+
+// configInt returns the positive integer of the variable, or the fallback if unset
+func configInt(name string, fallback int) int {
+	value := getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		configErrorf("%s must be a positive integer.", name)
+		return fallback
+	}
+	return n
+}
+
+// configEntry is a resolved configuration value, as shown by /debug/config
+type configEntry struct {
+	Value  string `json:"value,omitempty"`
+	Source string `json:"source"`
+}
+
+// serveConfig responds with the variables read by the proxy, their redacted values and sources.
+// Variables that weren't set show their source as default.
+func serveConfig(w http.ResponseWriter, r *http.Request) {
+	configMu.Lock()
+	entries := make(map[string]configEntry, len(configSources))
+	for name := range configSources {
+		value, source := resolveConfig(name)
+		entries[name] = configEntry{Value: redactConfig(name, value), Source: source}
+	}
+	configMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// redactConfig redacts the value of secret variables, and the passwords of URLs
+func redactConfig(name, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	for _, secret := range secretConfigNames {
+		if strings.Contains(name, secret) {
+			return redactedValue
+		}
+	}
+	if strings.HasSuffix(name, secretConfigURLSuffix) {
+		return redactedValue
+	}
+
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
@@ -0,0 +1,20 @@
+// Package consumer helps services consuming the messages published by the proxy.
+package consumer
+
+import (
+	"context"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Message attributes set by the proxy
+const (
+	// AttrIdempotencyKey identifies the event across Slack's retries
+	AttrIdempotencyKey = "idempotency_key"
+
+	// AttrBodySHA256 is the hex SHA-256 of the raw request body, as signed by Slack
+	AttrBodySHA256 = "body_sha256"
+)
+
+// Handler processes a message
+type Handler func(ctx context.Context, msg *pubsub.Message) error
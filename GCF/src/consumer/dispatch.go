@@ -0,0 +1,135 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// AttrPayloadFormat tells converted slash commands ("command") and interactivity payloads ("interactivity") apart
+const AttrPayloadFormat = "payload_format"
+
+// Dispatcher routes the proxy's messages to the handlers registered by event type, slash command or action id
+type Dispatcher struct {
+	events   map[string]Handler
+	commands map[string]Handler
+	actions  map[string]Handler
+	fallback Handler
+}
+
+// NewDispatcher creates a dispatcher without handlers
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		events:   map[string]Handler{},
+		commands: map[string]Handler{},
+		actions:  map[string]Handler{},
+	}
+}
+
+// OnEvent handles the Events API events of the type, e.g. "app_mention",
+// or the payloads of the type, e.g. "view_submission" or "shortcut"
+func (d *Dispatcher) OnEvent(eventType string, handler Handler) {
+	d.events[eventType] = handler
+}
+
+// OnCommand handles the slash command, e.g. "/deploy"
+func (d *Dispatcher) OnCommand(command string, handler Handler) {
+	d.commands[command] = handler
+}
+
+// OnAction handles the block actions of the action id, taking precedence over OnEvent("block_actions")
+func (d *Dispatcher) OnAction(actionID string, handler Handler) {
+	d.actions[actionID] = handler
+}
+
+// Default handles the messages no other handler matches. Unmatched messages are dropped if unset.
+func (d *Dispatcher) Default(handler Handler) {
+	d.fallback = handler
+}
+
+// dispatched holds the fields messages are routed by
+type dispatched struct {
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	Event   struct {
+		Type string `json:"type"`
+	} `json:"event"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+	} `json:"actions"`
+}
+
+// Handle routes the message to its handler
+func (d *Dispatcher) Handle(ctx context.Context, msg *pubsub.Message) error {
+	data, err := Payload(msg)
+	if err != nil {
+		return fmt.Errorf("failed decoding message %s: %w", msg.ID, err)
+	}
+
+	var payload dispatched
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("failed decoding message %s: %w", msg.ID, err)
+	}
+
+	if msg.Attributes[AttrPayloadFormat] == "command" {
+		if handler, ok := d.commands[payload.Command]; ok {
+			return handler(ctx, msg)
+		}
+	}
+	for _, action := range payload.Actions {
+		if handler, ok := d.actions[action.ActionID]; ok {
+			return handler(ctx, msg)
+		}
+	}
+
+	eventType := payload.Event.Type
+	if eventType == "" {
+		eventType = payload.Type
+	}
+	if handler, ok := d.events[eventType]; ok {
+		return handler(ctx, msg)
+	}
+
+	if d.fallback != nil {
+		return d.fallback(ctx, msg)
+	}
+	return nil
+}
+
+// messagePublishedData is the data of the CloudEvents of Pub/Sub triggers
+type messagePublishedData struct {
+	Message struct {
+		ID          string            `json:"messageId"`
+		Data        []byte            `json:"data"`
+		Attributes  map[string]string `json:"attributes"`
+		PublishTime time.Time         `json:"publishTime"`
+		OrderingKey string            `json:"orderingKey"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// Dispatch returns a CloudEvent function dispatching the messages of a Pub/Sub trigger, e.g.
+//
+//	functions.CloudEvent("HandleSlack", consumer.Dispatch(d))
+//
+// Failed handlers fail the function, so the message is retried if retries are enabled on the trigger.
+func Dispatch(d *Dispatcher) func(ctx context.Context, e event.Event) error {
+	return func(ctx context.Context, e event.Event) error {
+		var data messagePublishedData
+		if err := e.DataAs(&data); err != nil {
+			return fmt.Errorf("failed decoding event %s: %w", e.ID(), err)
+		}
+
+		return d.Handle(ctx, &pubsub.Message{
+			ID:          data.Message.ID,
+			Data:        data.Message.Data,
+			Attributes:  data.Message.Attributes,
+			PublishTime: data.Message.PublishTime,
+			OrderingKey: data.Message.OrderingKey,
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package consumer
+
+import (
+	"errors"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// AttrPayloadEncoding is set to "protobuf" on messages published with PAYLOAD_ENCODING=protobuf
+const AttrPayloadEncoding = "payload_encoding"
+
+// Field number of the raw_json field of the SlackEvent message
+const fieldRawJSON protowire.Number = 15
+
+// Payload returns the JSON payload of the message, whether encoded as JSON or as a SlackEvent message.
+// Consumers decoding SlackEvent with code generated from proto/slack_event.proto don't need it.
+func Payload(msg *pubsub.Message) ([]byte, error) {
+	if msg.Attributes[AttrPayloadEncoding] != "protobuf" {
+		return msg.Data, nil
+	}
+
+	b := msg.Data
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == fieldRawJSON && typ == protowire.BytesType {
+			raw, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			return raw, nil
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil, errors.New("message has no raw_json field")
+}
@@ -0,0 +1,34 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Message attributes holding the time the proxy received the request, and the time after which the event is stale
+const (
+	AttrReceivedAt = "received_at"
+	AttrExpiresAt  = "expires_at"
+)
+
+// SkipStale wraps a handler so events past their expires_at attribute, or received more than maxAge ago, are skipped.
+// A maxAge of 0 only honors expires_at. Useful for slash commands, whose response_url expires after 30 minutes.
+func SkipStale(maxAge time.Duration, handler Handler) Handler {
+	return func(ctx context.Context, msg *pubsub.Message) error {
+		now := time.Now()
+
+		if expires, err := time.Parse(time.RFC3339Nano, msg.Attributes[AttrExpiresAt]); err == nil && now.After(expires) {
+			return nil
+		}
+
+		if maxAge > 0 {
+			if received, err := time.Parse(time.RFC3339Nano, msg.Attributes[AttrReceivedAt]); err == nil && now.Sub(received) > maxAge {
+				return nil
+			}
+		}
+
+		return handler(ctx, msg)
+	}
+}
@@ -0,0 +1,86 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/redis/go-redis/v9"
+)
+
+// Store records the idempotency keys of processed messages
+type Store interface {
+	// Claim records the key for the ttl, returning false if it was already recorded
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// AtMostOnce wraps a handler so each event is processed at most once, across redeliveries and Slack's retries.
+// The key is claimed before processing, and not released on failure.
+// Messages without an idempotency key are always processed.
+func AtMostOnce(store Store, ttl time.Duration, handler Handler) Handler {
+	return func(ctx context.Context, msg *pubsub.Message) error {
+		key := msg.Attributes[AttrIdempotencyKey]
+		if key == "" {
+			return handler(ctx, msg)
+		}
+
+		claimed, err := store.Claim(ctx, key, ttl)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return nil
+		}
+
+		return handler(ctx, msg)
+	}
+}
+
+// MemoryStore keeps the keys in-process, suiting a single consumer instance
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+}
+
+// NewMemoryStore creates an empty in-process store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: map[string]time.Time{}}
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if expires, ok := s.keys[key]; ok && now.Before(expires) {
+		return false, nil
+	}
+
+	// Sweep expired keys once the store grows
+	if len(s.keys) >= 10000 {
+		for k, expires := range s.keys {
+			if now.After(expires) {
+				delete(s.keys, k)
+			}
+		}
+	}
+
+	s.keys[key] = now.Add(ttl)
+	return true, nil
+}
+
+// RedisStore keeps the keys in Redis, shared by all consumer instances
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a store keeping the keys under the prefix
+func NewRedisStore(client *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+key, 1, ttl).Result()
+}
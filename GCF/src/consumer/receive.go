@@ -0,0 +1,39 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Receive receives the messages of the subscription until the context is done,
+// acking the messages the handler processed and nacking the ones it failed.
+//
+// On subscriptions with exactly-once delivery, each ack is confirmed before the next message of the stream is handled
+// by that goroutine, so a confirmed message is never redelivered. Ack failures are passed to onAckError if set.
+// Exactly-once delivery doesn't cover Slack's retries, wrap the handler with AtMostOnce for those.
+func Receive(ctx context.Context, sub *pubsub.Subscription, handler Handler, onAckError func(msg *pubsub.Message, err error)) error {
+	cfg, err := sub.Config(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting the subscription config: %w", err)
+	}
+	exactlyOnce := cfg.EnableExactlyOnceDelivery
+
+	return sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		if err := handler(ctx, msg); err != nil {
+			msg.Nack()
+			return
+		}
+
+		if !exactlyOnce {
+			msg.Ack()
+			return
+		}
+
+		// The ack may fail, e.g. if its deadline expired, in which case the message is redelivered
+		if _, err := msg.AckWithResult().Get(ctx); err != nil && onAckError != nil {
+			onAckError(msg, err)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Message attributes holding the response_url and trigger_id of slash commands and interactivity payloads
+const (
+	AttrResponseURL = "response_url"
+	AttrTriggerID   = "trigger_id"
+)
+
+// Responder answers the slash command or interaction a message came from
+type Responder struct {
+	// ResponseURL posts messages in response, for 30 minutes
+	ResponseURL string
+
+	// TriggerID opens a modal, for 3 seconds after the interaction
+	TriggerID string
+
+	// Channel and MessageTS identify the message of the interaction, if any
+	Channel   string
+	MessageTS string
+
+	token string
+}
+
+// correlated holds the fields of the payloads identifying the request to respond to
+type correlated struct {
+	ResponseURL string `json:"response_url"`
+	TriggerID   string `json:"trigger_id"`
+
+	// Slash commands
+	ChannelID string `json:"channel_id"`
+
+	// Interactivity payloads
+	Channel struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Container struct {
+		ChannelID string `json:"channel_id"`
+		MessageTS string `json:"message_ts"`
+	} `json:"container"`
+}
+
+// NewResponder creates the responder of a message. The bot token is only needed to open modals,
+// and to update messages without a response_url.
+// The response_url and trigger_id attributes are used if set, and are parsed from the payload otherwise.
+func NewResponder(msg *pubsub.Message, token string) (*Responder, error) {
+	data, err := Payload(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding message %s: %w", msg.ID, err)
+	}
+
+	var payload correlated
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed decoding message %s: %w", msg.ID, err)
+	}
+
+	r := &Responder{
+		ResponseURL: msg.Attributes[AttrResponseURL],
+		TriggerID:   msg.Attributes[AttrTriggerID],
+		Channel:     payload.Container.ChannelID,
+		MessageTS:   payload.Container.MessageTS,
+		token:       token,
+	}
+	if r.ResponseURL == "" {
+		r.ResponseURL = payload.ResponseURL
+	}
+	if r.TriggerID == "" {
+		r.TriggerID = payload.TriggerID
+	}
+	if r.Channel == "" {
+		r.Channel = payload.Channel.ID
+	}
+	if r.Channel == "" {
+		r.Channel = payload.ChannelID
+	}
+	return r, nil
+}
+
+// RespondEphemeral posts a message only the user who sent the command or interacted can see.
+// Blocks are optional, the text is then the notification fallback.
+func (r *Responder) RespondEphemeral(ctx context.Context, text string, blocks any) error {
+	msg := map[string]any{"response_type": "ephemeral", "text": text}
+	if blocks != nil {
+		msg["blocks"] = blocks
+	}
+	return r.respond(ctx, msg)
+}
+
+// OpenModal opens the view as a modal, within 3 seconds of the interaction
+func (r *Responder) OpenModal(ctx context.Context, view any) error {
+	if r.TriggerID == "" {
+		return errors.New("message has no trigger_id")
+	}
+	return callSlack(ctx, r.token, "views.open", map[string]any{"trigger_id": r.TriggerID, "view": view})
+}
+
+// UpdateMessage replaces the message of the interaction, through its response_url,
+// or chat.update if it has none
+func (r *Responder) UpdateMessage(ctx context.Context, text string, blocks any) error {
+	msg := map[string]any{"text": text}
+	if blocks != nil {
+		msg["blocks"] = blocks
+	}
+
+	if r.ResponseURL != "" {
+		msg["replace_original"] = true
+		return r.respond(ctx, msg)
+	}
+
+	if r.Channel == "" || r.MessageTS == "" {
+		return errors.New("message has no response_url or message to update")
+	}
+	msg["channel"], msg["ts"] = r.Channel, r.MessageTS
+	return callSlack(ctx, r.token, "chat.update", msg)
+}
+
+// respond posts the message to the response_url
+func (r *Responder) respond(ctx context.Context, msg map[string]any) error {
+	if r.ResponseURL == "" {
+		return errors.New("message has no response_url")
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.ResponseURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response_url returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// LinkShared is a link_shared event, posted when a message links to a domain the app unfurls
+type LinkShared struct {
+	TeamID    string
+	Channel   string `json:"channel"`
+	MessageTS string `json:"message_ts"`
+	UnfurlID  string `json:"unfurl_id"`
+	Source    string `json:"source"`
+	Links     []struct {
+		Domain string `json:"domain"`
+		URL    string `json:"url"`
+	} `json:"links"`
+}
+
+// ParseLinkShared parses the link_shared event of a message's data, or of a request of the proxy's UNFURL_URL
+func ParseLinkShared(data []byte) (*LinkShared, error) {
+	var payload struct {
+		TeamID string `json:"team_id"`
+		Event  struct {
+			Type string `json:"type"`
+			LinkShared
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Event.Type != "link_shared" {
+		return nil, fmt.Errorf("not a link_shared event: %q", payload.Event.Type)
+	}
+
+	e := payload.Event.LinkShared
+	e.TeamID = payload.TeamID
+	return &e, nil
+}
+
+// Unfurl posts the unfurls of the event's links with chat.unfurl, by URL.
+// Unfurls of the composer preview are posted by their unfurl_id and source, others by channel and message ts.
+func Unfurl(ctx context.Context, token string, e *LinkShared, unfurls map[string]any) error {
+	args := map[string]any{"unfurls": unfurls}
+	if e.UnfurlID != "" {
+		args["unfurl_id"], args["source"] = e.UnfurlID, e.Source
+	} else {
+		args["channel"], args["ts"] = e.Channel, e.MessageTS
+	}
+
+	return callSlack(ctx, token, "chat.unfurl", args)
+}
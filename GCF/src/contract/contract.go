@@ -0,0 +1,99 @@
+// Package contract replays canonical Slack payloads through the proxy into an in-memory publisher,
+// so consumer authors can assert their handlers against exactly what the proxy emits.
+//
+// The harness configures the proxy itself, so it must run before anything else uses the proxy.
+package contract
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	proxy "github.com/bharel/SlackFunctionsProxy"
+	"github.com/bharel/SlackFunctionsProxy/inmem"
+	"github.com/bharel/SlackFunctionsProxy/slackfixture"
+)
+
+// SigningSecret signs the replayed requests
+const SigningSecret = "contract-harness-signing-secret"
+
+// Result is the outcome of a replayed request
+type Result struct {
+	// Status is the status code the proxy responded with
+	Status int
+
+	// Body is the body the proxy responded with
+	Body []byte
+
+	// Messages are the messages the proxy published
+	Messages []*pubsub.Message
+}
+
+var (
+	setupOnce sync.Once
+	published = inmem.NewPublisher()
+
+	// Requests are replayed one at a time, so their messages can be told apart
+	replayMu sync.Mutex
+)
+
+// setup configures the proxy to publish in-memory.
+// Other settings are still taken from the environment.
+func setup() {
+	setupOnce.Do(func() {
+		os.Setenv("SLACK_SIGNING_SECRET", SigningSecret)
+		if os.Getenv("GCP_PROJECT") == "" {
+			os.Setenv("GCP_PROJECT", "contract")
+		}
+		if os.Getenv("ACCESS_LOG") == "" {
+			os.Setenv("ACCESS_LOG", "false")
+		}
+
+		proxy.SetPublisher(published)
+		proxy.Setup()
+	})
+}
+
+// Fixtures returns the names of the recorded payloads, such as "message" or "view_submission".
+// See the slackfixture package for the corpus.
+func Fixtures() []string {
+	var names []string
+	for _, f := range slackfixture.All() {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// Replay sends a recorded payload through the proxy
+func Replay(name string) (*Result, error) {
+	f, ok := slackfixture.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown fixture %s", name)
+	}
+	return send(f), nil
+}
+
+// Send signs a JSON payload and sends it through the proxy
+func Send(body []byte) *Result {
+	return send(slackfixture.Fixture{ContentType: "application/json", Body: body})
+}
+
+// send signs a fixture and sends it through the proxy
+func send(f slackfixture.Fixture) *Result {
+	setup()
+
+	r := f.Request("/", SigningSecret, time.Now())
+
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	published.Reset()
+
+	w := httptest.NewRecorder()
+	proxy.Proxy(w, r)
+
+	return &Result{Status: w.Code, Body: w.Body.Bytes(), Messages: published.Messages()}
+}
@@ -0,0 +1,68 @@
+package contract
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/bharel/SlackFunctionsProxy/slackfixture"
+)
+
+func TestReplayURLVerification(t *testing.T) {
+	result, err := Replay("url_verification")
+	if err != nil {
+		t.Fatalf("Replay() = %v", err)
+	}
+	if result.Status != http.StatusOK || len(result.Messages) != 0 {
+		t.Errorf("got %d with %d messages, want 200 with none", result.Status, len(result.Messages))
+	}
+	if !bytes.Contains(result.Body, []byte("3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P")) {
+		t.Errorf("body %s doesn't answer the challenge", result.Body)
+	}
+}
+
+func TestReplayEvents(t *testing.T) {
+	for _, f := range slackfixture.All() {
+		if f.Kind != slackfixture.KindEvent || f.Name == "url_verification" {
+			continue
+		}
+		t.Run(f.Name, func(t *testing.T) {
+			result, err := Replay(f.Name)
+			if err != nil {
+				t.Fatalf("Replay() = %v", err)
+			}
+			if result.Status != http.StatusOK || len(result.Messages) != 1 {
+				t.Fatalf("got %d with %d messages, want 200 with one", result.Status, len(result.Messages))
+			}
+
+			msg := result.Messages[0]
+			if attempt := msg.Attributes["publish_attempt"]; attempt != "1" {
+				t.Errorf("publish_attempt = %q, want 1", attempt)
+			}
+		})
+	}
+}
+
+func TestReplayUnknown(t *testing.T) {
+	if _, err := Replay("unknown"); err == nil {
+		t.Error("Replay(unknown) succeeded")
+	}
+}
+
+func TestSend(t *testing.T) {
+	body := []byte(`{"type":"event_callback","team_id":"T1","event_id":"Ev1","event":{"type":"message"}}`)
+
+	result := Send(body)
+	if result.Status != http.StatusOK || len(result.Messages) != 1 {
+		t.Fatalf("got %d with %d messages, want 200 with one", result.Status, len(result.Messages))
+	}
+	if !bytes.Contains(result.Messages[0].Data, []byte(`"Ev1"`)) {
+		t.Errorf("published %s, want the sent payload", result.Messages[0].Data)
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	if len(Fixtures()) != len(slackfixture.All()) {
+		t.Errorf("Fixtures() lists %d fixtures, want %d", len(Fixtures()), len(slackfixture.All()))
+	}
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DebugHandler serves the /debug endpoints of the standalone server:
+// /debug/pprof (Go profiling), /debug/runtime (runtime stats), /debug/flags (feature flags)
+// /debug/config (redacted configuration) and /debug/flush (POST, waits for the work off the request path).
+// Requires a bearer token matching DEBUG_TOKEN, and responds 404 if it isn't set.
+func DebugHandler() http.Handler {
+	token := getenv("DEBUG_TOKEN")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", serveRuntimeStats)
+	mux.HandleFunc("/debug/flags", serveFlags)
+	mux.HandleFunc("/debug/config", serveConfig)
+	mux.HandleFunc("/debug/flush", serveFlush)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if !hasBearerToken(r, token) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// hasBearerToken returns true if the request carries the token as its bearer token
+func hasBearerToken(r *http.Request, token string) bool {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+// runtimeStats is a summary of the process state, for diagnosing memory growth or goroutine leaks
+type runtimeStats struct {
+	GoVersion    string  `json:"go_version"`
+	Uptime       string  `json:"uptime"`
+	GOMAXPROCS   int     `json:"gomaxprocs"`
+	Goroutines   int     `json:"goroutines"`
+	HeapAlloc    uint64  `json:"heap_alloc_bytes"`
+	HeapInuse    uint64  `json:"heap_inuse_bytes"`
+	HeapObjects  uint64  `json:"heap_objects"`
+	Sys          uint64  `json:"sys_bytes"`
+	NumGC        uint32  `json:"num_gc"`
+	PauseTotalMs float64 `json:"gc_pause_total_ms"`
+}
+
+func serveRuntimeStats(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runtimeStats{
+		GoVersion:    runtime.Version(),
+		Uptime:       time.Since(processStart).Round(time.Second).String(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		Sys:          mem.Sys,
+		NumGC:        mem.NumGC,
+		PauseTotalMs: float64(mem.PauseTotalNs) / 1e6,
+	})
+}
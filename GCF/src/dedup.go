@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+const (
+	errorInFlight = "duplicate_in_flight"
+
+	// Seconds Slack is asked to wait before retrying a duplicate still being published
+	inFlightRetryAfter = "1"
+)
+
+var (
+	// Time window in which identical events are suppressed, 0 if disabled
+	dedupWindow time.Duration
+	dedupKeys   Store
+)
+
+var (
+	suppressedDuplicates = newCounterVec("slack_proxy_duplicates_suppressed_total",
+		"Duplicate events of an event still being published, answered with a retryable status, by event type.", "event_type")
+	acknowledgedPublished = newCounterVec("slack_proxy_published_events_acknowledged_total",
+		"Events acknowledged without republishing, as their event id was already published, by event type.", "event_type")
+)
+
+// setupDedup configures the dedup window from the environment
+func setupDedup() {
+	if dedupWindow = configDuration("DEDUP_WINDOW", 0, false); dedupWindow == 0 {
+		return
+	}
+
+	dedupKeys = newStoreFromEnv("DEDUP_STORE")
+}
+
+// dedupKey hashes the content identifying an event.
+// Only the inner event is hashed, as the same event may arrive wrapped
+// with different authorizations.
+func dedupKey(payload *slackPayload, body []byte) string {
+	hash := sha256.New()
+	if len(payload.RawEvent) > 0 {
+		hash.Write(stringToByteSlice(&payload.TeamID))
+		hash.Write(payload.RawEvent)
+	} else {
+		hash.Write(body)
+	}
+	return "dedup:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// States of a claimed dedup key
+const (
+	dedupClaimed   = "claimed"
+	dedupPublished = "published"
+)
+
+// claimEvent returns the dedup key of the event, or the state of its key if it is a duplicate.
+// A duplicate of an event already published is dedupPublished, e.g. Slack's retries of a slow first attempt,
+// and one of an event still being published is dedupClaimed, as that publish may yet fail.
+// Store failures let the event through, as a duplicate is better than a lost event.
+func claimEvent(ctx context.Context, payload *slackPayload, body []byte) (key string, state string) {
+	key = dedupKey(payload, body)
+
+	claimed, err := dedupKeys.SetNX(ctx, key, dedupClaimed, dedupWindow)
+	if err != nil {
+		logError(ctx, "Failed claiming dedup key: %s", err.Error())
+		return "", ""
+	}
+	if claimed {
+		return key, ""
+	}
+
+	// The key may have been released meanwhile, in which case the duplicate is retried as well
+	if state, _, _ = dedupKeys.Get(ctx, key); state == dedupPublished {
+		acknowledgedPublished.Inc(payload.Event.Type)
+		return "", dedupPublished
+	}
+	suppressedDuplicates.Inc(payload.Event.Type)
+	return "", dedupClaimed
+}
+
+// writeInFlight answers a duplicate of an event still being published, asking Slack to retry it later.
+// The retry is acknowledged once the event is published, or claims it if that publish failed.
+func writeInFlight(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", inFlightRetryAfter)
+	writeError(w, http.StatusServiceUnavailable, errorInFlight)
+}
+
+// releaseEvent releases a claimed event, so a retry isn't suppressed
+func releaseEvent(ctx context.Context, key string) {
+	if err := dedupKeys.Delete(ctx, key); err != nil {
+		logError(ctx, "Failed releasing dedup key: %s", err.Error())
+	}
+}
+
+// markPublished marks the claimed event as published for the dedup window
+func markPublished(ctx context.Context, key string) {
+	if err := dedupKeys.Set(ctx, key, dedupPublished, dedupWindow); err != nil {
+		logError(ctx, "Failed marking event as published: %s", err.Error())
+	}
+}
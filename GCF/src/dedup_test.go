@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func withDedup(t *testing.T) {
+	t.Helper()
+	prevWindow, prevKeys := dedupWindow, dedupKeys
+	dedupWindow, dedupKeys = time.Minute, newMemoryStore()
+	t.Cleanup(func() { dedupWindow, dedupKeys = prevWindow, prevKeys })
+}
+
+func TestClaimEventStates(t *testing.T) {
+	withDedup(t)
+	ctx := context.Background()
+	payload := &slackPayload{TeamID: "T1", RawEvent: json.RawMessage(`{"type":"message","ts":"1"}`)}
+	payload.Event.Type = "message"
+
+	key, state := claimEvent(ctx, payload, nil)
+	if key == "" || state != "" {
+		t.Fatalf("first claim = (%q, %q), want a key", key, state)
+	}
+
+	// Still being published: the duplicate should be retried
+	if dup, state := claimEvent(ctx, payload, nil); dup != "" || state != dedupClaimed {
+		t.Errorf("claim while in flight = (%q, %q), want %q", dup, state, dedupClaimed)
+	}
+
+	markPublished(ctx, key)
+	if dup, state := claimEvent(ctx, payload, nil); dup != "" || state != dedupPublished {
+		t.Errorf("claim once published = (%q, %q), want %q", dup, state, dedupPublished)
+	}
+}
+
+func TestClaimEventAfterRelease(t *testing.T) {
+	withDedup(t)
+	ctx := context.Background()
+	payload := &slackPayload{TeamID: "T1", RawEvent: json.RawMessage(`{"type":"message","ts":"2"}`)}
+
+	key, _ := claimEvent(ctx, payload, nil)
+	releaseEvent(ctx, key)
+
+	if retry, state := claimEvent(ctx, payload, nil); retry != key || state != "" {
+		t.Errorf("claim after a failed publish = (%q, %q), want (%q, \"\")", retry, state, key)
+	}
+}
+
+func TestWriteInFlight(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeInFlight(w)
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("writeInFlight() answered %d with Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the DynamoDB JSON API
+const dynamoDBTargetPrefix = "DynamoDB_20120810."
+
+var dynamoDBClient = &http.Client{Timeout: 5 * time.Second}
+
+// awsCredentials signs requests to AWS, read from the standard env vars
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+}
+
+// awsCredentialsFromEnv reads the credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN,
+// and the region from AWS_REGION or AWS_DEFAULT_REGION.
+// Records a configuration error and returns false if any is missing.
+func awsCredentialsFromEnv() (awsCredentials, bool) {
+	creds := awsCredentials{
+		accessKeyID:     getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    getenv("AWS_SESSION_TOKEN"),
+		region:          getenv("AWS_REGION"),
+	}
+	if creds.region == "" {
+		creds.region = getenv("AWS_DEFAULT_REGION")
+	}
+
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" || creds.region == "" {
+		configErrorf("AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION env vars must be set for DynamoDB.")
+		return creds, false
+	}
+	return creds, true
+}
+
+// sign signs the request with AWS Signature Version 4
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func (creds awsCredentials) sign(req *http.Request, body []byte, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	// The signed headers, in the sorted order of their lowercase names
+	signed := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if creds.sessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	signed = append(signed, "x-amz-target")
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(),
+		strings.Join(signed, ";"), hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := day + "/" + creds.region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.secretAccessKey)
+	for _, part := range []string{day, creds.region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, strings.Join(signed, ";"), hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// dynamoDBTenantRegistry keeps an item per tenant in a DynamoDB table, with a team_id string partition key
+type dynamoDBTenantRegistry struct {
+	table    string
+	endpoint string
+	creds    awsCredentials
+}
+
+// newDynamoDBTenantRegistry creates the registry of the table.
+// The endpoint defaults to the regional endpoint of DynamoDB.
+func newDynamoDBTenantRegistry(table, endpoint string, creds awsCredentials) *dynamoDBTenantRegistry {
+	if endpoint == "" {
+		endpoint = "https://dynamodb." + creds.region + ".amazonaws.com"
+	}
+	return &dynamoDBTenantRegistry{table: table, endpoint: strings.TrimSuffix(endpoint, "/") + "/", creds: creds}
+}
+
+// dynamoDBValue is an attribute value of an item, of the types used by the tenants
+type dynamoDBValue struct {
+	S    *string                  `json:"S,omitempty"`
+	N    *string                  `json:"N,omitempty"`
+	BOOL *bool                    `json:"BOOL,omitempty"`
+	M    map[string]dynamoDBValue `json:"M,omitempty"`
+}
+
+func dynamoDBString(s string) dynamoDBValue {
+	return dynamoDBValue{S: &s}
+}
+
+func (reg *dynamoDBTenantRegistry) Lookup(ctx context.Context, teamID string) (*tenant, error) {
+	var resp struct {
+		Item map[string]dynamoDBValue `json:"Item"`
+	}
+	err := reg.call(ctx, "GetItem", map[string]any{
+		"TableName": reg.table,
+		"Key":       map[string]dynamoDBValue{"team_id": dynamoDBString(teamID)},
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Item == nil {
+		return nil, nil
+	}
+
+	t := &tenant{TeamID: teamID, Flags: map[string]bool{}}
+	item := resp.Item
+	if v := item["signing_secret"].S; v != nil {
+		t.SigningSecret = *v
+	}
+	if v := item["bot_token"].S; v != nil {
+		t.BotToken = *v
+	}
+	if v := item["topic"].S; v != nil {
+		t.Topic = *v
+	}
+	if v := item["daily_quota"].N; v != nil {
+		if t.DailyQuota, err = strconv.ParseInt(*v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid daily_quota of tenant %s: %w", teamID, err)
+		}
+	}
+	for name, v := range item["flags"].M {
+		if v.BOOL != nil {
+			t.Flags[name] = *v.BOOL
+		}
+	}
+	return t, nil
+}
+
+func (reg *dynamoDBTenantRegistry) Install(ctx context.Context, t *tenant) error {
+	update := "SET bot_token = :bot_token"
+	values := map[string]dynamoDBValue{":bot_token": dynamoDBString(t.BotToken)}
+	if t.Topic != "" {
+		update += ", topic = :topic"
+		values[":topic"] = dynamoDBString(t.Topic)
+	}
+
+	return reg.call(ctx, "UpdateItem", map[string]any{
+		"TableName":                 reg.table,
+		"Key":                       map[string]dynamoDBValue{"team_id": dynamoDBString(t.TeamID)},
+		"UpdateExpression":          update,
+		"ExpressionAttributeValues": values,
+	}, nil)
+}
+
+// call calls an action of the DynamoDB JSON API, decoding its response into out if set
+func (reg *dynamoDBTenantRegistry) call(ctx context.Context, action string, input any, out any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reg.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", dynamoDBTargetPrefix+action)
+	reg.creds.sign(req, body, "dynamodb", clock.Now())
+
+	resp, err := dynamoDBClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		return fmt.Errorf("DynamoDB %s returned status %d: %s %s", action, resp.StatusCode, apiErr.Type, apiErr.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// dynamoDBEndpoint returns the DYNAMODB_ENDPOINT override, validating it
+func dynamoDBEndpoint() string {
+	endpoint := getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		return ""
+	}
+	if u, err := url.Parse(endpoint); err != nil || u.Host == "" {
+		configErrorf("DYNAMODB_ENDPOINT must be a URL, e.g. http://localhost:8000.")
+		return ""
+	}
+	return endpoint
+}
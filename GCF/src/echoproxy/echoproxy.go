@@ -0,0 +1,29 @@
+// Package echoproxy mounts the proxy in echo, for services embedding it in an existing API.
+package echoproxy
+
+import (
+	"net/http"
+
+	proxy "github.com/bharel/SlackFunctionsProxy"
+	"github.com/labstack/echo/v4"
+)
+
+// Handler returns a handler proxying Slack requests
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(http.HandlerFunc(proxy.Proxy))
+}
+
+// Verify returns a middleware verifying Slack requests before the next handler.
+// Invalid requests are responded to without calling it.
+func Verify() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var err error
+			proxy.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				err = next(c)
+			})).ServeHTTP(c.Response(), c.Request())
+			return err
+		}
+	}
+}
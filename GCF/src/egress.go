@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// Transport trusting the egress CAs, nil to use the default transport
+var egressTransport *http.Transport
+
+// setupEgressCA trusts the CAs at EGRESS_CA_FILE on top of the system roots, for egress proxies inspecting TLS.
+// Applies to the HTTP clients of the proxy and the REST clients of Google APIs, but not to the gRPC transport.
+// Runs before any client is used, including those reading the secrets and the remote configuration.
+func setupEgressCA() {
+	file := getenv("EGRESS_CA_FILE")
+	if file == "" {
+		return
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	pem, err := os.ReadFile(file)
+	if err != nil || !pool.AppendCertsFromPEM(pem) {
+		configErrorf("EGRESS_CA_FILE must be a file of PEM certificates.")
+		return
+	}
+
+	// A dedicated transport, leaving the default one of the embedding program alone
+	egressTransport = http.DefaultTransport.(*http.Transport).Clone()
+	egressTransport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	for _, client := range []*http.Client{
+		alertClient, webhookClient, slackAPIClient, flagsClient, optionsClient, otlpClient, sentryClient, vaultClient, dynamoDBClient,
+	} {
+		client.Transport = egressTransport
+	}
+}
+
+// egressClientOptions adds the egress transport to the options of a Google API REST client, if set
+func egressClientOptions(opts ...option.ClientOption) []option.ClientOption {
+	if egressTransport == nil {
+		return opts
+	}
+
+	// The transport authenticates the requests using the credentials options
+	transport, err := htransport.NewTransport(context.Background(), egressTransport, opts...)
+	if err != nil {
+		configErrorf("Failed creating the egress transport: %s.", err.Error())
+		return opts
+	}
+	return append(opts, option.WithHTTPClient(&http.Client{Transport: transport}))
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// Message attributes of encrypted payloads
+const (
+	attrPayloadEncryption = "payload_encryption"
+	attrEncryptionKeyID   = "encryption_key_id"
+)
+
+const encryptionAES256GCM = "aes-256-gcm"
+
+var (
+	// AEAD encrypting the published payloads, nil if disabled
+	payloadAEAD cipher.AEAD
+
+	// Identifies the key to consumers rotating keys, empty if unset
+	payloadKeyID string
+)
+
+// setupPayloadEncryption configures the payload encryption from the environment.
+// PAYLOAD_ENCRYPTION_KEY is a base64 AES-256 key, which can be passed KMS-encrypted as PAYLOAD_ENCRYPTION_KEY_ENC.
+func setupPayloadEncryption() {
+	encoded := getenv("PAYLOAD_ENCRYPTION_KEY")
+	if encoded == "" {
+		return
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		configErrorf("PAYLOAD_ENCRYPTION_KEY must be a base64 encoded 32 bytes key.")
+		return
+	}
+
+	// Both expect plaintext JSON messages
+	if getenv("PUBSUB_SCHEMA") != "" {
+		configErrorf("PUBSUB_SCHEMA validates JSON messages, and can't be used with PAYLOAD_ENCRYPTION_KEY.")
+		return
+	}
+	if getenv("WEBHOOK_TEMPLATE") != "" {
+		configErrorf("WEBHOOK_TEMPLATE renders JSON payloads, and can't be used with PAYLOAD_ENCRYPTION_KEY.")
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		configErrorf("Invalid PAYLOAD_ENCRYPTION_KEY: %s.", err.Error())
+		return
+	}
+	if payloadAEAD, err = cipher.NewGCM(block); err != nil {
+		configErrorf("Invalid PAYLOAD_ENCRYPTION_KEY: %s.", err.Error())
+		return
+	}
+
+	payloadKeyID = getenv("PAYLOAD_ENCRYPTION_KEY_ID")
+}
+
+// encryptPayload seals the payload, returning the nonce followed by the ciphertext
+func encryptPayload(data []byte) ([]byte, error) {
+	nonce := make([]byte, payloadAEAD.NonceSize(), payloadAEAD.NonceSize()+len(data)+payloadAEAD.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed generating nonce: %w", err)
+	}
+	return payloadAEAD.Seal(nonce, nonce, data, nil), nil
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Message attributes set by the enrichment
+const (
+	attrUserName    = "user_name"
+	attrUserEmail   = "user_email"
+	attrChannelName = "channel_name"
+)
+
+const (
+	defaultEnrichTimeout  = 500 * time.Millisecond
+	defaultEnrichCacheTTL = 10 * time.Minute
+)
+
+const slackAPIURL = "https://slack.com/api/"
+
+var (
+	enrichEnabled bool
+
+	// Bot token used for the lookups, unless the tenant has its own
+	slackBotToken string
+
+	enrichTimeout = defaultEnrichTimeout
+	enrichCache   cache
+
+	slackAPIClient = &http.Client{}
+
+	// Unix time until which the Slack API rate-limited each token, keyed by the token's hash
+	slackAPIBlocks Store = newMemoryStore()
+)
+
+// setupEnrichment configures the payload enrichment from the environment
+func setupEnrichment() {
+	if getenv("ENRICH") != "true" {
+		return
+	}
+
+	enrichEnabled = true
+
+	// (optional when the tenant registry holds the tokens)
+	slackBotToken = getenv("SLACK_BOT_TOKEN")
+	if slackBotToken == "" && getenv("TENANT_REGISTRY") == "" {
+		configErrorf("SLACK_BOT_TOKEN env var must be set when ENRICH is enabled.")
+	}
+
+	enrichTimeout = configDuration("ENRICH_TIMEOUT", defaultEnrichTimeout, false)
+	enrichCache = newCacheFromEnv("ENRICH_CACHE", configDuration("ENRICH_CACHE_TTL", defaultEnrichCacheTTL, false))
+	slackAPIBlocks = newStoreFromEnv("ENRICH_RATE_LIMIT_STORE")
+}
+
+// slackAPIBlockKey returns the key of the token's rate limit, which doesn't reveal the token
+func slackAPIBlockKey(token string) string {
+	return "slack_api_blocked:" + bodyHash([]byte(token))
+}
+
+// enrich resolves the user and channel IDs of the payload and attaches them as attributes.
+// Lookup failures are logged and never fail the request.
+// Tenants can opt out using the "enrich" flag.
+func enrich(ctx context.Context, payload *slackPayload, attributes map[string]string) {
+	t := tenantFromContext(ctx)
+	if !t.flag("enrich", true) {
+		return
+	}
+
+	token := slackBotToken
+	if t != nil && t.BotToken != "" {
+		token = t.BotToken
+	}
+	if token == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, stageTimeout(ctx, enrichTimeout))
+	defer cancel()
+
+	// IDs are only unique within a workspace, and each tenant's token sees its own data
+	teamID := payload.TeamID
+	if teamID == "" {
+		teamID = payload.Team.ID
+	}
+	scope := teamID
+	if t != nil {
+		scope = t.TeamID + ":" + teamID
+	}
+
+	if user := rawString(payload.Event.User); user != "" {
+		var info struct {
+			User struct {
+				Name    string `json:"name"`
+				Profile struct {
+					Email string `json:"email"`
+				} `json:"profile"`
+			} `json:"user"`
+		}
+		if lookup(ctx, token, scope, "users.info", "user", user, &info) {
+			attributes[attrUserName] = info.User.Name
+			if info.User.Profile.Email != "" {
+				attributes[attrUserEmail] = info.User.Profile.Email
+			}
+		}
+	}
+
+	if channel := rawString(payload.Event.Channel); channel != "" {
+		var info struct {
+			Channel struct {
+				Name string `json:"name"`
+			} `json:"channel"`
+		}
+		if lookup(ctx, token, scope, "conversations.info", "channel", channel, &info) && info.Channel.Name != "" {
+			attributes[attrChannelName] = info.Channel.Name
+		}
+	}
+}
+
+// lookup calls a Slack Web API method with a single ID argument, going through the cache.
+// Cached results are keyed by the team and tenant scope, along with the method and ID.
+// Returns true if the result was decoded into v.
+func lookup(ctx context.Context, token, scope, method, arg, id string, v any) bool {
+	key := method + ":" + scope + ":" + id
+	if cached, ok := enrichCache.Get(ctx, key); ok {
+		return json.Unmarshal(stringToByteSlice(&cached), v) == nil
+	}
+
+	data, err := callSlackAPI(ctx, token, method, url.Values{arg: {id}})
+	if err != nil {
+		logError(ctx, "Failed calling %s: %s", method, err.Error())
+		return false
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		logError(ctx, "Failed decoding %s: %s", method, err.Error())
+		return false
+	}
+
+	enrichCache.Set(ctx, key, byteSliceToString(data))
+	return true
+}
+
+// callSlackAPI calls a Slack Web API method, honoring rate limits.
+// Returns the raw response of successful calls.
+func callSlackAPI(ctx context.Context, token, method string, args url.Values) ([]byte, error) {
+	blockKey := slackAPIBlockKey(token)
+	if value, ok, err := slackAPIBlocks.Get(ctx, blockKey); err != nil {
+		logWarning(ctx, "Failed reading the Slack API rate limit: %s", err.Error())
+	} else if until, _ := strconv.ParseInt(value, 10, 64); ok && clock.Now().Unix() < until {
+		return nil, fmt.Errorf("rate limited for %ds", until-clock.Now().Unix())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, slackAPIURL+method+"?"+args.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := slackAPIClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || retryAfter <= 0 {
+			retryAfter = 1
+		}
+		until := clock.Now().Unix() + int64(retryAfter)
+		err = slackAPIBlocks.Set(ctx, blockKey, strconv.FormatInt(until, 10), time.Duration(retryAfter)*time.Second)
+		if err != nil {
+			logWarning(ctx, "Failed recording the Slack API rate limit: %s", err.Error())
+		}
+		return nil, fmt.Errorf("rate limited, retry after %ds", retryAfter)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+
+	var data json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if !result.OK {
+		return nil, fmt.Errorf("returned error %s", result.Error)
+	}
+
+	return data, nil
+}
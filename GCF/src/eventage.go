@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+var (
+	// Maximum age of an event's event_time, 0 if unchecked
+	maxEventAge time.Duration
+
+	// Topic receiving the dropped stale events, nil if disabled
+	staleEventsTopic pubsubTopic
+
+	staleEvents = newCounterVec("slack_proxy_events_stale_total",
+		"Events dropped for being older than MAX_EVENT_AGE, by event type.", "event_type")
+)
+
+// setupEventAge configures the stale events policy from the environment
+func setupEventAge() {
+	maxEventAge = configDuration("MAX_EVENT_AGE", 0, true)
+	if topicName := getenv("MAX_EVENT_AGE_TOPIC"); topicName != "" {
+		if maxEventAge == 0 {
+			configErrorf("MAX_EVENT_AGE must be set when MAX_EVENT_AGE_TOPIC is set.")
+			return
+		}
+		staleEventsTopic = openExistingTopic(topicName)
+	}
+}
+
+// isStale returns true if the event happened longer than MAX_EVENT_AGE ago, e.g. redelivered by Slack's retries
+// after an outage. Payloads without an event_time are never stale.
+func isStale(payload *slackPayload) bool {
+	if payload.EventTime == 0 {
+		return false
+	}
+	return clock.Now().Sub(time.Unix(payload.EventTime, 0)) > maxEventAge
+}
+
+// dropStaleEvent records the stale event, and publishes it to MAX_EVENT_AGE_TOPIC if set
+func dropStaleEvent(ctx context.Context, e *Event) {
+	age := clock.Now().Sub(time.Unix(e.payload.EventTime, 0)).Round(time.Second)
+	staleEvents.Inc(e.payload.eventType())
+	logWarning(ctx, "Dropped stale event %s, %s old.", e.payload.EventID, age)
+
+	if staleEventsTopic == nil {
+		return
+	}
+
+	attributes := make(map[string]string, len(e.Message.Attributes)+1)
+	for name, value := range e.Message.Attributes {
+		attributes[name] = value
+	}
+	attributes["event_age_seconds"] = strconv.FormatInt(int64(age/time.Second), 10)
+
+	if err := staleEventsTopic.Publish(ctx, &pubsub.Message{Data: e.Body, Attributes: attributes}); err != nil {
+		logError(ctx, "Failed publishing stale event: %s", err.Error())
+	}
+}
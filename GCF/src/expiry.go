@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"context"
+	"time"
+)
+
+// Message attributes holding the time the request was received, and the time after which the event is stale
+const (
+	attrReceivedAt = "received_at"
+	attrExpiresAt  = "expires_at"
+)
+
+// Time to live of the messages, 0 if they don't expire
+var messageTTL time.Duration
+
+// setupExpiry configures the message expiry from the environment
+func setupExpiry() {
+	messageTTL = configDuration("MESSAGE_TTL", 0, false)
+}
+
+// stampExpiry attaches the receive time, and the expiry time if configured
+func stampExpiry(ctx context.Context, attributes map[string]string) {
+	received := requestStart(ctx).UTC()
+	attributes[attrReceivedAt] = received.Format(time.RFC3339Nano)
+	if messageTTL > 0 {
+		attributes[attrExpiresAt] = received.Add(messageTTL).Format(time.RFC3339Nano)
+	}
+}
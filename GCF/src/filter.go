@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"strings"
+)
+
+// eventFilter drops events by their type.
+// Payloads without an event (such as slash commands) are never dropped.
+type eventFilter struct {
+	// Event types to drop
+	drop map[string]bool
+
+	// If set, only these event types are forwarded
+	only map[string]bool
+}
+
+// Named filter presets, dropping well-known high-volume/low-value events
+var filterPresets = map[string]eventFilter{
+	"minimal": {drop: setOf(
+		"user_typing", "presence_change", "manual_presence_change",
+		"reaction_removed", "dnd_updated_user",
+	)},
+	"no-presence": {drop: setOf(
+		"presence_change", "manual_presence_change", "dnd_updated", "dnd_updated_user",
+	)},
+	"messages-only": {only: setOf("message", "app_mention")},
+}
+
+var filteredEvents = newCounterVec("slack_proxy_events_filtered_total",
+	"Valid events dropped by the filters, by event type.", "event_type")
+
+// setOf creates a set of the given strings
+func setOf(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// setupFilters configures the event filters from the environment.
+// FILTER_PRESET is a comma separated list of preset names.
+func setupFilters(live *liveConfig) {
+	live.deniedUsers = parseUserList(getenv("FILTER_DENY_USERS"))
+	live.allowedUsers = parseUserList(getenv("FILTER_ALLOW_USERS"))
+
+	presets := getenv("FILTER_PRESET")
+	if presets == "" {
+		return
+	}
+
+	for _, name := range strings.Split(presets, ",") {
+		preset, ok := filterPresets[strings.TrimSpace(name)]
+		if !ok {
+			configErrorf("Unknown FILTER_PRESET: %s.", name)
+			continue
+		}
+		live.eventFilters = append(live.eventFilters, preset)
+	}
+}
+
+// parseUserList parses a comma separated list of user ids, nil if empty
+func parseUserList(value string) map[string]bool {
+	if value == "" {
+		return nil
+	}
+
+	users := map[string]bool{}
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			users[id] = true
+		}
+	}
+	return users
+}
+
+// allows returns true if the filter lets the event type through
+func (f *eventFilter) allows(eventType string) bool {
+	if f.drop[eventType] {
+		return false
+	}
+	if f.only != nil && !f.only[eventType] {
+		return false
+	}
+	return true
+}
+
+// isFiltered returns true if the payload should be dropped
+func (live *liveConfig) isFiltered(payload *slackPayload) bool {
+	if (live.deniedUsers != nil || live.allowedUsers != nil) && !live.allowsUser(payload.userID()) {
+		filteredEvents.Inc(payload.eventType())
+		return true
+	}
+
+	if payload.Event.Type == "" {
+		return false
+	}
+
+	for i := range live.eventFilters {
+		if !live.eventFilters[i].allows(payload.Event.Type) {
+			filteredEvents.Inc(payload.Event.Type)
+			return true
+		}
+	}
+	return false
+}
+
+// allowsUser returns true if the user lists let the user's payloads through.
+// Payloads without a user are never dropped.
+func (live *liveConfig) allowsUser(user string) bool {
+	if user == "" {
+		return true
+	}
+	if live.deniedUsers[user] {
+		return false
+	}
+	return live.allowedUsers == nil || live.allowedUsers[user]
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feature flags gating risky behaviors, all enabled by default.
+// Turning one off disables the behavior without redeploying its configuration.
+const (
+	flagEnrichment     = "enrichment"
+	flagTransforms     = "transforms"
+	flagFaultInjection = "fault_injection"
+	flagMirroring      = "mirroring"
+	flagAckFirst       = "ack_first"
+	flagEncryption     = "encryption"
+	flagCanary         = "canary"
+)
+
+const defaultFlagsRefreshInterval = time.Minute
+
+var featureFlagDefaults = map[string]bool{
+	flagEnrichment:     true,
+	flagTransforms:     true,
+	flagFaultInjection: true,
+	flagMirroring:      true,
+	flagAckFirst:       true,
+	flagEncryption:     true,
+	flagCanary:         true,
+}
+
+var (
+	flagsMu      sync.RWMutex
+	featureFlags = copyFlags(featureFlagDefaults)
+
+	// Flags set by FLAGS overriding the defaults, and the remote flags overriding them
+	localFlags  map[string]bool
+	remoteFlags map[string]bool
+
+	// URL serving a JSON object of flags overriding the local ones, empty if disabled
+	flagsURL             string
+	flagsRefreshInterval = defaultFlagsRefreshInterval
+	flagsClient          = &http.Client{Timeout: 5 * time.Second}
+)
+
+// setupFlags configures the feature flags from the environment, and starts refreshing the remote ones
+func setupFlags() {
+	setLocalFlags(parseFlags(getenv("FLAGS")))
+
+	if flagsURL = getenv("FLAGS_URL"); flagsURL != "" {
+		flagsRefreshInterval = configDuration("FLAGS_REFRESH_INTERVAL", defaultFlagsRefreshInterval, false)
+
+		ctx := context.Background()
+		if err := refreshFlags(ctx); err != nil {
+			logWarning(ctx, "Failed fetching the remote flags: %s", err.Error())
+		}
+		go refreshFlagsPeriodically()
+	}
+
+	logInfo(context.Background(), "Feature flags: %s.", formatFlags(flagsSnapshot()))
+}
+
+// parseFlags parses the comma-separated flag rules of FLAGS
+func parseFlags(value string) map[string]bool {
+	flags := map[string]bool{}
+	if value == "" {
+		return flags
+	}
+
+	for _, rule := range strings.Split(value, ",") {
+		name, enabled, err := parseFlag(strings.TrimSpace(rule))
+		if err != nil {
+			configErrorf("Invalid FLAGS rule: %s.", rule)
+			continue
+		}
+		flags[name] = enabled
+	}
+	return flags
+}
+
+// parseFlag parses a flag rule, either name (enabled), name=on or name=off
+func parseFlag(rule string) (string, bool, error) {
+	name, value, hasValue := strings.Cut(rule, "=")
+	if _, ok := featureFlagDefaults[name]; !ok {
+		return "", false, fmt.Errorf("unknown flag %s", name)
+	}
+	if !hasValue {
+		return name, true, nil
+	}
+
+	switch value {
+	case "on":
+		return name, true, nil
+	case "off":
+		return name, false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	return name, enabled, err
+}
+
+// flagEnabled returns whether the feature flag is on
+func flagEnabled(name string) bool {
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+	return featureFlags[name]
+}
+
+// setLocalFlags replaces the local flags, keeping the remote ones
+func setLocalFlags(local map[string]bool) {
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	localFlags = local
+	applyFlags()
+}
+
+// setRemoteFlags replaces the remote flags, keeping the local ones
+func setRemoteFlags(remote map[string]bool) {
+	flagsMu.Lock()
+	defer flagsMu.Unlock()
+	remoteFlags = remote
+	applyFlags()
+}
+
+// applyFlags sets the effective flags: the defaults, overridden by the local flags, then by the remote ones.
+// Must be called with flagsMu held.
+func applyFlags() {
+	flags := copyFlags(featureFlagDefaults)
+	for name, enabled := range localFlags {
+		flags[name] = enabled
+	}
+	for name, enabled := range remoteFlags {
+		if _, ok := featureFlagDefaults[name]; ok {
+			flags[name] = enabled
+		}
+	}
+	featureFlags = flags
+}
+
+// flagsSnapshot returns a copy of the effective flags
+func flagsSnapshot() map[string]bool {
+	flagsMu.RLock()
+	defer flagsMu.RUnlock()
+	return copyFlags(featureFlags)
+}
+
+func copyFlags(flags map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(flags))
+	for name, enabled := range flags {
+		c[name] = enabled
+	}
+	return c
+}
+
+// formatFlags formats the flags as sorted name=on|off pairs
+func formatFlags(flags map[string]bool) string {
+	pairs := make([]string, 0, len(flags))
+	for name, enabled := range flags {
+		state := "off"
+		if enabled {
+			state = "on"
+		}
+		pairs = append(pairs, name+"="+state)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// refreshFlagsPeriodically fetches the remote flags every refresh interval.
+// Failed fetches keep the last known flags.
+func refreshFlagsPeriodically() {
+	ticker := time.NewTicker(flagsRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), flagsRefreshInterval)
+		before := formatFlags(flagsSnapshot())
+		if err := refreshFlags(ctx); err != nil {
+			logWarning(ctx, "Failed fetching the remote flags: %s", err.Error())
+		} else if after := formatFlags(flagsSnapshot()); after != before {
+			logInfo(ctx, "Feature flags changed: %s.", after)
+		}
+		cancel()
+	}
+}
+
+// refreshFlags fetches the remote flags, a JSON object of booleans by flag name
+func refreshFlags(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, flagsURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := flagsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+
+	var remote map[string]bool
+	if err := json.NewDecoder(resp.Body).Decode(&remote); err != nil {
+		return err
+	}
+	setRemoteFlags(remote)
+	return nil
+}
+
+// serveFlags responds with the effective flags
+func serveFlags(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flagsSnapshot())
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+const errorFlushTimeout = "flush_timeout"
+
+// Work left running off the request path: alerts, captures
+// and the publishes of early acknowledged events. Idle is closed once none is left.
+var (
+	backgroundMu   sync.Mutex
+	backgroundWork int
+	backgroundIdle = closedChannel()
+)
+
+// closedChannel returns a closed channel
+func closedChannel() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// startBackground tracks work started off the request path, until finishBackground is called
+func startBackground() {
+	backgroundMu.Lock()
+	defer backgroundMu.Unlock()
+
+	if backgroundWork == 0 {
+		backgroundIdle = make(chan struct{})
+	}
+	backgroundWork++
+}
+
+// finishBackground stops tracking work started by startBackground
+func finishBackground() {
+	backgroundMu.Lock()
+	defer backgroundMu.Unlock()
+
+	if backgroundWork--; backgroundWork == 0 {
+		close(backgroundIdle)
+	}
+}
+
+// goBackground runs the work off the request path, tracked so Flush waits for it
+func goBackground(work func()) {
+	startBackground()
+	go func() {
+		defer finishBackground()
+		work()
+	}()
+}
+
+// Flush waits for the work still running off the request path, then exports the usage and metrics
+// counted since the last export. Call it before the instance stops, once no more requests are served;
+// the standalone server does on SIGTERM.
+// Returns the context's error if the work didn't finish in time.
+func Flush(ctx context.Context) error {
+	backgroundMu.Lock()
+	idle := backgroundIdle
+	backgroundMu.Unlock()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		logError(ctx, "Work still running off the request path on flush: %s", ctx.Err().Error())
+		return ctx.Err()
+	}
+
+	if usageTable != nil {
+		if err := exportUsage(ctx); err != nil {
+			logError(ctx, "Failed exporting usage: %s", err.Error())
+		}
+	}
+
+	if otlpMetricsURL != "" {
+		if err := exportMetrics(ctx); err != nil {
+			logError(ctx, "Failed exporting metrics: %s", err.Error())
+		}
+	}
+
+	return ctx.Err()
+}
+
+// serveFlush flushes on request, answering once done
+func serveFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := Flush(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, errorFlushTimeout)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
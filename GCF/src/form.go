@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+const contentTypeForm = "application/x-www-form-urlencoded"
+
+// Payload formats of converted form payloads
+const (
+	formatInteractivity = "interactivity"
+	formatCommand       = "command"
+)
+
+// Attributes letting consumers respond to slash commands and interactivity payloads without parsing them
+const (
+	attrResponseURL = "response_url"
+	attrTriggerID   = "trigger_id"
+)
+
+// Accept form-encoded slash commands and interactivity payloads
+var formPayloads bool
+
+// setupFormPayloads configures the form-encoded payloads from the environment
+func setupFormPayloads() {
+	formPayloads = getenv("FORM_PAYLOADS") == "true"
+}
+
+// isFormRequest returns true if the request is form-encoded
+func isFormRequest(r *http.Request) bool {
+	return r.Header.Get("Content-Type") == contentTypeForm
+}
+
+// convertForm converts a verified form-encoded payload to JSON, returning it along with its format.
+// Interactivity payloads carry JSON in their payload field, which is unwrapped.
+// Slash commands are converted to a JSON object of their fields.
+func convertForm(body []byte) ([]byte, string, error) {
+	form, err := url.ParseQuery(byteSliceToString(body))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if payload := form.Get("payload"); payload != "" {
+		if !json.Valid([]byte(payload)) {
+			return nil, "", errors.New("invalid interactivity payload")
+		}
+		return []byte(payload), formatInteractivity, nil
+	}
+
+	// Slash commands never repeat fields
+	fields := make(map[string]string, len(form))
+	for key := range form {
+		fields[key] = form.Get(key)
+	}
+
+	data, err := json.Marshal(fields)
+	return data, formatCommand, err
+}
+
+// attachResponseAttributes attaches the response_url and trigger_id of the payload, if any
+func attachResponseAttributes(payload *slackPayload, attributes map[string]string) {
+	if payload.ResponseURL != "" {
+		attributes[attrResponseURL] = payload.ResponseURL
+	}
+	if payload.TriggerID != "" {
+		attributes[attrTriggerID] = payload.TriggerID
+	}
+}
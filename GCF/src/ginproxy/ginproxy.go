@@ -0,0 +1,31 @@
+// Package ginproxy mounts the proxy in gin, for services embedding it in an existing API.
+package ginproxy
+
+import (
+	"net/http"
+
+	proxy "github.com/bharel/SlackFunctionsProxy"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler returns a handler proxying Slack requests
+func Handler() gin.HandlerFunc {
+	return gin.WrapF(proxy.Proxy)
+}
+
+// Verify returns a middleware verifying Slack requests before the next handlers.
+// Invalid requests are responded to and aborted.
+func Verify() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		verified := false
+		proxy.Verify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			verified = true
+			c.Request = r
+			c.Next()
+		})).ServeHTTP(c.Writer, c.Request)
+
+		if !verified {
+			c.Abort()
+		}
+	}
+}
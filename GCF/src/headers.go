@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Route whose headers apply to every path
+const allRoutes = "*"
+
+// Response headers by route path, nil if none are configured
+var responseHeaders map[string]map[string]string
+
+// setupResponseHeaders configures the response headers from the environment.
+// RESPONSE_HEADERS is a JSON object mapping a path, or "*" for all paths, to headers,
+// e.g. {"*": {"Cache-Control": "no-store"}, "/commands": {"X-Slack-No-Retry": "1"}}
+func setupResponseHeaders() {
+	value := getenv("RESPONSE_HEADERS")
+	if value == "" {
+		return
+	}
+
+	if err := json.Unmarshal([]byte(value), &responseHeaders); err != nil {
+		configErrorf("Invalid RESPONSE_HEADERS: %s.", err.Error())
+	}
+}
+
+// setResponseHeaders sets the configured headers of the request's route.
+// Headers of the path override the ones of all paths.
+func setResponseHeaders(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	for name, value := range responseHeaders[allRoutes] {
+		header.Set(name, value)
+	}
+	for name, value := range responseHeaders[r.URL.Path] {
+		header.Set(name, value)
+	}
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	// Message attribute holding a stable key of the event, for idempotent consumers
+	attrIdempotencyKey = "idempotency_key"
+
+	// Message attribute holding the hex SHA-256 of the raw request body, as signed by Slack
+	attrBodySHA256 = "body_sha256"
+)
+
+// idempotencyKey returns a key identifying the event across Slack's retries.
+// Prefers the event id, falling back to a hash of the body and timestamp for payloads without one.
+func idempotencyKey(r *http.Request, payload *slackPayload, body []byte) string {
+	if payload.EventID != "" {
+		return payload.EventID
+	}
+
+	hash := sha256.New()
+	hash.Write(body)
+	hash.Write([]byte(r.Header.Get("X-Slack-Request-Timestamp")))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// bodyHash returns the hex SHA-256 of the raw body.
+// Unlike the message data, it isn't affected by transforms, encodings or form decoding.
+func bodyHash(body []byte) string {
+	hash := sha256.Sum256(body)
+	return hex.EncodeToString(hash[:])
+}
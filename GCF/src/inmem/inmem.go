@@ -0,0 +1,68 @@
+// Package inmem is an in-memory publisher for unit tests of programs embedding the proxy,
+// standing in for Pub/Sub without the emulator:
+//
+//	pub := inmem.NewPublisher()
+//	proxy.SetPublisher(pub)
+package inmem
+
+import (
+	"context"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Publisher records the published messages in-memory
+type Publisher struct {
+	mu       sync.Mutex
+	messages []*pubsub.Message
+	err      error
+}
+
+// NewPublisher creates an empty publisher
+func NewPublisher() *Publisher {
+	return &Publisher{}
+}
+
+// Publish records a copy of the message, or fails with the error set by SetError
+func (p *Publisher) Publish(ctx context.Context, msg *pubsub.Message) error {
+	attributes := make(map[string]string, len(msg.Attributes))
+	for name, value := range msg.Attributes {
+		attributes[name] = value
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	p.messages = append(p.messages, &pubsub.Message{
+		Data:        append([]byte(nil), msg.Data...),
+		Attributes:  attributes,
+		OrderingKey: msg.OrderingKey,
+	})
+	return nil
+}
+
+// Messages returns the messages published so far, in order
+func (p *Publisher) Messages() []*pubsub.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*pubsub.Message(nil), p.messages...)
+}
+
+// Reset forgets the messages published so far
+func (p *Publisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = nil
+}
+
+// SetError makes the publishes fail with the error, e.g. to test retries and alerting. nil restores them.
+func (p *Publisher) SetError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
@@ -0,0 +1,71 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestPublisherRecordsCopies(t *testing.T) {
+	p := NewPublisher()
+	msg := &pubsub.Message{Data: []byte("{}"), Attributes: map[string]string{"a": "1"}, OrderingKey: "T1"}
+	if err := p.Publish(context.Background(), msg); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+
+	// The publisher may reuse the message, e.g. on another attempt
+	msg.Attributes["a"] = "2"
+	msg.Data[0] = '['
+
+	got := p.Messages()
+	if len(got) != 1 {
+		t.Fatalf("got %d messages, want 1", len(got))
+	}
+	if string(got[0].Data) != "{}" || got[0].Attributes["a"] != "1" || got[0].OrderingKey != "T1" {
+		t.Errorf("recorded %+v, want the message as published", got[0])
+	}
+}
+
+func TestPublisherError(t *testing.T) {
+	p := NewPublisher()
+	failure := errors.New("unavailable")
+
+	p.SetError(failure)
+	if err := p.Publish(context.Background(), &pubsub.Message{}); !errors.Is(err, failure) {
+		t.Errorf("Publish() = %v, want %v", err, failure)
+	}
+	if n := len(p.Messages()); n != 0 {
+		t.Errorf("recorded %d failed messages", n)
+	}
+
+	p.SetError(nil)
+	if err := p.Publish(context.Background(), &pubsub.Message{}); err != nil {
+		t.Errorf("Publish() once restored = %v", err)
+	}
+}
+
+func TestPublisherConcurrent(t *testing.T) {
+	p := NewPublisher()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Publish(context.Background(), &pubsub.Message{Attributes: map[string]string{"a": "1"}})
+			p.Messages()
+		}()
+	}
+	wg.Wait()
+
+	if n := len(p.Messages()); n != 50 {
+		t.Errorf("got %d messages, want 50", n)
+	}
+	p.Reset()
+	if n := len(p.Messages()); n != 0 {
+		t.Errorf("got %d messages once reset, want 0", n)
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a compiled JSONPath expression of the subset selecting a single value:
+// $.field, $['field'] and $.list[0], in any combination
+type jsonPath []jsonPathStep
+
+// jsonPathStep selects a field of an object, or an element of a list if field is empty
+type jsonPathStep struct {
+	field string
+	index int
+}
+
+// pathAttribute attaches the value at a JSONPath of the payload as an attribute
+type pathAttribute struct {
+	name string
+	path jsonPath
+}
+
+// setupPathAttributes configures the extracted attributes from the environment.
+// ATTRIBUTE_PATHS is a comma separated list of name=path, e.g. thread_ts=$.event.thread_ts
+func setupPathAttributes(live *liveConfig) {
+	value := getenv("ATTRIBUTE_PATHS")
+	if value == "" {
+		return
+	}
+
+	for _, rule := range strings.Split(value, ",") {
+		name, expr, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+		if !ok || name == "" || strings.HasPrefix(name, "goog") {
+			configErrorf("Invalid ATTRIBUTE_PATHS rule: %s.", rule)
+			continue
+		}
+
+		path, err := compileJSONPath(expr)
+		if err != nil {
+			configErrorf("Invalid ATTRIBUTE_PATHS path of %s: %s.", name, err.Error())
+			continue
+		}
+		live.pathAttributes = append(live.pathAttributes, pathAttribute{name: name, path: path})
+	}
+}
+
+// compileJSONPath compiles a JSONPath expression
+func compileJSONPath(expr string) (jsonPath, error) {
+	rest, ok := strings.CutPrefix(expr, "$")
+	if !ok {
+		return nil, errors.New("must start with $")
+	}
+
+	var path jsonPath
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			field := rest[1 : end+1]
+			if field == "" {
+				return nil, errors.New("empty field name")
+			}
+			path = append(path, jsonPathStep{field: field})
+			rest = rest[end+1:]
+
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, errors.New("unterminated ['")
+			}
+			path = append(path, jsonPathStep{field: rest[2:end]})
+			rest = rest[end+2:]
+
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.New("unterminated [")
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index %s", rest[1:end])
+			}
+			path = append(path, jsonPathStep{index: index})
+			rest = rest[end+1:]
+
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest)
+		}
+	}
+	return path, nil
+}
+
+// lookup returns the value at the path, or false if missing
+func (p jsonPath) lookup(value any) (any, bool) {
+	for _, step := range p {
+		if step.field != "" {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[step.field]; !ok {
+				return nil, false
+			}
+			continue
+		}
+
+		list, ok := value.([]any)
+		if !ok || step.index >= len(list) {
+			return nil, false
+		}
+		value = list[step.index]
+	}
+	return value, true
+}
+
+// attachPathAttributes attaches the values of the payload at the configured paths.
+// Strings are attached as is, other values as JSON. Missing and null values are skipped.
+func (live *liveConfig) attachPathAttributes(data []byte, attributes map[string]string) {
+	var payload any
+	if json.Unmarshal(data, &payload) != nil {
+		return
+	}
+
+	for _, attr := range live.pathAttributes {
+		value, ok := attr.path.lookup(payload)
+		if !ok || value == nil {
+			continue
+		}
+
+		if s, ok := value.(string); ok {
+			attributes[attr.name] = s
+		} else if encoded, err := json.Marshal(value); err == nil {
+			attributes[attr.name] = byteSliceToString(encoded)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"context"
+	"time"
+)
+
+// setupKeepalive starts the Pub/Sub keepalive from the environment.
+// PUBSUB_KEEPALIVE is the interval of the no-op calls keeping the connection alive.
+func setupKeepalive() {
+	interval := configDuration("PUBSUB_KEEPALIVE", 0, false)
+	if interval == 0 || topic == nil {
+		return
+	}
+
+	go keepalive(interval)
+}
+
+// keepalive periodically makes a lightweight call over the Pub/Sub connection,
+// so it is re-established in the background after long idle periods rather than by the next event.
+func keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if _, err := topic.Exists(ctx); err != nil {
+			logWarning(ctx, "Pub/Sub keepalive failed: %s", err.Error())
+		}
+		cancel()
+	}
+}
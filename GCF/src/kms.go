@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"strings"
+
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+// Suffix of the variables holding KMS ciphertexts of other variables, e.g. SLACK_SIGNING_SECRET_ENC
+const encryptedConfigSuffix = "_ENC"
+
+// decryptConfigSecrets decrypts the <NAME>_ENC variables using the Cloud KMS key at KMS_KEY,
+// for deployments allowed to pass env vars but not plaintext secrets
+func decryptConfigSecrets() {
+	names := encryptedConfigNames()
+	if len(names) == 0 {
+		return
+	}
+
+	key := getenv("KMS_KEY")
+	if key == "" {
+		configErrorf("KMS_KEY env var must be set when %s is set.", names[0])
+		return
+	}
+
+	ctx := context.Background()
+	service, err := cloudkms.NewService(ctx, egressClientOptions()...)
+	if err != nil {
+		configErrorf("Failed creating a KMS client: %s.", err.Error())
+		return
+	}
+
+	secrets := make(configValues, len(names))
+	for _, name := range names {
+		// The ciphertext is base64 encoded, as the API expects it
+		resp, err := service.Projects.Locations.KeyRings.CryptoKeys.Decrypt(key, &cloudkms.DecryptRequest{
+			Ciphertext: strings.TrimSpace(getenv(name)),
+		}).Context(ctx).Do()
+		if err != nil {
+			configErrorf("Failed decrypting %s: %s.", name, err.Error())
+			continue
+		}
+
+		plaintext, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+		if err != nil {
+			configErrorf("Failed decrypting %s: %s.", name, err.Error())
+			continue
+		}
+		secrets[strings.TrimSuffix(name, encryptedConfigSuffix)] = string(plaintext)
+	}
+
+	configMu.Lock()
+	configDecrypted = secrets
+	configMu.Unlock()
+}
+
+// encryptedConfigNames returns the names of the encrypted variables of all sources
+func encryptedConfigNames() []string {
+	seen := map[string]bool{}
+	add := func(name string) {
+		if strings.HasSuffix(name, encryptedConfigSuffix) && len(name) > len(encryptedConfigSuffix) {
+			seen[name] = true
+		}
+	}
+
+	for _, env := range os.Environ() {
+		name, _, _ := strings.Cut(env, "=")
+		add(name)
+	}
+
+	configMu.Lock()
+	for _, source := range []configValues{configOverrides, configVault, configFile} {
+		for name := range source {
+			add(name)
+		}
+	}
+	configMu.Unlock()
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const (
+	reasonBadToken      = "bad_token"
+	reasonMalformedForm = "malformed_form"
+)
+
+// Message attribute marking the format of converted payloads
+const attrPayloadFormat = "payload_format"
+
+const formatOutgoingWebhook = "outgoing_webhook"
+
+// Maximum size of an ssl_check probe
+const maxSSLCheckSize = 4096
+
+var (
+	// Legacy verification token, checked in addition to the signature. Empty if disabled.
+	slackVerificationToken string
+
+	// Accept form-encoded outgoing webhooks
+	legacyOutgoingWebhooks bool
+)
+
+// setupLegacy configures the legacy Slack compatibility from the environment
+func setupLegacy() {
+	slackVerificationToken = getenv("SLACK_VERIFICATION_TOKEN")
+
+	if getenv("LEGACY_OUTGOING_WEBHOOKS") == "true" {
+		// Outgoing webhooks aren't signed, the token is their only authentication
+		if slackVerificationToken == "" {
+			configErrorf("SLACK_VERIFICATION_TOKEN env var must be set when LEGACY_OUTGOING_WEBHOOKS is enabled.")
+		}
+		legacyOutgoingWebhooks = true
+	}
+}
+
+// isSSLCheck returns true if the request is Slack's ssl_check probe.
+// Slack sends these form-encoded to slash command URLs, to verify their certificate.
+// Reads the body but restores it before returning.
+func isSSLCheck(r *http.Request) bool {
+	if r.Method != http.MethodPost || !isFormRequest(r) {
+		return false
+	}
+
+	form, ok := peekForm(r, maxSSLCheckSize)
+	if !ok || form.Get("ssl_check") != "1" {
+		return false
+	}
+
+	// Probes carry the verification token, if the app has one
+	return slackVerificationToken == "" || isValidVerificationToken(form.Get("token"))
+}
+
+// peekForm parses the form-encoded body of at most limit bytes, restoring the body before returning
+func peekForm(r *http.Request, limit int64) (url.Values, bool) {
+	if r.Body == nil || r.ContentLength <= 0 || r.ContentLength > limit {
+		return nil, false
+	}
+
+	body := make([]byte, r.ContentLength)
+	if _, err := io.ReadFull(r.Body, body); err != nil {
+		return nil, false
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	form, err := url.ParseQuery(byteSliceToString(body))
+	return form, err == nil
+}
+
+// serveSSLCheck acknowledges an ssl_check probe
+func serveSSLCheck(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// isValidVerificationToken compares the token to the legacy verification token in constant time
+func isValidVerificationToken(token string) bool {
+	return subtle.ConstantTimeCompare(stringToByteSlice(&token), stringToByteSlice(&slackVerificationToken)) == 1
+}
+
+// isURLVerification returns true if the payload is Slack's url_verification handshake,
+// sent when the request URL of the Events API is set
+func isURLVerification(payload *slackPayload) bool {
+	return payload.Type == "url_verification"
+}
+
+// serveChallenge answers a verified url_verification handshake with its challenge
+func serveChallenge(w http.ResponseWriter, payload *slackPayload) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.WriteString(w, payload.Challenge)
+}
+
+// isOutgoingWebhook returns true if the request should be handled as a legacy outgoing webhook.
+// Outgoing webhooks are unsigned, and carry the token and trigger_word fields.
+// Signed form-encoded requests, such as slash commands, always go through the signature check.
+// Reads the body but restores it before returning.
+func isOutgoingWebhook(r *http.Request) bool {
+	if !legacyOutgoingWebhooks || r.Method != http.MethodPost ||
+		!isFormRequest(r) || r.Header.Get("X-Slack-Signature") != "" {
+		return false
+	}
+
+	form, ok := peekForm(r, maxBodySize)
+	return ok && form.Has("token") && form.Has("trigger_word")
+}
+
+// validateOutgoingWebhook validates a legacy outgoing webhook by its token.
+// On success, replaces the form-encoded body with its JSON equivalent, so it's handled like any other payload.
+// Returns 0 if valid, HTTP status code and rejection reason otherwise
+func validateOutgoingWebhook(r *http.Request) (int, string) {
+	if r.ContentLength > maxBodySize {
+		return http.StatusRequestEntityTooLarge, reasonOversized
+	}
+
+	if r.ContentLength <= 0 || r.Body == nil {
+		return http.StatusBadRequest, reasonEmptyBody
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, reasonEmptyBody
+	}
+
+	form, err := url.ParseQuery(byteSliceToString(body))
+	if err != nil {
+		return http.StatusBadRequest, reasonMalformedForm
+	}
+
+	if !isValidVerificationToken(form.Get("token")) {
+		return http.StatusUnauthorized, reasonBadToken
+	}
+
+	// Outgoing webhooks never repeat fields
+	fields := make(map[string]string, len(form))
+	for key := range form {
+		fields[key] = form.Get(key)
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return http.StatusBadRequest, reasonMalformedForm
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	return 0, ""
+}
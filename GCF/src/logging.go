@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Cloud Logging severities
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#logseverity
+const (
+	severityInfo    = "INFO"
+	severityWarning = "WARNING"
+	severityError   = "ERROR"
+)
+
+var (
+	// Write structured Cloud Logging entries rather than plain text
+	structuredLogging bool
+
+	// Project the traces belong to
+	traceProject string
+
+	// Labels attached to every structured entry
+	logLabels map[string]string
+)
+
+// setupLogging configures the log format from the environment.
+// LOG_FORMAT is json or text, and defaults to json when running on GCP.
+func setupLogging() {
+	switch format := getenv("LOG_FORMAT"); format {
+	case "":
+		structuredLogging = getenv("K_SERVICE") != "" || getenv("FUNCTION_TARGET") != ""
+	case "json":
+		structuredLogging = true
+	case "text":
+		structuredLogging = false
+	default:
+		configErrorf("Unknown LOG_FORMAT: %s.", format)
+	}
+
+	traceProject = getenv("GCP_PROJECT")
+	logLabels = map[string]string{"service": serviceName()}
+
+	// LOG_LABELS is a comma separated list of key=value
+	if labels := getenv("LOG_LABELS"); labels != "" {
+		for _, label := range strings.Split(labels, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(label), "=")
+			if !ok || key == "" {
+				configErrorf("Invalid LOG_LABELS label: %s.", label)
+				continue
+			}
+			logLabels[key] = value
+		}
+	}
+}
+
+// requestTrace identifies the trace of a request
+type requestTrace struct {
+	traceID string
+	spanID  string
+	sampled bool
+}
+
+type traceContextKey struct{}
+
+// withTrace attaches the trace of the request, if any, to its context.
+// Supports both X-Cloud-Trace-Context and W3C traceparent headers.
+func withTrace(r *http.Request) *http.Request {
+	var trace requestTrace
+
+	if header := r.Header.Get("X-Cloud-Trace-Context"); header != "" {
+		// TRACE_ID/SPAN_ID;o=OPTIONS
+		ids, options, _ := strings.Cut(header, ";")
+		trace.traceID, trace.spanID, _ = strings.Cut(ids, "/")
+		trace.sampled = options == "o=1"
+	} else if header := r.Header.Get("traceparent"); header != "" {
+		// VERSION-TRACE_ID-SPAN_ID-FLAGS
+		if parts := strings.Split(header, "-"); len(parts) == 4 {
+			trace.traceID, trace.spanID = parts[1], parts[2]
+			trace.sampled = strings.HasSuffix(parts[3], "1")
+		}
+	}
+
+	if trace.traceID == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), traceContextKey{}, trace))
+}
+
+// addTraceFields adds the trace correlation fields to a structured entry
+func addTraceFields(ctx context.Context, entry map[string]any) {
+	trace, ok := ctx.Value(traceContextKey{}).(requestTrace)
+	if !ok {
+		return
+	}
+
+	entry["logging.googleapis.com/trace"] = fmt.Sprintf("projects/%s/traces/%s", traceProject, trace.traceID)
+	if trace.spanID != "" {
+		entry["logging.googleapis.com/spanId"] = trace.spanID
+	}
+	entry["logging.googleapis.com/trace_sampled"] = trace.sampled
+}
+
+// writeStructured writes a structured entry to the given stream, adding the severity, trace and labels
+func writeStructured(ctx context.Context, out *os.File, severity string, entry map[string]any) {
+	entry["severity"] = severity
+	if _, ok := entry["logging.googleapis.com/labels"]; !ok {
+		entry["logging.googleapis.com/labels"] = logLabels
+	}
+	addTraceFields(ctx, entry)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed encoding log entry: %s", err.Error())
+		return
+	}
+	fmt.Fprintln(out, byteSliceToString(data))
+}
+
+// logf logs a message with the given severity, correlated to the request of the context
+func logf(ctx context.Context, severity, format string, args ...any) {
+	if !structuredLogging {
+		log.Printf(format, args...)
+		return
+	}
+	writeStructured(ctx, os.Stderr, severity, map[string]any{"message": fmt.Sprintf(format, args...)})
+}
+
+func logInfo(ctx context.Context, format string, args ...any) {
+	logf(ctx, severityInfo, format, args...)
+}
+
+func logWarning(ctx context.Context, format string, args ...any) {
+	logf(ctx, severityWarning, format, args...)
+}
+
+func logError(ctx context.Context, format string, args ...any) {
+	logf(ctx, severityError, format, args...)
+}
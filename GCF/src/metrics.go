@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// counterVec is a monotonic counter partitioned by a single label
+type counterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.RWMutex
+	values map[string]*atomic.Uint64
+}
+
+var (
+	// All registered counters, in exposition order
+	counters []*counterVec
+
+	// Path serving the metrics, empty if disabled
+	metricsPath string
+
+	// Bearer token required by the metrics path
+	metricsToken string
+)
+
+var rejectedRequests = newCounterVec("slack_proxy_requests_rejected_total",
+	"Requests rejected before publishing, by rejection reason.", "reason")
+
+// newCounterVec creates and registers a new counter
+func newCounterVec(name, help, label string) *counterVec {
+	c := &counterVec{
+		name:   name,
+		help:   help,
+		label:  label,
+		values: map[string]*atomic.Uint64{},
+	}
+	counters = append(counters, c)
+	return c
+}
+
+// Inc increments the counter for the given label value
+func (c *counterVec) Inc(value string) {
+	c.Add(value, 1)
+}
+
+// Add adds n to the counter for the given label value
+func (c *counterVec) Add(value string, n uint64) {
+	c.mu.RLock()
+	v, ok := c.values[value]
+	c.mu.RUnlock()
+
+	if !ok {
+		c.mu.Lock()
+		if v, ok = c.values[value]; !ok {
+			v = new(atomic.Uint64)
+			c.values[value] = v
+		}
+		c.mu.Unlock()
+	}
+
+	v.Add(n)
+}
+
+// snapshot returns the current value of every label value
+func (c *counterVec) snapshot() map[string]uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	values := make(map[string]uint64, len(c.values))
+	for label, value := range c.values {
+		values[label] = value.Load()
+	}
+	return values
+}
+
+// writeTo writes the counter in the Prometheus text exposition format
+func (c *counterVec) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	labels := make([]string, 0, len(c.values))
+	for label := range c.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, label, c.values[label].Load())
+	}
+}
+
+// setupMetrics configures the metrics endpoint from the environment.
+// The path is served alongside the proxy, so it requires a bearer token.
+func setupMetrics() {
+	metricsPath = getenv("METRICS_PATH")
+	metricsToken = getenv("METRICS_TOKEN")
+	if metricsPath != "" && metricsToken == "" {
+		configErrorf("METRICS_TOKEN env var must be set when METRICS_PATH is set.")
+	}
+}
+
+// isMetricsRequest returns true if the request should be served the metrics
+func isMetricsRequest(r *http.Request) bool {
+	return metricsPath != "" && r.Method == http.MethodGet && r.URL.Path == metricsPath
+}
+
+// serveMetrics writes all registered counters, if the request carries METRICS_TOKEN
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !hasBearerToken(r, metricsToken) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, c := range counters {
+		c.writeTo(w)
+	}
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	slackAuthorizeURL = "https://slack.com/oauth/v2/authorize"
+
+	// Cookie holding the OAuth state between the redirect and the callback
+	oauthStateCookie = "slack_oauth_state"
+)
+
+var (
+	slackClientID     string
+	slackClientSecret string
+	slackOAuthScopes  string
+
+	// Where to send the installing user afterwards, empty to show a plain message
+	oauthSuccessURL string
+
+	// Template of per-tenant topic ids to create on install, nil if disabled
+	tenantTopicTemplate *template.Template
+)
+
+// setupOAuth configures the OAuth install flow from the environment.
+// The flow is served by the OAuthCallback function, and requires the tenant registry.
+func setupOAuth() {
+	slackClientID = getenv("SLACK_CLIENT_ID")
+	if slackClientID == "" {
+		return
+	}
+
+	if getenv("TENANT_REGISTRY") == "" {
+		configErrorf("TENANT_REGISTRY env var must be set when SLACK_CLIENT_ID is set.")
+	}
+
+	slackClientSecret = getenv("SLACK_CLIENT_SECRET")
+	if slackClientSecret == "" {
+		configErrorf("SLACK_CLIENT_SECRET env var must be set when SLACK_CLIENT_ID is set.")
+	}
+
+	slackOAuthScopes = getenv("SLACK_OAUTH_SCOPES")
+	oauthSuccessURL = getenv("OAUTH_SUCCESS_URL")
+
+	if text := getenv("TENANT_TOPIC_TEMPLATE"); text != "" {
+		var err error
+		tenantTopicTemplate, err = template.New("tenant-topic").Option("missingkey=error").Parse(text)
+		if err != nil {
+			configErrorf("Invalid TENANT_TOPIC_TEMPLATE: %s.", err.Error())
+		}
+	}
+}
+
+// OAuthCallback installs the Slack app in a workspace.
+// Without a code, redirects to Slack's authorization page.
+// Once Slack redirects back with a code, exchanges it for a bot token and
+// registers the tenant, creating its topic if TENANT_TOPIC_TEMPLATE is set.
+// https://api.slack.com/authentication/oauth-v2
+func OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	Setup()
+
+	if slackClientID == "" {
+		http.Error(w, "OAuth is not configured.", http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, "Installation cancelled: "+reason, http.StatusBadRequest)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		redirectToAuthorize(w, r)
+		return
+	}
+
+	// Protect against CSRF by matching the state to the one we issued
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		http.Error(w, "Invalid OAuth state, please restart the installation.", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/", MaxAge: -1})
+
+	t, err := exchangeOAuthCode(r.Context(), code, callbackURL(r))
+	if err != nil {
+		logError(r.Context(), "Failed exchanging OAuth code: %s", err.Error())
+		http.Error(w, "Installation failed.", http.StatusBadGateway)
+		return
+	}
+
+	if err := onboardTenant(r.Context(), t); err != nil {
+		logError(r.Context(), "Failed onboarding tenant %s: %s", t.TeamID, err.Error())
+		reportError(fmt.Errorf("failed onboarding tenant %s: %w", t.TeamID, err), r)
+		http.Error(w, "Installation failed.", http.StatusInternalServerError)
+		return
+	}
+
+	logInfo(r.Context(), "Installed in workspace %s.", t.TeamID)
+
+	if oauthSuccessURL != "" {
+		http.Redirect(w, r, oauthSuccessURL, http.StatusFound)
+		return
+	}
+	fmt.Fprintln(w, "Installation complete.")
+}
+
+// callbackURL returns the URL Slack redirects back to, which is this function
+func callbackURL(r *http.Request) string {
+	scheme := "https"
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+}
+
+// redirectToAuthorize starts the flow, storing a random state in a cookie
+func redirectToAuthorize(w http.ResponseWriter, r *http.Request) {
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    hex.EncodeToString(state),
+		Path:     "/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	params := url.Values{
+		"client_id":    {slackClientID},
+		"scope":        {slackOAuthScopes},
+		"state":        {hex.EncodeToString(state)},
+		"redirect_uri": {callbackURL(r)},
+	}
+	http.Redirect(w, r, slackAuthorizeURL+"?"+params.Encode(), http.StatusFound)
+}
+
+// exchangeOAuthCode exchanges the temporary code for the workspace's bot token
+func exchangeOAuthCode(ctx context.Context, code, redirectURI string) (*tenant, error) {
+	form := url.Values{
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackAPIURL+"oauth.v2.access",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(slackClientID, slackClientSecret)
+
+	resp, err := slackAPIClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Error       string `json:"error"`
+		AccessToken string `json:"access_token"`
+		Team        struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"team"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.OK {
+		return nil, fmt.Errorf("oauth.v2.access returned error %s", result.Error)
+	}
+	if result.Team.ID == "" {
+		return nil, fmt.Errorf("oauth.v2.access returned no team")
+	}
+
+	return &tenant{TeamID: result.Team.ID, BotToken: result.AccessToken}, nil
+}
+
+// onboardTenant creates the tenant's topic if configured, and registers the tenant
+func onboardTenant(ctx context.Context, t *tenant) error {
+	// The registry may have been removed by a reload
+	tenants := liveConfigFrom(ctx).tenants
+	if tenants == nil {
+		return errors.New("tenant registry is disabled")
+	}
+
+	if tenantTopicTemplate != nil {
+		var name strings.Builder
+		if err := tenantTopicTemplate.Execute(&name, map[string]string{"team_id": t.TeamID}); err != nil {
+			return err
+		}
+
+		if !topicNamePattern.MatchString(name.String()) {
+			return fmt.Errorf("invalid topic name %q", name.String())
+		}
+
+		exists, err := openTopic(name.String()).Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := createTopic(ctx, name.String()); err != nil {
+				return err
+			}
+			logInfo(ctx, "Created topic %s.", name.String())
+		}
+		t.Topic = name.String()
+	}
+
+	return tenants.Install(ctx, t)
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Payload type of the options requests of external select menus
+const payloadBlockSuggestion = "block_suggestion"
+
+const errorOptionsLoad = "options_load_failed"
+
+const (
+	defaultOptionsCacheTTL = 5 * time.Second
+	defaultOptionsTimeout  = 2 * time.Second
+
+	// Slack limits options responses well below this
+	maxOptionsResponseSize = 1024 * 1024
+)
+
+var (
+	// URL serving the options of external select menus, empty if disabled
+	optionsLoadURL string
+
+	optionsTimeout = defaultOptionsTimeout
+	optionsCache   cache
+	optionsClient  = &http.Client{}
+)
+
+// setupOptionsLoad configures the synchronous options loading from the environment
+func setupOptionsLoad() {
+	optionsLoadURL = getenv("OPTIONS_LOAD_URL")
+	if optionsLoadURL == "" {
+		return
+	}
+
+	if u, err := url.Parse(optionsLoadURL); err != nil || u.Host == "" {
+		configErrorf("OPTIONS_LOAD_URL must be a valid URL.")
+	}
+	if !formPayloads {
+		configErrorf("FORM_PAYLOADS must be enabled when OPTIONS_LOAD_URL is set.")
+	}
+
+	optionsTimeout = configDuration("OPTIONS_LOAD_TIMEOUT", defaultOptionsTimeout, false)
+	optionsCache = newCacheFromEnv("OPTIONS_CACHE", configDuration("OPTIONS_CACHE_TTL", defaultOptionsCacheTTL, false))
+}
+
+// optionsCacheKey returns the cache key of the options of a block_suggestion payload.
+// The fields are hashed as a JSON array, so typed values containing separators can't collide.
+func optionsCacheKey(payload *slackPayload) string {
+	fields, _ := json.Marshal([]string{payload.TeamID, payload.BlockID, payload.ActionID, payload.Value})
+	return "options:" + bodyHash(fields)
+}
+
+// isOptionsRequest returns true if the payload should be answered with options synchronously
+func isOptionsRequest(payload *slackPayload) bool {
+	return optionsLoadURL != "" && payload.Type == payloadBlockSuggestion
+}
+
+// serveOptions answers a block_suggestion request with the options of the backend.
+// Responses are cached by team, block, action and typed value, so repeated keystrokes don't hammer the backend.
+func serveOptions(w http.ResponseWriter, r *http.Request, payload *slackPayload, data []byte) {
+	ctx := r.Context()
+	key := optionsCacheKey(payload)
+
+	options, ok := optionsCache.Get(ctx, key)
+	if !ok {
+		var err error
+		if options, err = loadOptions(ctx, data); err != nil {
+			writeError(w, http.StatusInternalServerError, errorOptionsLoad)
+			logError(ctx, "Failed loading options of %s: %s", payload.ActionID, err.Error())
+			return
+		}
+		optionsCache.Set(ctx, key, options)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, options)
+}
+
+// loadOptions posts the payload to the options backend, returning its response
+func loadOptions(ctx context.Context, data []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, stageTimeout(ctx, optionsTimeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, optionsLoadURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := optionsClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+
+	options, err := io.ReadAll(io.LimitReader(resp.Body, maxOptionsResponseSize))
+	return byteSliceToString(options), err
+}
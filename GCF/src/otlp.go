@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultOTLPExportInterval = 60 * time.Second
+
+	// Time an export on the request path is allowed, after the response is sent
+	otlpRequestExportTimeout = time.Second
+)
+
+var (
+	// OTLP/HTTP metrics endpoint, export is disabled if empty
+	otlpMetricsURL     string
+	otlpHeaders        = map[string]string{}
+	otlpExportInterval = defaultOTLPExportInterval
+	otlpClient         = &http.Client{Timeout: 10 * time.Second}
+
+	// Export from the requests rather than in the background, for CPU allocated only during requests
+	otlpExportOnRequest bool
+
+	// Unix time in nanoseconds of the last export
+	lastOTLPExport atomic.Int64
+
+	// Start of the cumulative counters
+	processStart = time.Now()
+)
+
+// setupOTLP configures the OTLP metrics export from the standard OpenTelemetry env vars
+// https://opentelemetry.io/docs/specs/otel/protocol/exporter/
+func setupOTLP() {
+	if endpoint := getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"); endpoint != "" {
+		otlpMetricsURL = endpoint
+	} else if endpoint := getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		otlpMetricsURL = strings.TrimSuffix(endpoint, "/") + "/v1/metrics"
+	} else {
+		return
+	}
+
+	if headers := getenv("OTEL_EXPORTER_OTLP_HEADERS"); headers != "" {
+		for _, header := range strings.Split(headers, ",") {
+			name, value, ok := strings.Cut(header, "=")
+			if !ok {
+				configErrorf("Invalid OTEL_EXPORTER_OTLP_HEADERS header: %s.", header)
+				continue
+			}
+			otlpHeaders[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	// In milliseconds
+	ms := configInt("OTEL_METRIC_EXPORT_INTERVAL", int(defaultOTLPExportInterval.Milliseconds()))
+	otlpExportInterval = time.Duration(ms) * time.Millisecond
+	lastOTLPExport.Store(time.Now().UnixNano())
+
+	otlpExportOnRequest = getenv("OTEL_METRIC_EXPORT_ON_REQUEST") == "true"
+	if !otlpExportOnRequest {
+		go exportMetricsPeriodically()
+	}
+}
+
+// exportMetricsPeriodically pushes the counters every export interval
+func exportMetricsPeriodically() {
+	ticker := time.NewTicker(otlpExportInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), otlpExportInterval)
+		if err := exportMetrics(ctx); err != nil {
+			logError(ctx, "Failed exporting metrics: %s", err.Error())
+		}
+		lastOTLPExport.Store(time.Now().UnixNano())
+		cancel()
+	}
+}
+
+// withMetricsExport wraps a handler, pushing the counters once the response is sent
+// if the last export is older than the export interval. A single request exports at a time.
+func withMetricsExport(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+
+		if !otlpExportOnRequest {
+			return
+		}
+
+		last := lastOTLPExport.Load()
+		if time.Since(time.Unix(0, last)) < otlpExportInterval ||
+			!lastOTLPExport.CompareAndSwap(last, time.Now().UnixNano()) {
+			return
+		}
+
+		// Let Slack have the response before exporting
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), otlpRequestExportTimeout)
+		defer cancel()
+		if err := exportMetrics(ctx); err != nil {
+			logError(r.Context(), "Failed exporting metrics: %s", err.Error())
+		}
+	}
+}
+
+// OTLP/JSON encoding of the counters
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+type (
+	otlpAttribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	otlpDataPoint struct {
+		Attributes        []otlpAttribute `json:"attributes"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		AsInt             string          `json:"asInt"`
+	}
+	otlpMetric struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Sum         struct {
+			DataPoints []otlpDataPoint `json:"dataPoints"`
+			// AGGREGATION_TEMPORALITY_CUMULATIVE
+			AggregationTemporality int  `json:"aggregationTemporality"`
+			IsMonotonic            bool `json:"isMonotonic"`
+		} `json:"sum"`
+	}
+)
+
+func newOTLPAttribute(key, value string) otlpAttribute {
+	attribute := otlpAttribute{Key: key}
+	attribute.Value.StringValue = value
+	return attribute
+}
+
+// exportMetrics pushes all registered counters to the OTLP endpoint
+func exportMetrics(ctx context.Context) error {
+	start := strconv.FormatInt(processStart.UnixNano(), 10)
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	metrics := make([]otlpMetric, 0, len(counters))
+	for _, c := range counters {
+		metric := otlpMetric{Name: c.name, Description: c.help}
+		metric.Sum.AggregationTemporality = 2
+		metric.Sum.IsMonotonic = true
+
+		for label, value := range c.snapshot() {
+			metric.Sum.DataPoints = append(metric.Sum.DataPoints, otlpDataPoint{
+				Attributes:        []otlpAttribute{newOTLPAttribute(c.label, label)},
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				AsInt:             strconv.FormatUint(value, 10),
+			})
+		}
+
+		if len(metric.Sum.DataPoints) > 0 {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	request := map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttribute{newOTLPAttribute("service.name", serviceName())},
+			},
+			"scopeMetrics": []any{map[string]any{
+				"scope":   map[string]string{"name": "slack-proxy"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, otlpMetricsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range otlpHeaders {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := otlpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import "encoding/json"
+
+// slackPayload holds the fields of a Slack payload the proxy looks at.
+// The payload itself is always forwarded unmodified.
+type slackPayload struct {
+	Type      string          `json:"type"`
+	Token     string          `json:"token"`
+	TeamID    string          `json:"team_id"`
+	EventID   string          `json:"event_id"`
+	EventTime int64           `json:"event_time"`
+	RawEvent  json.RawMessage `json:"event"`
+
+	// url_verification payloads
+	Challenge string `json:"challenge"`
+
+	// Slash commands and interactivity payloads
+	ResponseURL string          `json:"response_url"`
+	TriggerID   string          `json:"trigger_id"`
+	UserID      string          `json:"user_id"`
+	User        json.RawMessage `json:"user"`
+	ChannelID   string          `json:"channel_id"`
+	Channel     json.RawMessage `json:"channel"`
+
+	// Slash commands
+	Command     string `json:"command"`
+	ChannelName string `json:"channel_name"`
+
+	// Interactivity payloads
+	Team struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	BlockID    string `json:"block_id"`
+	ActionID   string `json:"action_id"`
+	Value      string `json:"value"`
+	CallbackID string `json:"callback_id"`
+	View       struct {
+		CallbackID string `json:"callback_id"`
+	} `json:"view"`
+
+	// app_rate_limited payloads
+	MinuteRateLimited int64 `json:"minute_rate_limited"`
+
+	// Parsed from RawEvent
+	Event slackEvent `json:"-"`
+}
+
+// slackEvent is the inner event of an Events API payload
+type slackEvent struct {
+	Type    string          `json:"type"`
+	User    json.RawMessage `json:"user"`
+	Channel json.RawMessage `json:"channel"`
+	Tab     string          `json:"tab"`
+}
+
+// parsePayload parses the fields of interest out of a payload.
+// Payloads that fail to parse are treated as empty, as they are still forwarded.
+func parsePayload(body []byte) *slackPayload {
+	var payload slackPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return &slackPayload{}
+	}
+	if len(payload.RawEvent) > 0 {
+		json.Unmarshal(payload.RawEvent, &payload.Event)
+	}
+	if payload.TeamID == "" {
+		payload.TeamID = payload.Team.ID
+	}
+	return &payload
+}
+
+// eventType returns the Events API event type, or the payload type of other payloads
+func (p *slackPayload) eventType() string {
+	if p.Event.Type != "" {
+		return p.Event.Type
+	}
+	return p.Type
+}
+
+// userID returns the id of the user behind the payload: the user of the event,
+// of the interaction, or of the slash command. Empty if none.
+func (p *slackPayload) userID() string {
+	if user := rawString(p.Event.User); user != "" {
+		return user
+	}
+	var interactionUser struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(p.User, &interactionUser) == nil && interactionUser.ID != "" {
+		return interactionUser.ID
+	}
+	return p.UserID
+}
+
+// channelID returns the id of the channel of the payload: the channel of the event,
+// of the interaction, or of the slash command. Empty if none.
+func (p *slackPayload) channelID() string {
+	if channel := rawString(p.Event.Channel); channel != "" {
+		return channel
+	}
+	var interactionChannel struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(p.Channel, &interactionChannel) == nil && interactionChannel.ID != "" {
+		return interactionChannel.ID
+	}
+	return p.ChannelID
+}
+
+// rawString returns the JSON string value, or an empty string if it isn't one.
+// Some events carry full objects rather than IDs in these fields.
+func rawString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Pipeline stages, in the order they run
+const (
+	// StageVerify validates the request and reads its payload
+	StageVerify = "verify"
+
+	// StageFilter drops filtered, sampled out, duplicate and over-quota events
+	StageFilter = "filter"
+
+	// StageRoute enriches the message and resolves its destination
+	StageRoute = "route"
+
+	// StageTransform runs the transform steps over the payload
+	StageTransform = "transform"
+
+	// StagePublish forwards the message
+	StagePublish = "publish"
+)
+
+// Stage is a step of the pipeline handling a Slack request.
+// Returns true to continue to the next stage, or responds to the request and returns false to stop.
+// Requests making it through all stages are acknowledged with a 200.
+type Stage func(w http.ResponseWriter, e *Event) bool
+
+// Middleware wraps a stage, e.g. to run custom logic before or after it
+type Middleware func(next Stage) Stage
+
+// Event is a Slack request flowing through the pipeline
+type Event struct {
+	// Request is the incoming request. Its context carries the tenant and trace of the request.
+	Request *http.Request
+
+	// Body is the verified body of the request. Set by the verify stage.
+	Body []byte
+
+	// Message is the message to forward. Set by the verify stage,
+	// and can be modified by later stages until published.
+	Message *pubsub.Message
+
+	// Topic is the ID of the destination topic. Set by the route stage when publishing to Pub/Sub,
+	// and can be changed until published.
+	Topic string
+
+	payload         *slackPayload
+	outgoingWebhook bool
+	claimedKey      string
+	appHomeKey      string
+	rule            *routingRule
+	topic           pubsubTopic
+	live            *liveConfig
+
+	// Whether the message was published, or forwarded to the unfurl service
+	forwarded bool
+
+	// Whether Slack was answered before publishing the message
+	acked bool
+}
+
+// newEvent starts the event of a request, with the live settings current at that point.
+// Live configuration changes apply to the next requests.
+func newEvent(r *http.Request) *Event {
+	live := currentLiveConfig.Load()
+	return &Event{Request: r.WithContext(withLiveConfig(r.Context(), live)), live: live}
+}
+
+// TeamID returns the team id of the payload, once verified
+func (e *Event) TeamID() string {
+	if e.payload == nil {
+		return ""
+	}
+	return e.payload.TeamID
+}
+
+// EventType returns the Events API event type, or the payload type of other payloads, once verified
+func (e *Event) EventType() string {
+	if e.payload == nil {
+		return ""
+	}
+	return e.payload.eventType()
+}
+
+// release releases the dedup claim of an event that wasn't forwarded, so Slack's retry goes through.
+// The claim is released even once the request ran past its deadline.
+func (e *Event) release() {
+	ctx := detachedContext{e.Request.Context()}
+	if e.claimedKey != "" {
+		releaseEvent(ctx, e.claimedKey)
+	}
+	if e.appHomeKey != "" {
+		releaseAppHome(ctx, e.appHomeKey)
+	}
+}
+
+var (
+	stageOrder = []string{StageVerify, StageFilter, StageRoute, StageTransform, StagePublish}
+
+	stages = map[string]Stage{
+		StageVerify:    verify,
+		StageFilter:    filter,
+		StageRoute:     route,
+		StageTransform: transform,
+		StagePublish:   publish,
+	}
+)
+
+// Use wraps a stage of the pipeline with the middleware.
+// The middleware added last runs first. Must be called before serving requests.
+func Use(stage string, middleware Middleware) {
+	next, ok := stages[stage]
+	if !ok {
+		log.Panicf("Unknown pipeline stage: %s.", stage)
+	}
+	stages[stage] = middleware(next)
+}
+
+// Before returns a middleware running the hook before the stage.
+// The stage doesn't run if the hook returns false.
+func Before(hook Stage) Middleware {
+	return func(next Stage) Stage {
+		return func(w http.ResponseWriter, e *Event) bool {
+			return hook(w, e) && next(w, e)
+		}
+	}
+}
+
+// After returns a middleware running the hook after the stage, if it continued
+func After(hook Stage) Middleware {
+	return func(next Stage) Stage {
+		return func(w http.ResponseWriter, e *Event) bool {
+			return next(w, e) && hook(w, e)
+		}
+	}
+}
+
+type destinationContextKey struct{}
+
+// withDestination attaches the resolved destination topic to the context
+func withDestination(ctx context.Context, t pubsubTopic) context.Context {
+	return context.WithValue(ctx, destinationContextKey{}, t)
+}
+
+// Verify is a middleware verifying Slack requests before passing them to the next handler,
+// for services handling Slack requests themselves. Runs the verify stage, including its hooks.
+// The next handler can read the verified body as usual.
+func Verify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Setup()
+
+		// Only panics of the stage are recovered, the next handler has its own
+		var verified *Event
+		withRecovery("verify", func(w http.ResponseWriter, r *http.Request) {
+			if e := newEvent(r); stages[StageVerify](w, e) {
+				verified = e
+			}
+		})(w, withTrace(withBudget(r, withBudgetTotal(publishBudget))))
+		if verified == nil {
+			return
+		}
+
+		r = verified.Request
+		r.Body = io.NopCloser(bytes.NewReader(verified.Body))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"strings"
+)
+
+// Message attribute holding the priority of the event
+const attrPriority = "priority"
+
+// setupPriorities configures the event priorities from the environment.
+// EVENT_PRIORITIES is a comma separated list of event_type=priority, e.g. app_mention=high,message=normal
+func setupPriorities(live *liveConfig) {
+	rules := getenv("EVENT_PRIORITIES")
+	if rules == "" {
+		return
+	}
+
+	live.eventPriorities = map[string]string{}
+	for _, rule := range strings.Split(rules, ",") {
+		eventType, priority, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		if !ok || eventType == "" || priority == "" {
+			configErrorf("Invalid EVENT_PRIORITIES rule: %s.", rule)
+			continue
+		}
+		live.eventPriorities[eventType] = priority
+	}
+
+	live.defaultPriority = getenv("EVENT_PRIORITY_DEFAULT")
+}
+
+// attachPriority sets the priority attribute of the event, by its type
+func (live *liveConfig) attachPriority(payload *slackPayload, attributes map[string]string) {
+	priority, ok := live.eventPriorities[payload.eventType()]
+	if !ok {
+		priority = live.defaultPriority
+	}
+	if priority != "" {
+		attributes[attrPriority] = priority
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"os"
+)
+
+// Environments selected by ENVIRONMENT
+const (
+	environmentDev     = "dev"
+	environmentStaging = "staging"
+	environmentProd    = "prod"
+)
+
+// Defaults of each environment, overridden by any source
+var profileDefaults = map[string]configValues{
+	// Conveniences for running against the Pub/Sub emulator and replaying captured requests
+	environmentDev: {
+		"PUBSUB_EMULATOR_HOST":      "localhost:8085",
+		"PUBSUB_TOPIC_AUTO_CREATE":  "true",
+		"SLACK_TIMESTAMP_TOLERANCE": "0",
+		"LOG_FORMAT":                "text",
+		"LOG_PAYLOADS":              "true",
+	},
+	environmentStaging: {
+		"SLACK_TIMESTAMP_TOLERANCE": "5m",
+		"LOG_PAYLOADS":              "false",
+	},
+	// Strict timestamps, and private messages never logged
+	environmentProd: {
+		"SLACK_TIMESTAMP_TOLERANCE": "5m",
+		"LOG_PAYLOADS":              "false",
+	},
+}
+
+// loadProfile sets the defaults of the ENVIRONMENT profile
+func loadProfile() {
+	var defaults configValues
+	if environment := getenv("ENVIRONMENT"); environment != "" {
+		var ok bool
+		if defaults, ok = profileDefaults[environment]; !ok {
+			configErrorf("Unknown ENVIRONMENT: %s.", environment)
+		}
+	}
+
+	configMu.Lock()
+	configProfile = defaults
+	configMu.Unlock()
+
+	// The Pub/Sub client only reads the emulator host from the process environment
+	if host := getenv("PUBSUB_EMULATOR_HOST"); host != "" && os.Getenv("PUBSUB_EMULATOR_HOST") == "" {
+		os.Setenv("PUBSUB_EMULATOR_HOST", host)
+	}
+}
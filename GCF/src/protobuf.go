@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Encodings of the published payloads
+const (
+	encodingJSON     = "json"
+	encodingProtobuf = "protobuf"
+)
+
+// Attribute set on messages of payloads not encoded as JSON
+const attrPayloadEncoding = "payload_encoding"
+
+// Field numbers of the SlackEvent message of proto/slack_event.proto
+const (
+	fieldType      protowire.Number = 1
+	fieldTeamID    protowire.Number = 2
+	fieldEventID   protowire.Number = 3
+	fieldEventTime protowire.Number = 4
+	fieldEventType protowire.Number = 5
+	fieldUserID    protowire.Number = 6
+	fieldChannelID protowire.Number = 7
+	fieldRawJSON   protowire.Number = 15
+)
+
+// Encoding of the published payloads
+var payloadEncoding = encodingJSON
+
+// setupPayloadEncoding configures the wire format of the messages from the environment
+func setupPayloadEncoding() {
+	switch payloadEncoding = getenv("PAYLOAD_ENCODING"); payloadEncoding {
+	case "":
+		payloadEncoding = encodingJSON
+	case encodingJSON, encodingProtobuf:
+	default:
+		configErrorf("Unknown PAYLOAD_ENCODING: %s.", payloadEncoding)
+	}
+
+	// Both expect JSON messages
+	if payloadEncoding == encodingProtobuf {
+		if getenv("PUBSUB_SCHEMA") != "" {
+			configErrorf("PUBSUB_SCHEMA validates JSON messages, and can't be used with PAYLOAD_ENCODING=protobuf.")
+		}
+		if getenv("WEBHOOK_TEMPLATE") != "" {
+			configErrorf("WEBHOOK_TEMPLATE renders JSON payloads, and can't be used with PAYLOAD_ENCODING=protobuf.")
+		}
+	}
+}
+
+// encodeProtobuf converts a JSON payload to a SlackEvent message.
+// The payload is kept whole in raw_json, so nothing is lost to the conversion.
+func encodeProtobuf(data []byte) []byte {
+	payload := parsePayload(data)
+
+	var b []byte
+	b = appendStringField(b, fieldType, payload.Type)
+	b = appendStringField(b, fieldTeamID, payload.TeamID)
+	b = appendStringField(b, fieldEventID, payload.EventID)
+	if payload.EventTime != 0 {
+		b = protowire.AppendTag(b, fieldEventTime, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(payload.EventTime))
+	}
+	b = appendStringField(b, fieldEventType, payload.Event.Type)
+	b = appendStringField(b, fieldUserID, rawString(payload.Event.User))
+	b = appendStringField(b, fieldChannelID, rawString(payload.Event.Channel))
+	b = protowire.AppendTag(b, fieldRawJSON, protowire.BytesType)
+	return protowire.AppendBytes(b, data)
+}
+
+// appendStringField appends a string field, omitted if empty as proto3 does
+func appendStringField(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
@@ -6,11 +6,12 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
+	"sync"
+	"time"
 	"unsafe"
 
 	"cloud.google.com/go/pubsub"
@@ -19,50 +20,243 @@ import (
 
 var (
 	slackSigningSecret []byte
-	pubsubClient       *pubsub.Client
-	topic              *pubsub.Topic
+	topic              pubsubTopic
 )
 
 const maxBodySize = 1024 * 1024 * 10 // 10MB
 
+var setupOnce sync.Once
+
 func init() {
+	// Register the functions
+	functions.HTTP("Proxy", Proxy)
+	functions.HTTP("OAuthCallback", OAuthCallback)
+	functions.HTTP("AuditLogPoller", AuditLogPoller)
+	functions.HTTP("Reconciler", Reconciler)
+
+	// Set up eagerly on GCP, so the first request doesn't pay for it
+	if getenv("K_SERVICE") != "" || getenv("FUNCTION_TARGET") != "" {
+		Setup()
+	}
+}
+
+// Setup configures the proxy from the environment, panicking on invalid configuration.
+// Runs at startup on GCP, and on first use elsewhere, letting programs embedding the proxy
+// configure it beforehand. Only the first call has any effect.
+func Setup() {
+	setupOnce.Do(setup)
+}
+
+func setup() {
+	// Settings that can change at runtime are in their own struct
+	live := &liveConfig{}
+	currentLiveConfig.Store(live)
+
+	// Read the configuration sources, and report all configuration errors at once
+	loadConfigSources()
+	defer func() {
+		// Later steps may fail on the values of invalid ones
+		if err := recover(); err != nil {
+			if len(configErrors) == 0 {
+				panic(err)
+			}
+			configErrorf("%v", err)
+		}
+		checkConfig()
+	}()
+
 	// Get the Slack signing secret from the environment
-	slackSigningSecret = []byte(os.Getenv("SLACK_SIGNING_SECRET"))
-	if len(slackSigningSecret) == 0 {
-		log.Panicln("SLACK_SIGNING_SECRET env var must be set.")
+	// (optional when the tenant registry holds the secrets, or when only using Socket Mode)
+	slackSigningSecret = []byte(getenv("SLACK_SIGNING_SECRET"))
+	if len(slackSigningSecret) == 0 && getenv("TENANT_REGISTRY") == "" && getenv("SLACK_APP_TOKEN") == "" {
+		configErrorf("SLACK_SIGNING_SECRET env var must be set.")
 	}
 
 	// Get the GCP project from the environment
-	project := os.Getenv("GCP_PROJECT")
+	project := getenv("GCP_PROJECT")
 	if project == "" {
-		log.Panicln("GCP_PROJECT env var must be set.")
+		configErrorf("GCP_PROJECT env var must be set.")
 	}
 
-	// Get the Pub/Sub topic ID from the environment
-	topicName := os.Getenv("PUBSUB_TOPIC")
-	if topicName == "" {
-		log.Panicln("PUBSUB_TOPIC env var must be set.")
+	// Set up the limits of the outstanding publishes, before opening the topics
+	setupBackpressure()
+
+	// Set up the backend
+	setupBackend()
+
+	// Create a Pub/Sub client, unless a custom publisher replaces it
+	if backend != backendCustom {
+		if project != "" {
+			setupPubSub(project)
+		}
+
+		// Get the Pub/Sub topic ID from the environment
+		// (optional when forwarding to a webhook)
+		if topicName := getenv("PUBSUB_TOPIC"); topicName != "" {
+			// Get the topic
+			topic = openExistingTopic(topicName)
+
+			// Create its default subscription
+			if subscription := getenv("CREATE_SUBSCRIPTION"); subscription != "" && topic != nil {
+				if err := createSubscription(context.Background(), topic, subscription); err != nil {
+					configErrorf("Failed creating subscription %s: %s.", subscription,
+						describePubSubError(err, "pubsub.subscriptions.create"))
+				}
+			}
+		} else if backend == backendPubSub {
+			configErrorf("PUBSUB_TOPIC env var must be set.")
+		}
 	}
 
-	var err error
+	// Set up the secondary topics
+	setupReplication()
 
-	// Create a Pub/Sub client
-	pubsubClient, err = pubsub.NewClient(context.Background(), project)
-	if err != nil {
-		log.Panicf("Failed creating a Pub/Sub client: %s.", err.Error())
-	}
+	// Set up the logging
+	setupLogging()
+	setupBuildInfo()
 
-	// Get the topic
-	topic = pubsubClient.Topic(topicName)
+	// Set up the feature flags
+	setupFlags()
 
-	if exists, err := topic.Exists(context.Background()); err != nil || !exists {
-		log.Panicf("Topic %s doesn't exist.\n", topicName)
-	}
+	// Set up the timestamp validation
+	setupClock()
 
-	topic.PublishSettings.CountThreshold = 1
+	// Set up error reporting
+	setupErrorReporting()
 
-	// Register the function
-	functions.HTTP("Proxy", Proxy)
+	// Set up the access log
+	setupAccessLog()
+
+	// Set up the payload logging
+	setupPayloadLogging()
+
+	// Set up the metrics endpoint and export
+	setupMetrics()
+	setupOTLP()
+
+	// Set up the audit log of rejected requests
+	setupAudit()
+	// Set up the time-boxed capture of full requests
+	setupCapture()
+
+	// Set up the topic of the app_rate_limited payloads
+	setupOpsTopic()
+
+	// Set up the link_shared fast path
+	setupUnfurls()
+	// Set up the failure alerts
+	setupAlerts()
+
+	// Set up the warm-up requests and connection keepalive
+	setupWarmup()
+	setupKeepalive()
+
+	// Set up the publish retries
+	setupRetry()
+
+	// Set up the overall deadline of the requests
+	setupRequestTimeout()
+
+	// Set up the early acknowledgement of the events
+	setupAckFirst()
+
+	// Set up the fault injection
+	setupChaos()
+
+	// Set up the message expiry
+	setupExpiry()
+	// Set up the stage timings attribute
+	setupStageTimings()
+
+	// Set up the request path and query attributes, and the attributes extracted from the payloads
+	setupRequestAttributes()
+	setupPathAttributes(live)
+
+	// Set up the trusted proxies
+	setupClientIP()
+
+	// Set up the attribute allowlist
+	setupAttributeAllowlist()
+
+	// Set up the Slack audit logs poller
+	setupAuditLogs()
+
+	// Set up the Socket Mode bridge
+	setupSocketMode()
+
+	// Set up the wire format of the messages
+	setupPayloadEncoding()
+
+	// Set up the payload encryption
+	setupPayloadEncryption()
+
+	// Set up the form-encoded payloads, synchronous options loading and modal submissions
+	setupFormPayloads()
+	setupOptionsLoad()
+	setupViewSubmissions()
+
+	// Set up the integrity chain of forwarded messages
+	setupIntegrityChain()
+
+	// Set up the tenant registry
+	setupTenantRegistry(live)
+
+	// Set up the OAuth install flow
+	setupOAuth()
+
+	// Set up the payload enrichment
+	setupEnrichment()
+
+	// Set up the event filters
+	setupFilters(live)
+
+	// Set up the stale events policy
+	setupEventAge()
+
+	// Set up the sampling rules
+	setupSampling(live)
+
+	// Set up the event priorities
+	setupPriorities(live)
+
+	// Set up the dedup window
+	setupDedup()
+	// Set up the counts of the publish reconciliation
+	setupReconciliation()
+
+	// Set up the app_home_opened preset
+	setupAppHome()
+
+	// Set up the canary topic
+	setupCanary()
+
+	// Set up the usage metering and quotas
+	setupMetering()
+
+	// Set up the export of the usage to BigQuery
+	setupUsageExport()
+
+	// Set up the inbound rate limit
+	setupRateLimit()
+
+	// Set up the templated destination topic
+	setupTopicTemplate(live)
+
+	// Set up the routes by channel, and the routing rules
+	setupChannelRoutes(live)
+	setupRoutingRules(live)
+
+	// Set up the payload transform steps
+	setupTransforms()
+
+	// Set up the schema of the published messages
+	setupSchema()
+
+	// Set up the legacy Slack compatibility
+	setupLegacy()
+
+	// Set up the response headers
+	setupResponseHeaders()
 }
 
 // stringToByteSlice converts a string to a byte slice without copying the underlying data.
@@ -112,69 +306,551 @@ func isValidSlackSignature(secret []byte, r *http.Request) bool {
 	return true
 }
 
+// Rejection reasons, used for logging and metrics
+const (
+	reasonBadMethod      = "bad_method"
+	reasonBadContentType = "bad_content_type"
+	reasonOversized      = "oversized"
+	reasonEmptyBody      = "empty_body"
+	reasonBadSignature   = "bad_signature"
+	reasonUnknownTenant  = "unknown_tenant"
+)
+
+// Error codes of failures past validation
+const (
+	errorTenantLookup = "tenant_lookup_failed"
+	errorReadBody     = "read_failed"
+	errorOverQuota    = "over_quota"
+	errorRateLimited  = "rate_limited"
+	errorForward      = "forward_failed"
+)
+
 // Validate a request
-// Returns 0 if valid, HTTP status code otherwise
-func validateRequest(r *http.Request) int {
+// Returns 0 if valid, HTTP status code and rejection reason otherwise
+func validateRequest(r *http.Request, secret []byte) (int, string) {
 	if r.Method != http.MethodPost {
-		return http.StatusMethodNotAllowed
+		return http.StatusMethodNotAllowed, reasonBadMethod
 	}
 
-	if r.Header.Get("Content-Type") != "application/json" {
-		return http.StatusUnsupportedMediaType
+	if r.Header.Get("Content-Type") != "application/json" && !(formPayloads && isFormRequest(r)) {
+		return http.StatusUnsupportedMediaType, reasonBadContentType
 	}
 
 	if r.ContentLength > maxBodySize {
-		return http.StatusRequestEntityTooLarge
+		return http.StatusRequestEntityTooLarge, reasonOversized
 	}
 
 	if r.ContentLength <= 0 {
-		return http.StatusBadRequest
+		return http.StatusBadRequest, reasonEmptyBody
 	}
 
 	if r.Body == nil {
-		return http.StatusBadRequest
+		return http.StatusBadRequest, reasonEmptyBody
 	}
 
-	if !isValidSlackSignature(slackSigningSecret, r) {
-		return http.StatusUnauthorized
+	if len(secret) == 0 {
+		return http.StatusUnauthorized, reasonUnknownTenant
 	}
 
-	return 0
+	if !isValidSlackSignature(secret, r) {
+		return http.StatusUnauthorized, reasonBadSignature
+	}
+
+	if !isFreshTimestamp(r) {
+		return http.StatusUnauthorized, reasonStaleTimestamp
+	}
+
+	return 0, ""
+}
+
+// writeError responds with the status code and a JSON body holding the machine-readable error code,
+// e.g. {"error":"bad_signature"}
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":%q}`, code)
+}
+
+// rejectRequest responds to an invalid request, recording the rejection.
+// The body is the one already read, nil if not read yet.
+func rejectRequest(w http.ResponseWriter, r *http.Request, body []byte, status int, reason string) {
+	auditRejection(r, body, status, reason)
+	writeError(w, status, reason)
+	rejectedRequests.Inc(reason)
+	logWarning(r.Context(), "Invalid request (%s) from %s. Returned status: %d", reason, clientIP(r), status)
+	if reason == reasonBadSignature {
+		recordFailure(r.Context(), alertSignatureFailure)
+	}
 }
 
 // Proxy a slack request to Pub/Sub
 // Makes sure the request is a valid slack request before proxying it
 func Proxy(w http.ResponseWriter, r *http.Request) {
+	Setup()
 
-	// Validate the request
-	if status := validateRequest(r); status != 0 {
-		w.WriteHeader(status)
-		log.Printf("Invalid request. Returned status: %d", status)
+	withMetricsExport(withAccessLog(withCapture(withRecovery("proxy", withRequestTimeout("proxy", proxy)))))(w, withTrace(withBudget(r, withBudgetTotal(publishBudget))))
+}
+
+// proxy handles a single request
+func proxy(w http.ResponseWriter, r *http.Request) {
+	// Serve the metrics if requested
+	if isMetricsRequest(r) {
+		serveMetrics(w, r)
+		return
+	}
+
+	// Keep the instance warm
+	if isWarmupRequest(r) {
+		serveWarmup(w, r)
+		return
+	}
+
+	// Serve the build info if requested
+	if isVersionRequest(r) {
+		serveVersion(w)
+		return
+	}
+
+	// Set the headers of the route
+	if responseHeaders != nil {
+		setResponseHeaders(w, r)
+	}
+
+	// Acknowledge Slack's certificate probes
+	if isSSLCheck(r) {
+		serveSSLCheck(w)
 		return
 	}
 
+	runPipeline(w, r)
+}
+
+// runPipeline runs the stages over the request, acknowledging it if they ran to completion
+func runPipeline(w http.ResponseWriter, r *http.Request) {
+	budget := budgetFrom(r.Context())
+	defer budget.logIfOverrun(r.Context())
+
+	// Report the request's errors once Slack is answered
+	r = deferReports(r)
+	defer func() {
+		// A panic is answered by the recovery, so the response isn't flushed before it
+		if p := recover(); p != nil {
+			sendDeferredReports(nil, r)
+			panic(p)
+		}
+		sendDeferredReports(w, r)
+	}()
+
+	e := newEvent(r)
+	defer releaseOnPanic(e)
+
+	// Count the deliveries of Slack's events, for the reconciliation job
+	if reconcileWindow != 0 {
+		rec := &responseRecorder{ResponseWriter: w}
+		w = rec
+		defer func() {
+			if e.payload != nil && e.payload.EventID != "" {
+				// Answer Slack first, counting while the request still holds the instance
+				rec.Flush()
+				recordDelivery(r.Context(), r, e.payload, deliveryOutcome(e.forwarded, rec.status))
+			}
+		}()
+	}
+
+	for _, name := range stageOrder {
+		// Stop once the request ran past its deadline, rather than answering Slack too late
+		if requestTimedOut(r.Context()) {
+			e.release()
+			writeRequestTimeout(w)
+			return
+		}
+
+		stageStart := time.Now()
+		ok := stages[name](w, e)
+		budget.record(name, time.Since(stageStart))
+		if !ok {
+			return
+		}
+	}
+
+	// Acknowledged before publishing
+	if !e.acked {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// verify validates the request and reads its payload
+func verify(w http.ResponseWriter, e *Event) bool {
+	r := e.Request
+
+	// Look up the tenant of the request
+	if e.live.tenants != nil {
+		t, err := e.live.resolveTenant(r)
+		if errors.Is(err, errBadTeamID) {
+			rejectRequest(w, r, nil, http.StatusBadRequest, reasonBadTeamID)
+			return false
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errorTenantLookup)
+			logError(r.Context(), "Failed looking up tenant: %s", err.Error())
+			reportError(fmt.Errorf("failed looking up tenant: %w", err), r)
+			return false
+		}
+		r = r.WithContext(withTenant(r.Context(), t))
+		e.Request = r
+	}
+
+	// Validate the request
+	// (legacy outgoing webhooks are authenticated by their token)
+	e.outgoingWebhook = isOutgoingWebhook(r)
+	var status int
+	var reason string
+	if e.outgoingWebhook {
+		status, reason = validateOutgoingWebhook(r)
+	} else if isSocketModeRequest(r) {
+		// Authenticated by the Socket Mode connection
+	} else {
+		status, reason = validateRequest(r, signingSecretFor(tenantFromContext(r.Context())))
+	}
+	if status != 0 {
+		rejectRequest(w, r, nil, status, reason)
+		return false
+	}
+
 	// Read the body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		// Technically this should never happen
 		// (already read the body on validateRequest)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		writeError(w, http.StatusInternalServerError, errorReadBody)
+		return false
 	}
 
-	msg := pubsub.Message{
-		Data: body,
+	// Form-encoded payloads are forwarded as JSON
+	data, format := body, ""
+	if formPayloads && isFormRequest(r) && !e.outgoingWebhook {
+		if data, format, err = convertForm(body); err != nil {
+			rejectRequest(w, r, body, http.StatusBadRequest, reasonMalformedForm)
+			return false
+		}
+	} else if socketFormat, ok := socketModePayloadFormat(r); ok {
+		format = socketFormat
 	}
 
-	// Publish the message
-	publishResult := topic.Publish(r.Context(), &msg)
+	e.Body = body
+	e.payload = parsePayload(data)
+	annotateAccessLog(r.Context(), e.payload)
 
-	// Ensure the message was published
-	if _, err := publishResult.Get(r.Context()); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("Failed publishing message: ", err.Error())
-		return
+	// Old-style integrations also carry the legacy verification token
+	if slackVerificationToken != "" && !isValidVerificationToken(e.payload.Token) {
+		rejectRequest(w, r, body, http.StatusUnauthorized, reasonBadToken)
+		return false
+	}
+
+	// The URL handshake is answered, not published
+	if isURLVerification(e.payload) {
+		serveChallenge(w, e.payload)
+		return false
+	}
+
+	logPayload(r.Context(), data)
+
+	// Options of external select menus are answered synchronously
+	if isOptionsRequest(e.payload) {
+		serveOptions(w, r, e.payload, data)
+		return false
+	}
+
+	// Modal submissions answered by the backend aren't published
+	if isSyncViewSubmission(e.payload) && serveViewSubmission(w, r, e.payload, data) {
+		return false
+	}
+
+	e.Message = &pubsub.Message{
+		Data: data,
+		Attributes: map[string]string{
+			attrProxyVersion:   versionString(),
+			attrIdempotencyKey: idempotencyKey(r, e.payload, body),
+			attrBodySHA256:     bodyHash(body),
+		},
+	}
+
+	stampExpiry(r.Context(), e.Message.Attributes)
+	attachRequestAttributes(r, e.Message.Attributes)
+	if e.live.pathAttributes != nil {
+		e.live.attachPathAttributes(data, e.Message.Attributes)
+	}
+	e.Message.Attributes[attrClientIP] = clientIP(r)
+	if originRegion != "" {
+		e.Message.Attributes[attrOriginRegion] = originRegion
+	}
+
+	if e.outgoingWebhook {
+		e.Message.Attributes[attrPayloadFormat] = formatOutgoingWebhook
+	} else if format != "" {
+		e.Message.Attributes[attrPayloadFormat] = format
+		attachResponseAttributes(e.payload, e.Message.Attributes)
+	}
+
+	return true
+}
+
+// filter decides whether to forward the event.
+// Events that aren't forwarded are still acknowledged, unless over a rejecting quota.
+func filter(w http.ResponseWriter, e *Event) bool {
+	ctx := e.Request.Context()
+
+	// Acknowledge filtered events without publishing them
+	if e.live.isFiltered(e.payload) {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+
+	// Acknowledge stale events without publishing them
+	if maxEventAge > 0 && isStale(e.payload) {
+		dropStaleEvent(ctx, e)
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+
+	// Acknowledge sampled out events without publishing them
+	if !e.live.sample(e.payload, e.Message.Attributes) {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+
+	// Acknowledge duplicates of published events without republishing them,
+	// and have Slack retry those of events still being published
+	if dedupWindow != 0 {
+		var state string
+		switch e.claimedKey, state = claimEvent(ctx, e.payload, e.Body); state {
+		case dedupPublished:
+			w.WriteHeader(http.StatusOK)
+			return false
+		case dedupClaimed:
+			writeInFlight(w)
+			return false
+		}
+	}
+
+	// Throttle the team's requests
+	if rateLimit != 0 {
+		if retryAfter := rateLimited(ctx, e.payload); retryAfter != 0 {
+			writeRateLimited(w, retryAfter)
+			e.release()
+			return false
+		}
+	}
+
+	// Acknowledge repeated App Home openings without publishing them
+	if appHomeWindow != 0 && e.payload.Event.Type == eventAppHomeOpened {
+		var ok bool
+		if e.appHomeKey, ok = claimAppHome(ctx, e.payload); !ok {
+			w.WriteHeader(http.StatusOK)
+			return false
+		}
+	}
+
+	// Enforce the team's quota
+	if meteringEnabled {
+		if status := checkQuota(ctx, e.payload); status != 0 {
+			if status == http.StatusOK {
+				w.WriteHeader(status)
+			} else {
+				writeError(w, status, errorOverQuota)
+			}
+			e.release()
+			return false
+		}
+	}
+
+	return true
+}
+
+// route attaches the enrichment attributes and resolves the destination topic
+func route(w http.ResponseWriter, e *Event) bool {
+	ctx := e.Request.Context()
+
+	// Resolve user and channel IDs
+	if enrichEnabled && flagEnabled(flagEnrichment) {
+		enrich(ctx, e.payload, e.Message.Attributes)
+	}
+
+	// Let consumers process urgent events first
+	if e.live.eventPriorities != nil {
+		e.live.attachPriority(e.payload, e.Message.Attributes)
+	}
+
+	// Apply the first matching routing rule, which may answer the payload right away
+	if e.live.routingRules != nil || e.live.proposedRoutingRules != nil {
+		if e.rule = evaluateRules(ctx, e.payload, e.Message.Attributes); e.rule != nil && e.rule.Respond != nil {
+			e.rule.Respond.write(w)
+			return false
+		}
+	}
+
+	// Slack is throttling the app
+	if e.payload.Type == payloadAppRateLimited {
+		recordAppRateLimited(ctx, e.payload)
+	}
+
+	// Unfurls are latency-sensitive, and may skip Pub/Sub
+	if unfurlURL != "" && e.payload.Event.Type == eventLinkShared && forwardUnfurl(w, e) {
+		return false
+	}
+
+	if backend == backendPubSub {
+		e.topic = destinationTopic(ctx, e.Message)
+		if opsTopic != nil && e.payload.Type == payloadAppRateLimited {
+			e.topic = opsTopic
+		}
+		if linkSharedTopic != nil && e.payload.Event.Type == eventLinkShared {
+			e.topic = linkSharedTopic
+		}
+		if appHomeTopic != nil && e.payload.Event.Type == eventAppHomeOpened {
+			e.topic = appHomeTopic
+		}
+		if e.live.routingRules != nil && !routeByRule(ctx, w, e) {
+			e.release()
+			return false
+		}
+
+		// Send a share of the primary topic's events to the canary topic
+		if canaryTopic != nil && e.topic == topic && flagEnabled(flagCanary) && isCanary(e.Message) {
+			e.topic = canaryTopic
+			e.Message.Attributes[attrCanary] = "true"
+		}
+		e.Topic = e.topic.ID()
+	}
+
+	return true
+}
+
+// publish forwards the message, and ensures it was accepted
+func publish(w http.ResponseWriter, e *Event) bool {
+	r := e.Request
+	ctx := r.Context()
+
+	// Hooks may have changed the destination
+	if e.topic != nil && e.Topic != e.topic.ID() {
+		t, err := namedTopic(ctx, e.Topic)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errorForward)
+			logError(ctx, "Failed resolving topic %s: %s", e.Topic, err.Error())
+			e.release()
+			return false
+		}
+		e.topic = t
+	}
+	if e.topic != nil {
+		ctx = withDestination(ctx, e.topic)
+	}
+
+	// Encode the payload in the configured wire format
+	if payloadEncoding == encodingProtobuf {
+		e.Message.Data = encodeProtobuf(e.Message.Data)
+		e.Message.Attributes[attrPayloadEncoding] = encodingProtobuf
 	}
 
-	w.WriteHeader(http.StatusOK)
+	// Encrypt the payload for the consumers holding the key
+	if payloadAEAD != nil && flagEnabled(flagEncryption) {
+		data, err := encryptPayload(e.Message.Data)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errorForward)
+			logError(ctx, "Failed encrypting payload: %s", err.Error())
+			e.release()
+			return false
+		}
+		e.Message.Data = data
+		e.Message.Attributes[attrPayloadEncryption] = encryptionAES256GCM
+		if payloadKeyID != "" {
+			e.Message.Attributes[attrEncryptionKeyID] = payloadKeyID
+		}
+	}
+
+	// Record the time spent by the stages so far, for downstream latency analysis
+	if stageTimingsEnabled {
+		attachStageTimings(ctx, e.Message.Attributes)
+	}
+
+	// Answer with a 503 rather than piling up publishes while the backend falls behind
+	if backpressureEnabled() {
+		if !admitPublish(w, e) {
+			e.release()
+			return false
+		}
+		defer finishPublish(e)
+	}
+
+	// Only attach the allowed attributes, within Pub/Sub's limits
+	guardAttributes(ctx, e.Message.Attributes)
+
+	// Link the message to the integrity chain, over its final attributes
+	var checkpoint *chainCheckpoint
+	if integrityChain != nil {
+		checkpoint = integrityChain.link(e.Message)
+	}
+
+	// Answer Slack right away, leaving the whole publish budget to the publish
+	if ackFirst && flagEnabled(flagAckFirst) {
+		var cancel context.CancelFunc
+		ctx, cancel = acknowledgeEarly(ctx, w, e)
+		defer cancel()
+	}
+
+	publishStart := time.Now()
+	err := forwardWithRetry(ctx, e.Message)
+	recordPublishLatency(ctx, time.Since(publishStart))
+	if err != nil {
+		if integrityChain != nil {
+			// The chain has a gap at this link
+			integrityChain.gap(ctx, e.Message)
+		}
+
+		// Slack won't retry an acknowledged event
+		if e.acked {
+			logError(ctx, "Failed forwarding acknowledged %s event, the event is lost: %s", e.EventType(), err.Error())
+			reportError(fmt.Errorf("failed forwarding acknowledged message: %w", err), r)
+			recordFailure(ctx, alertPublishFailure)
+			lostEvents.Inc(e.EventType())
+			return false
+		}
+
+		if isFlowControlled(err) {
+			logWarning(ctx, "Publisher falling behind, rejecting %s event: %s", e.EventType(), err.Error())
+			rejectBackpressure(w, e.Message.Attributes[attrPriority])
+			e.release()
+			return false
+		}
+		if requestTimedOut(ctx) {
+			writeRequestTimeout(w)
+		} else {
+			writeError(w, http.StatusInternalServerError, errorForward)
+		}
+		logError(ctx, "Failed forwarding message: %s", err.Error())
+		reportError(fmt.Errorf("failed forwarding message: %w", err), r)
+		recordFailure(ctx, alertPublishFailure)
+		e.release()
+		return false
+	}
+
+	e.forwarded = true
+	forwardedEvents.Inc(e.EventType())
+
+	// Count the published event towards the team's usage
+	if meteringEnabled {
+		meterEvent(ctx, e.payload)
+	}
+
+	// Let Slack's retries of the event be acknowledged right away
+	if e.claimedKey != "" {
+		markPublished(ctx, e.claimedKey)
+	}
+
+	// Record the head of the chain, now that its last link is published
+	if checkpoint != nil {
+		integrityChain.checkpoint(ctx, *checkpoint)
+	}
+
+	return true
 }
@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/pubsub"
+	pubsubapi "cloud.google.com/go/pubsub/apiv1"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpckeepalive "google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+const defaultGRPCKeepaliveTimeout = 20 * time.Second
+
+// Pub/Sub transports
+const (
+	transportGRPC = "grpc"
+	transportREST = "rest"
+)
+
+// pubsubTopic is a Pub/Sub topic, over either transport
+type pubsubTopic interface {
+	// ID returns the topic id
+	ID() string
+
+	// Exists returns true if the topic exists
+	Exists(ctx context.Context) (bool, error)
+
+	// TestPermissions returns the permissions the caller holds on the topic, out of the given ones
+	TestPermissions(ctx context.Context, permissions []string) ([]string, error)
+
+	// Publish publishes the message, returning once the server accepted it
+	Publish(ctx context.Context, msg *pubsub.Message) error
+}
+
+var (
+	gcpProject string
+
+	// Clients of the selected transport, the other one is nil
+	pubsubClient *pubsub.Client
+	pubsubREST   *pubsubapi.PublisherClient
+
+	// Options of the clients, for creating other clients of the same transport
+	pubsubOptions []option.ClientOption
+
+	// Create the topics opened at startup if they don't exist
+	createMissingTopics bool
+
+	// Check that the topics opened at startup can be published to
+	preflight bool
+)
+
+// setupPubSub creates the Pub/Sub client of the transport selected by PUBSUB_TRANSPORT.
+// Both transports honor the standard HTTPS_PROXY and NO_PROXY env vars. The REST transport
+// suits egress proxies where gRPC is blocked.
+func setupPubSub(project string) {
+	gcpProject = project
+
+	opts := pubsubClientOptions()
+
+	// Regional endpoint, e.g. us-east1-pubsub.googleapis.com:443
+	if endpoint := getenv("PUBSUB_ENDPOINT"); endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+
+	var err error
+	pubsubClient, pubsubREST, err = newPubSubClient(project, opts...)
+	if err != nil {
+		log.Panicf("Failed creating a Pub/Sub client: %s.", err.Error())
+	}
+	pubsubOptions = opts
+
+	createMissingTopics = getenv("CREATE_TOPIC_IF_MISSING") == "true"
+	preflight = getenv("PREFLIGHT") == "true"
+}
+
+// newPubSubClient creates a client of the transport selected by PUBSUB_TRANSPORT.
+// Returns the client of the selected transport, the other one is nil.
+func newPubSubClient(project string, opts ...option.ClientOption) (*pubsub.Client, *pubsubapi.PublisherClient, error) {
+	switch transport := getenv("PUBSUB_TRANSPORT"); transport {
+	case "", transportGRPC:
+		opts = append(opts, grpcClientOptions()...)
+	case transportREST:
+		client, err := pubsubapi.NewPublisherRESTClient(context.Background(), egressClientOptions(opts...)...)
+		return nil, client, err
+	default:
+		configErrorf("Unknown PUBSUB_TRANSPORT: %s.", transport)
+	}
+
+	client, err := pubsub.NewClient(context.Background(), project, opts...)
+	return client, nil, err
+}
+
+// grpcClientOptions returns the connection pool and keepalive options of the gRPC transport.
+// Larger pools spread concurrent publishes of high-throughput instances over more connections.
+func grpcClientOptions() []option.ClientOption {
+	var opts []option.ClientOption
+
+	if getenv("PUBSUB_GRPC_POOL_SIZE") != "" {
+		opts = append(opts, option.WithGRPCConnectionPool(configInt("PUBSUB_GRPC_POOL_SIZE", 1)))
+	}
+
+	// HTTP/2 pings detect dead connections, e.g. dropped by NATs after idling, before a publish hits them
+	if t := configDuration("PUBSUB_GRPC_KEEPALIVE_TIME", 0, false); t > 0 {
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithKeepaliveParams(grpckeepalive.ClientParameters{
+			Time:                t,
+			Timeout:             configDuration("PUBSUB_GRPC_KEEPALIVE_TIMEOUT", defaultGRPCKeepaliveTimeout, false),
+			PermitWithoutStream: true,
+		})))
+	}
+
+	return opts
+}
+
+// pubsubClientOptions returns the credentials options of the Pub/Sub client.
+// Defaults to the ambient application default credentials.
+func pubsubClientOptions() []option.ClientOption {
+	var opts []option.ClientOption
+
+	if file := getenv("PUBSUB_CREDENTIALS_FILE"); file != "" {
+		opts = append(opts, option.WithCredentialsFile(file))
+	}
+
+	// Impersonation uses the credentials above as the base credentials
+	if account := getenv("PUBSUB_IMPERSONATE_SERVICE_ACCOUNT"); account != "" {
+		ts, err := impersonate.CredentialsTokenSource(context.Background(), impersonate.CredentialsConfig{
+			TargetPrincipal: account,
+			Scopes:          []string{pubsub.ScopePubSub},
+		}, opts...)
+		if err != nil {
+			log.Panicf("Failed impersonating %s: %s.", account, err.Error())
+		}
+		return []option.ClientOption{option.WithTokenSource(ts)}
+	}
+
+	// Self-signed JWTs of service account keys are issued for the audience
+	if audience := getenv("PUBSUB_AUDIENCE"); audience != "" {
+		opts = append(opts, option.WithAudiences(audience))
+	}
+
+	return opts
+}
+
+// openTopic returns a handle of the topic publishing each message as soon as it is published
+func openTopic(id string) pubsubTopic {
+	return openClientTopic(pubsubClient, pubsubREST, gcpProject, id)
+}
+
+// openClientTopic opens the topic of the project using the client of either transport
+func openClientTopic(client *pubsub.Client, rest *pubsubapi.PublisherClient, project, id string) pubsubTopic {
+	if rest != nil {
+		return &restTopic{client: rest, id: id, name: fmt.Sprintf("projects/%s/topics/%s", project, id)}
+	}
+
+	t := client.Topic(id)
+	t.PublishSettings.CountThreshold = 1
+	if backpressureEnabled() {
+		t.PublishSettings.FlowControlSettings = publisherFlowControl()
+	}
+	return &grpcTopic{t}
+}
+
+// openExistingTopic opens the topic, recording a configuration error if it doesn't exist.
+// Creates the topic instead if CREATE_TOPIC_IF_MISSING is set.
+// Used at startup, returns nil without a Pub/Sub client.
+func openExistingTopic(id string) pubsubTopic {
+	if pubsubClient == nil && pubsubREST == nil {
+		configErrorf("Topic %s requires the GCP_PROJECT env var.", id)
+		return nil
+	}
+
+	ctx := context.Background()
+	t := openTopic(id)
+	exists, err := t.Exists(ctx)
+	switch {
+	case err != nil:
+		configErrorf("Failed getting topic %s: %s.", id, describePubSubError(err, "pubsub.topics.get"))
+		return t
+	case exists:
+	case createMissingTopics:
+		if _, err := createTopic(ctx, id); err != nil {
+			configErrorf("Failed creating topic %s: %s.", id, describePubSubError(err, "pubsub.topics.create"))
+			return t
+		}
+		logInfo(ctx, "Created topic %s.", id)
+	default:
+		configErrorf("Topic %s doesn't exist. Set CREATE_TOPIC_IF_MISSING=true to create it.", id)
+		return t
+	}
+
+	if preflight {
+		checkPublishPermission(ctx, t)
+	}
+	return t
+}
+
+// checkPublishPermission records a configuration error unless the service account can publish to the topic,
+// surfacing IAM misconfigurations on deploy rather than on the first event
+func checkPublishPermission(ctx context.Context, t pubsubTopic) {
+	const permission = "pubsub.topics.publish"
+
+	granted, err := t.TestPermissions(ctx, []string{permission})
+	if err != nil {
+		configErrorf("Failed checking the permissions on topic %s: %s.", t.ID(), err.Error())
+		return
+	}
+	if len(granted) == 0 {
+		configErrorf("Can't publish to topic %s: the service account lacks the %s permission on project %s.",
+			t.ID(), permission, gcpProject)
+	}
+}
+
+// createSubscription creates a pull subscription of the topic, unless it already exists
+func createSubscription(ctx context.Context, t pubsubTopic, id string) error {
+	var err error
+	if pubsubREST != nil {
+		var client *pubsubapi.SubscriberClient
+		if client, err = pubsubapi.NewSubscriberRESTClient(ctx, egressClientOptions(pubsubOptions...)...); err != nil {
+			return err
+		}
+		defer client.Close()
+
+		_, err = client.CreateSubscription(ctx, &pubsubpb.Subscription{
+			Name:  fmt.Sprintf("projects/%s/subscriptions/%s", gcpProject, id),
+			Topic: t.(*restTopic).name,
+		})
+	} else {
+		_, err = pubsubClient.CreateSubscription(ctx, id, pubsub.SubscriptionConfig{Topic: pubsubClient.Topic(t.ID())})
+	}
+
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	return err
+}
+
+// describePubSubError explains permission errors, naming the missing permission
+func describePubSubError(err error, permission string) string {
+	if status.Code(err) == codes.PermissionDenied {
+		return fmt.Sprintf("the service account lacks the %s permission on project %s", permission, gcpProject)
+	}
+	return err.Error()
+}
+
+// createTopic creates a topic, returning its handle
+func createTopic(ctx context.Context, id string) (pubsubTopic, error) {
+	if pubsubREST != nil {
+		t := openTopic(id).(*restTopic)
+		if _, err := pubsubREST.CreateTopic(ctx, &pubsubpb.Topic{Name: t.name}); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	if _, err := pubsubClient.CreateTopic(ctx, id); err != nil {
+		return nil, err
+	}
+	return openTopic(id), nil
+}
+
+// grpcTopic is a topic of the default, gRPC based, client
+type grpcTopic struct {
+	topic *pubsub.Topic
+}
+
+func (t *grpcTopic) ID() string {
+	return t.topic.ID()
+}
+
+func (t *grpcTopic) Exists(ctx context.Context) (bool, error) {
+	return t.topic.Exists(ctx)
+}
+
+func (t *grpcTopic) TestPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	return t.topic.IAM().TestPermissions(ctx, permissions)
+}
+
+func (t *grpcTopic) Publish(ctx context.Context, msg *pubsub.Message) error {
+	_, err := t.topic.Publish(ctx, msg).Get(ctx)
+	return err
+}
+
+// restTopic is a topic of the REST client
+type restTopic struct {
+	client *pubsubapi.PublisherClient
+	id     string
+	name   string
+}
+
+func (t *restTopic) ID() string {
+	return t.id
+}
+
+func (t *restTopic) Exists(ctx context.Context) (bool, error) {
+	_, err := t.client.GetTopic(ctx, &pubsubpb.GetTopicRequest{Topic: t.name})
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t *restTopic) TestPermissions(ctx context.Context, permissions []string) ([]string, error) {
+	resp, err := t.client.TestIamPermissions(ctx, &iampb.TestIamPermissionsRequest{
+		Resource:    t.name,
+		Permissions: permissions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Permissions, nil
+}
+
+func (t *restTopic) Publish(ctx context.Context, msg *pubsub.Message) error {
+	_, err := t.client.Publish(ctx, &pubsubpb.PublishRequest{
+		Topic: t.name,
+		Messages: []*pubsubpb.PubsubMessage{{
+			Data:        msg.Data,
+			Attributes:  msg.Attributes,
+			OrderingKey: msg.OrderingKey,
+		}},
+	})
+	return err
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// What to do with events beyond the quota
+const (
+	quotaPolicyDrop   = "drop"
+	quotaPolicyReject = "reject"
+)
+
+// Time allowed to count a published event, which is done before answering Slack
+const meterTimeout = 250 * time.Millisecond
+
+var (
+	meteringEnabled bool
+	usage           Store
+
+	// Daily quota of events per team, 0 if unlimited
+	dailyQuota  int64
+	quotaPolicy = quotaPolicyDrop
+)
+
+var (
+	forwardedEvents = newCounterVec("slack_proxy_events_forwarded_total",
+		"Events forwarded, by event type.", "event_type")
+	overQuotaEvents = newCounterVec("slack_proxy_events_over_quota_total",
+		"Events beyond the daily quota of their team, by event type.", "event_type")
+)
+
+// setupMetering configures the usage metering and quotas from the environment
+func setupMetering() {
+	if getenv("METERING") != "true" {
+		return
+	}
+	meteringEnabled = true
+
+	// Counts in expiring daily buckets
+	usage = newCounterStoreFromEnv("METERING_STORE")
+
+	if quota := getenv("QUOTA_DAILY"); quota != "" {
+		var err error
+		if dailyQuota, err = strconv.ParseInt(quota, 10, 64); err != nil || dailyQuota < 0 {
+			configErrorf("QUOTA_DAILY must be a non-negative integer.")
+		}
+	}
+
+	switch policy := getenv("QUOTA_POLICY"); policy {
+	case "":
+	case quotaPolicyDrop, quotaPolicyReject:
+		quotaPolicy = policy
+	default:
+		configErrorf("Unknown QUOTA_POLICY: %s.", policy)
+	}
+}
+
+// Time the daily usage counters are kept, covering the day in every time zone
+const usageRetention = 48 * time.Hour
+
+// usageKey returns the key counting the team's forwarded events of the day
+func usageKey(teamID, day string) string {
+	return "usage:" + teamID + ":" + day
+}
+
+// checkQuota checks the event is within its team's daily quota.
+// Returns 0 if the event may be forwarded, or the status to respond with otherwise.
+// Only published events count towards the quota, by meterEvent, so concurrent events may exceed it slightly.
+// Counter failures let the event through.
+func checkQuota(ctx context.Context, payload *slackPayload) int {
+	if payload.TeamID == "" {
+		return 0
+	}
+
+	quota := dailyQuota
+	if t := tenantFromContext(ctx); t != nil && t.DailyQuota != 0 {
+		quota = t.DailyQuota
+	}
+	if quota <= 0 {
+		return 0
+	}
+
+	day := clock.Now().UTC().Format("2006-01-02")
+	value, _, err := usage.Get(ctx, usageKey(payload.TeamID, day))
+	if err != nil {
+		logError(ctx, "Failed checking quota: %s", err.Error())
+		return 0
+	}
+	if count, _ := strconv.ParseInt(value, 10, 64); count < quota {
+		return 0
+	}
+
+	overQuotaEvents.Inc(payload.eventType())
+	if usageTable != nil {
+		countUsage(payload.TeamID, true)
+	}
+
+	// Warn once a day per team, across instances
+	if first, err := usage.SetNX(ctx, "usage-exceeded:"+payload.TeamID+":"+day, "1", usageRetention); err == nil && first {
+		logWarning(ctx, "Team %s exceeded its daily quota of %d events.", payload.TeamID, quota)
+	}
+
+	if quotaPolicy == quotaPolicyReject {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusOK
+}
+
+// meterEvent counts a published event towards its team's daily usage.
+// Failed publishes, retried by Slack, aren't counted. Counter failures are logged, leaving the usage short.
+func meterEvent(ctx context.Context, payload *slackPayload) {
+	if payload.TeamID == "" {
+		return
+	}
+
+	// The event is already published, so it's counted even if the request's budget ran out
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, meterTimeout)
+	defer cancel()
+
+	day := clock.Now().UTC().Format("2006-01-02")
+	if _, err := usage.Incr(ctx, usageKey(payload.TeamID, day), usageRetention); err != nil {
+		logError(ctx, "Failed metering event: %s", err.Error())
+	}
+
+	if usageTable != nil {
+		countUsage(payload.TeamID, false)
+	}
+}
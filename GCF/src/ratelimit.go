@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultRateLimitWindow = time.Minute
+
+var (
+	// Requests allowed per team per window, 0 if unlimited
+	rateLimit       int64
+	rateLimitWindow = defaultRateLimitWindow
+	rateLimitCounts Store
+
+	rateLimitedEvents = newCounterVec("slack_proxy_events_rate_limited_total",
+		"Events answered with a 429 for exceeding RATE_LIMIT, by team.", "team_id")
+)
+
+// setupRateLimit configures the inbound rate limit from the environment
+func setupRateLimit() {
+	rateLimit = int64(configInt("RATE_LIMIT", 0))
+	if rateLimit <= 0 {
+		rateLimit = 0
+		return
+	}
+
+	rateLimitWindow = configDuration("RATE_LIMIT_WINDOW", defaultRateLimitWindow, false)
+
+	// Counts in expiring fixed windows
+	rateLimitCounts = newCounterStoreFromEnv("RATE_LIMIT_STORE")
+}
+
+// rateLimited counts the event towards its team's rate limit.
+// Returns the time until the window ends if the team is over the limit, 0 otherwise.
+// Counter failures let the event through.
+func rateLimited(ctx context.Context, payload *slackPayload) time.Duration {
+	if payload.TeamID == "" {
+		return 0
+	}
+
+	now := clock.Now()
+	windowStart := now.Truncate(rateLimitWindow)
+	key := "ratelimit:" + payload.TeamID + ":" + strconv.FormatInt(windowStart.Unix(), 10)
+	count, err := rateLimitCounts.Incr(ctx, key, 2*rateLimitWindow)
+	if err != nil {
+		logError(ctx, "Failed counting the rate limit: %s", err.Error())
+		return 0
+	}
+
+	if count <= rateLimit {
+		return 0
+	}
+
+	rateLimitedEvents.Inc(payload.TeamID)
+	if count == rateLimit+1 {
+		logWarning(ctx, "Team %s exceeded the rate limit of %d requests per %s.", payload.TeamID, rateLimit, rateLimitWindow)
+	}
+	return windowStart.Add(rateLimitWindow).Sub(now)
+}
+
+// writeRateLimited answers a rate limited request, asking Slack to retry once the window ends
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64((retryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	writeError(w, http.StatusTooManyRequests, errorRateLimited)
+}
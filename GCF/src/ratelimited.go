@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Payload type Slack sends once a minute while throttling the app's event deliveries
+const payloadAppRateLimited = "app_rate_limited"
+
+var (
+	// Topic receiving the app_rate_limited payloads instead of the destination topic, nil if unset
+	opsTopic pubsubTopic
+
+	appRateLimited = newCounterVec("slack_proxy_app_rate_limited_total",
+		"app_rate_limited payloads received from Slack, by team id.", "team_id")
+)
+
+// setupOpsTopic configures the topic of the operational payloads from the environment
+func setupOpsTopic() {
+	if topicName := getenv("OPS_TOPIC"); topicName != "" {
+		opsTopic = openExistingTopic(topicName)
+	}
+}
+
+// recordAppRateLimited logs, counts and alerts on an app_rate_limited payload.
+// Slack is about to drop the app's events, so a single one is alerted on, once per alert window.
+func recordAppRateLimited(ctx context.Context, payload *slackPayload) {
+	appRateLimited.Inc(payload.TeamID)
+
+	minute := time.Unix(payload.MinuteRateLimited, 0).UTC().Format(time.RFC3339)
+	logWarning(ctx, "Slack rate limited the app's events for team %s in the minute of %s.", payload.TeamID, minute)
+
+	if alertWebhookURL != "" && countFailure(alertAppRateLimited, 1) {
+		sendAlert(ctx, alertAppRateLimited, fmt.Sprintf(
+			":rotating_light: %s: Slack is rate limiting the events of team %s (minute of %s).",
+			serviceName(), payload.TeamID, minute))
+	}
+}
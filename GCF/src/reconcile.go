@@ -0,0 +1,258 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultReconcileDelay = 10 * time.Minute
+
+	// Time allowed to count a delivery, once Slack was answered
+	reconcileTimeout = time.Second
+
+	// How long the delivery counts are kept for the reconciliation job
+	reconcileRetention = 48 * time.Hour
+
+	// Key of the start of the last reconciled window
+	reconcileLastKey = "reconcile:last"
+)
+
+// Delivery counts of a window, by the events first delivered in it
+const (
+	// Distinct event ids that reached the proxy
+	countEvents = "events"
+
+	// Distinct event ids published, or forwarded to the unfurl service
+	countPublished = "published"
+
+	// Distinct event ids acknowledged without being published, as filtered, sampled out, duplicates or answered by a rule
+	countDropped = "dropped"
+
+	// Retried deliveries, carrying X-Slack-Retry-Num
+	countRetries = "retries"
+
+	// Event ids first seen on a retry, whose first attempt never reached the proxy
+	countMissedFirstAttempts = "missed_first_attempts"
+)
+
+var (
+	// Length of the reconciled windows, 0 if disabled
+	reconcileWindow time.Duration
+
+	// Time after the end of a window before it is reconciled, leaving time for Slack's retries
+	reconcileDelay = defaultReconcileDelay
+
+	reconcileCounts Store
+
+	reconcileDiscrepancies = newCounterVec("slack_proxy_reconciliation_discrepancies_total",
+		"Events of reconciled windows that were never published nor dropped, or whose first attempt never arrived, by kind.", "kind")
+)
+
+// reconciledWindow is the report of a reconciled window
+type reconciledWindow struct {
+	Start               time.Time `json:"start"`
+	Events              int64     `json:"events"`
+	Published           int64     `json:"published"`
+	Dropped             int64     `json:"dropped"`
+	Retries             int64     `json:"retries"`
+	MissedFirstAttempts int64     `json:"missed_first_attempts"`
+
+	// Events that reached the proxy but were never published nor dropped, which Slack gave up on
+	Unpublished int64 `json:"unpublished"`
+}
+
+// setupReconciliation configures the publish reconciliation from the environment.
+// The job is served by the Reconciler function, meant to be triggered by Cloud Scheduler.
+func setupReconciliation() {
+	if reconcileWindow = configDuration("RECONCILE_WINDOW", 0, false); reconcileWindow == 0 {
+		return
+	}
+
+	reconcileDelay = configDuration("RECONCILE_DELAY", defaultReconcileDelay, false)
+	if reconcileWindow+reconcileDelay >= reconcileRetention {
+		configErrorf("RECONCILE_WINDOW and RECONCILE_DELAY must add up to less than %s.", reconcileRetention)
+	}
+
+	// The job reads the counts of all instances
+	if backend := getenv("RECONCILE_STORE"); customStore == nil && backend != storeFirestore && backend != storeRedis {
+		configErrorf("RECONCILE_STORE must be a store shared by the instances and the job: firestore or redis.")
+		return
+	}
+	reconcileCounts = newStoreFromEnv("RECONCILE_STORE")
+}
+
+// reconcileKey returns the key of a count of the window
+func reconcileKey(window int64, count string) string {
+	return fmt.Sprintf("reconcile:%d:%s", window, count)
+}
+
+// deliveryOutcome returns the count of the event's delivery outcome, published or dropped, empty if it failed
+func deliveryOutcome(forwarded bool, status int) string {
+	switch {
+	case forwarded:
+		return countPublished
+	case status >= 200 && status < 300:
+		return countDropped
+	default:
+		return ""
+	}
+}
+
+// recordDelivery counts a delivery of an Events API event, once Slack was answered.
+// It runs before the request ends, so the counts aren't lost to an instance stopped or throttled after it.
+func recordDelivery(ctx context.Context, r *http.Request, payload *slackPayload, outcome string) {
+	// Detached from the request, which may be past its deadline
+	ctx, cancel := context.WithTimeout(detachedContext{ctx}, reconcileTimeout)
+	defer cancel()
+
+	countDelivery(ctx, payload.EventID, r.Header.Get("X-Slack-Retry-Num") != "", outcome)
+}
+
+// countDelivery counts a delivery of an Events API event in the window of its first delivery.
+// Store failures are logged, leaving the counts short.
+func countDelivery(ctx context.Context, eventID string, retry bool, outcome string) {
+	eventKey := "reconcile:event:" + eventID
+
+	window := clock.Now().Truncate(reconcileWindow).Unix()
+	first, err := reconcileCounts.SetNX(ctx, eventKey, strconv.FormatInt(window, 10), reconcileRetention)
+	if err != nil {
+		logError(ctx, "Failed recording delivery: %s", err.Error())
+		return
+	}
+
+	incr := func(count string) {
+		if _, err := reconcileCounts.Incr(ctx, reconcileKey(window, count), reconcileRetention); err != nil {
+			logError(ctx, "Failed recording delivery: %s", err.Error())
+		}
+	}
+
+	if first {
+		incr(countEvents)
+		if retry {
+			incr(countMissedFirstAttempts)
+		}
+	} else {
+		// Counted in the window of the first delivery
+		value, found, err := reconcileCounts.Get(ctx, eventKey)
+		if err != nil || !found {
+			logError(ctx, "Failed looking up the window of event %s.", eventID)
+			return
+		}
+		window, _ = strconv.ParseInt(value, 10, 64)
+	}
+
+	if retry {
+		incr(countRetries)
+	}
+
+	if outcome == "" {
+		return
+	}
+
+	// Only the first outcome counts, as the retries of a published event are dropped as duplicates
+	counted, err := reconcileCounts.SetNX(ctx, "reconcile:outcome:"+eventID, outcome, reconcileRetention)
+	if err != nil {
+		logError(ctx, "Failed recording delivery: %s", err.Error())
+		return
+	}
+	if counted {
+		incr(outcome)
+	}
+}
+
+// Reconciler reconciles the events that reached the proxy with those it published or dropped, for the windows ended since its last run.
+// Discrepancies are logged and counted, to detect events lost silently.
+func Reconciler(w http.ResponseWriter, r *http.Request) {
+	Setup()
+
+	if reconcileWindow == 0 {
+		http.Error(w, "Reconciliation is not configured.", http.StatusNotFound)
+		return
+	}
+
+	windows, err := reconcile(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "reconciliation_failed")
+		logError(r.Context(), "Failed reconciling deliveries: %s", err.Error())
+		reportError(fmt.Errorf("failed reconciling deliveries: %w", err), r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"windows": windows})
+}
+
+// reconcile reconciles the windows ended at least RECONCILE_DELAY ago, since the last reconciled one.
+// The last window is only advanced once reconciled, so a failed run is retried by the next one.
+func reconcile(ctx context.Context) ([]reconciledWindow, error) {
+	step := int64(reconcileWindow / time.Second)
+	cutoff := clock.Now().Add(-reconcileDelay).Truncate(reconcileWindow).Unix()
+	oldest := clock.Now().Add(-reconcileRetention).Truncate(reconcileWindow).Unix()
+
+	// The first run reconciles the last ended window
+	next := cutoff - step
+	value, found, err := reconcileCounts.Get(ctx, reconcileLastKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the last reconciled window: %w", err)
+	}
+	if found {
+		last, _ := strconv.ParseInt(value, 10, 64)
+		next = last + step
+	}
+	if next < oldest {
+		next = oldest
+	}
+
+	windows := []reconciledWindow{}
+	for window := next; window < cutoff; window += step {
+		report, err := reconcileWindowCounts(ctx, window)
+		if err != nil {
+			return windows, err
+		}
+		windows = append(windows, report)
+
+		if report.Unpublished > 0 || report.MissedFirstAttempts > 0 {
+			reconcileDiscrepancies.Add("unpublished", uint64(report.Unpublished))
+			reconcileDiscrepancies.Add(countMissedFirstAttempts, uint64(report.MissedFirstAttempts))
+			logWarning(ctx, "Deliveries of %s don't reconcile: %d events, %d published, %d dropped, %d unpublished, %d first attempts missed, %d retries.",
+				report.Start.Format(time.RFC3339), report.Events, report.Published, report.Dropped, report.Unpublished,
+				report.MissedFirstAttempts, report.Retries)
+		} else {
+			logInfo(ctx, "Deliveries of %s reconcile: %d events, %d published, %d dropped, %d retries.",
+				report.Start.Format(time.RFC3339), report.Events, report.Published, report.Dropped, report.Retries)
+		}
+
+		if err := reconcileCounts.Set(ctx, reconcileLastKey, strconv.FormatInt(window, 10), reconcileRetention); err != nil {
+			return windows, fmt.Errorf("failed saving the last reconciled window: %w", err)
+		}
+	}
+	return windows, nil
+}
+
+// reconcileWindowCounts reads the delivery counts of the window
+func reconcileWindowCounts(ctx context.Context, window int64) (reconciledWindow, error) {
+	report := reconciledWindow{Start: time.Unix(window, 0).UTC()}
+	for count, value := range map[string]*int64{
+		countEvents:              &report.Events,
+		countPublished:           &report.Published,
+		countDropped:             &report.Dropped,
+		countRetries:             &report.Retries,
+		countMissedFirstAttempts: &report.MissedFirstAttempts,
+	} {
+		text, found, err := reconcileCounts.Get(ctx, reconcileKey(window, count))
+		if err != nil {
+			return report, fmt.Errorf("failed reading the %s of %d: %w", count, window, err)
+		}
+		if found {
+			*value, _ = strconv.ParseInt(text, 10, 64)
+		}
+	}
+
+	report.Unpublished = report.Events - report.Published - report.Dropped
+	return report, nil
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+)
+
+const errorInternal = "internal_error"
+
+var recoveredPanics = newCounterVec("slack_proxy_panics_recovered_total",
+	"Panics recovered while handling requests, by handler.", "handler")
+
+// withRecovery wraps a handler, answering panicking requests with a 500 rather than crashing the instance.
+// Panics are logged with their stack trace, and reported.
+func withRecovery(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w}
+
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// Deliberate aborts of the response are left to net/http
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			recoveredPanics.Inc(name)
+			logError(r.Context(), "Recovered panic handling request %s: %v\n\n%s", requestID(r), p, debug.Stack())
+			reportPanic(p, r)
+
+			if rec.status == 0 {
+				writeError(w, http.StatusInternalServerError, errorInternal)
+			}
+		}()
+
+		handler(rec, r)
+	}
+}
+
+// requestID returns the id identifying the request in the logs: its trace id,
+// the id assigned by the platform, or a random id otherwise
+func requestID(r *http.Request) string {
+	if trace, ok := r.Context().Value(traceContextKey{}).(requestTrace); ok {
+		return trace.traceID
+	}
+
+	for _, header := range []string{"Function-Execution-Id", "X-Request-Id"} {
+		if id := r.Header.Get(header); id != "" {
+			return id
+		}
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// releaseOnPanic releases the dedup claim of an event whose handling panicked, before re-panicking
+func releaseOnPanic(e *Event) {
+	if p := recover(); p != nil {
+		e.release()
+		panic(p)
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Payloads hold private Slack messages, and are never logged unless LOG_PAYLOADS is set.
+// All payload logging must go through logPayload.
+
+const (
+	defaultLogPayloadMaxBytes = 1024
+	redactedValue             = "[REDACTED]"
+)
+
+// Request and response headers holding credentials, never sent outside the proxy
+var sensitiveHeaders = setOf("X-Slack-Signature", "Authorization", "Cookie", "Set-Cookie")
+
+// isSensitiveHeader returns true if the header holds credentials
+func isSensitiveHeader(name string) bool {
+	return sensitiveHeaders[http.CanonicalHeaderKey(name)]
+}
+
+// Fields redacted by default, at any depth of the payload
+const defaultRedactFields = "text,blocks,attachments,files,message,previous_message,email,value,values,token,response_url,trigger_id"
+
+var (
+	logPayloads        bool
+	logPayloadMaxBytes = defaultLogPayloadMaxBytes
+	redactFields       map[string]bool
+)
+
+// setupPayloadLogging configures the payload logging escape hatch from the environment
+func setupPayloadLogging() {
+	if getenv("LOG_PAYLOADS") != "true" {
+		return
+	}
+	logPayloads = true
+
+	logPayloadMaxBytes = configInt("LOG_PAYLOAD_MAX_BYTES", defaultLogPayloadMaxBytes)
+
+	fields, ok := lookupenv("LOG_REDACT_FIELDS")
+	if !ok {
+		fields = defaultRedactFields
+	}
+	redactFields = map[string]bool{}
+	for _, field := range strings.Split(fields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			redactFields[field] = true
+		}
+	}
+
+	logWarning(context.Background(), "Warning: payload logging is enabled.")
+}
+
+// logPayload logs the payload redacted and truncated, if payload logging is enabled
+func logPayload(ctx context.Context, body []byte) {
+	if !logPayloads {
+		return
+	}
+
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		// Can't redact what can't be parsed
+		logInfo(ctx, "Payload: <%d unparsable bytes>", len(body))
+		return
+	}
+
+	redacted, err := json.Marshal(redact(payload))
+	if err != nil {
+		return
+	}
+
+	if len(redacted) > logPayloadMaxBytes {
+		logInfo(ctx, "Payload: %s... (truncated)", redacted[:logPayloadMaxBytes])
+		return
+	}
+	logInfo(ctx, "Payload: %s", redacted)
+}
+
+// redact replaces the values of the redacted fields, recursively
+func redact(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if redactFields[key] {
+				v[key] = redactedValue
+			} else {
+				v[key] = redact(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redact(item)
+		}
+	}
+	return value
+}
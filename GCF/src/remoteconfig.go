@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Delay between reconnections of the remote configuration listener
+const remoteConfigRetryDelay = 10 * time.Second
+
+// liveConfig holds the settings that can change at runtime.
+// Changes build a new one and swap it in, and each request keeps the one it started with.
+type liveConfig struct {
+	// Attributes extracted from the payloads, in order. Empty if none.
+	pathAttributes []pathAttribute
+
+	// Active filters, in evaluation order
+	eventFilters []eventFilter
+
+	// Users whose payloads are dropped, nil if none
+	deniedUsers map[string]bool
+
+	// If set, only the payloads of these users are forwarded
+	allowedUsers map[string]bool
+
+	// Sample rates by event type, events of other types are always forwarded
+	sampleRates map[string]float64
+
+	// Priorities by event type, nil if disabled
+	eventPriorities map[string]string
+
+	// Priority of the events of other types, empty to leave them without one
+	defaultPriority string
+
+	// Template of the destination topic name, nil if disabled
+	topicTemplate *template.Template
+
+	// Create templated or tenant topics that don't exist yet
+	autoCreateTopics bool
+
+	// Channel routes, in evaluation order
+	channelRoutes []channelRoute
+
+	// Routing rules, in evaluation order. The first matching rule applies.
+	routingRules []*routingRule
+
+	// Ruleset evaluated alongside the live one without applying it, nil if unset
+	proposedRoutingRules []*routingRule
+
+	// Topic ids of the payloads matching no rule, and of the dead-lettered ones. Empty if unset.
+	routingDefaultTopic    string
+	routingDeadLetterTopic string
+
+	// Tenant registry, nil if disabled
+	tenants tenantRegistry
+}
+
+var (
+	// Settings applied again on every change of the configuration, in setup order
+	liveSetups = []func(*liveConfig){
+		setupPathAttributes, setupFilters, setupSampling, setupPriorities, setupTenantRegistry, setupTopicTemplate,
+		setupChannelRoutes, setupRoutingRules,
+	}
+
+	// Live settings of the new requests
+	currentLiveConfig atomic.Pointer[liveConfig]
+
+	remoteConfigDoc     *firestore.DocumentRef
+	remoteConfigWatch   sync.Once
+	remoteConfigUpdated time.Time
+)
+
+type liveConfigContextKey struct{}
+
+// withLiveConfig attaches the live settings the request runs with to the context
+func withLiveConfig(ctx context.Context, live *liveConfig) context.Context {
+	return context.WithValue(ctx, liveConfigContextKey{}, live)
+}
+
+// liveConfigFrom returns the live settings of the request, or the current ones outside of requests
+func liveConfigFrom(ctx context.Context) *liveConfig {
+	if live, ok := ctx.Value(liveConfigContextKey{}).(*liveConfig); ok {
+		return live
+	}
+	if live := currentLiveConfig.Load(); live != nil {
+		return live
+	}
+	return &liveConfig{}
+}
+
+// loadRemoteConfig reads the Firestore document at REMOTE_CONFIG_DOCUMENT, e.g. slack-proxy-config/live.
+// Its fields are variable names, and changes to the live settings apply without a redeploy.
+func loadRemoteConfig() {
+	path := getenv("REMOTE_CONFIG_DOCUMENT")
+	if path == "" {
+		return
+	}
+
+	collection, id, ok := strings.Cut(path, "/")
+	if !ok || collection == "" || id == "" || strings.Contains(id, "/") {
+		configErrorf("REMOTE_CONFIG_DOCUMENT must be of the form <collection>/<document>.")
+		return
+	}
+	if getenv("GCP_PROJECT") == "" {
+		configErrorf("GCP_PROJECT env var must be set when REMOTE_CONFIG_DOCUMENT is set.")
+		return
+	}
+	remoteConfigDoc = firestoreClient().Collection(collection).Doc(id)
+
+	doc, err := remoteConfigDoc.Get(context.Background())
+	switch {
+	case status.Code(err) == codes.NotFound:
+		setRemoteConfig(nil, time.Time{})
+	case err != nil:
+		configErrorf("Failed reading REMOTE_CONFIG_DOCUMENT: %s.", err.Error())
+		return
+	default:
+		setRemoteConfig(doc.Data(), doc.UpdateTime)
+	}
+
+	remoteConfigWatch.Do(func() { go watchRemoteConfig() })
+}
+
+// setRemoteConfig replaces the values of the remote configuration
+func setRemoteConfig(values map[string]any, updated time.Time) {
+	configMu.Lock()
+	configRemote = configFromMap(values)
+	remoteConfigUpdated = updated
+	configMu.Unlock()
+}
+
+// watchRemoteConfig applies the changes of the remote configuration document as they happen
+func watchRemoteConfig() {
+	// Changes apply once setup is done
+	Setup()
+	ctx := context.Background()
+
+	for {
+		snapshots := remoteConfigDoc.Snapshots(ctx)
+		for {
+			doc, err := snapshots.Next()
+			if err != nil {
+				logWarning(ctx, "Remote configuration listener failed: %s", err.Error())
+				break
+			}
+			applyRemoteConfig(ctx, doc)
+		}
+		snapshots.Stop()
+		time.Sleep(remoteConfigRetryDelay)
+	}
+}
+
+// applyRemoteConfig applies the snapshot of the document, unless it was already applied
+func applyRemoteConfig(ctx context.Context, doc *firestore.DocumentSnapshot) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	var values map[string]any
+	var updated time.Time
+	if doc.Exists() {
+		values, updated = doc.Data(), doc.UpdateTime
+	}
+
+	configMu.Lock()
+	unchanged := updated.Equal(remoteConfigUpdated)
+	configMu.Unlock()
+	if unchanged {
+		return
+	}
+
+	setRemoteConfig(values, updated)
+	configErrors = nil
+	if err := applyLiveConfig(); err != nil {
+		logError(ctx, "Invalid remote configuration: %s", err.Error())
+		reportError(fmt.Errorf("invalid remote configuration: %w", err), nil)
+	}
+}
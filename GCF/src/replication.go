@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	pubsubapi "cloud.google.com/go/pubsub/apiv1"
+	"google.golang.org/api/option"
+)
+
+// Replication modes of the secondary topics
+const (
+	// Publish to the secondary topic when publishing to the primary one fails
+	replicationFailover = "failover"
+
+	// Publish to both topics, succeeding if either accepted the message
+	replicationMirror = "mirror"
+)
+
+// Message attributes identifying the regions
+const (
+	attrOriginRegion  = "origin_region"
+	attrPublishRegion = "publish_region"
+)
+
+var (
+	// Region of the proxy instance, empty if unset
+	originRegion string
+
+	replicationMode = replicationFailover
+
+	// Labels of the regions of the topics
+	primaryRegion   = "primary"
+	secondaryRegion = "secondary"
+
+	// Clients of the secondary project or endpoint, both nil if disabled
+	secondaryClient  *pubsub.Client
+	secondaryREST    *pubsubapi.PublisherClient
+	secondaryProject string
+
+	// Handles of the secondary topics, by id
+	secondaryTopicsMu sync.Mutex
+	secondaryTopics   = map[string]pubsubTopic{}
+)
+
+var replicaFailures = newCounterVec("slack_proxy_replica_publish_failures_total",
+	"Failed publishes to either of the replicated topics, by region.", "region")
+
+// setupReplication configures the secondary topics from the environment.
+// Messages are published to topics of the same ids in the secondary project or regional endpoint.
+func setupReplication() {
+	originRegion = getenv("PROXY_REGION")
+
+	secondaryProject = getenv("PUBSUB_SECONDARY_PROJECT")
+	endpoint := getenv("PUBSUB_SECONDARY_ENDPOINT")
+	if secondaryProject == "" && endpoint == "" {
+		return
+	}
+	if backend != backendPubSub || topic == nil {
+		configErrorf("Secondary topics are only supported by the pubsub backend.")
+		return
+	}
+	if secondaryProject == "" {
+		secondaryProject = gcpProject
+	}
+
+	switch replicationMode = getenv("PUBSUB_REPLICATION"); replicationMode {
+	case "":
+		replicationMode = replicationFailover
+	case replicationFailover, replicationMirror:
+	default:
+		configErrorf("Unknown PUBSUB_REPLICATION: %s.", replicationMode)
+	}
+
+	if region := getenv("PUBSUB_REGION"); region != "" {
+		primaryRegion = region
+	}
+	if region := getenv("PUBSUB_SECONDARY_REGION"); region != "" {
+		secondaryRegion = region
+	}
+
+	opts := pubsubClientOptions()
+	if endpoint != "" {
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+
+	var err error
+	secondaryClient, secondaryREST, err = newPubSubClient(secondaryProject, opts...)
+	if err != nil {
+		log.Panicf("Failed creating the secondary Pub/Sub client: %s.", err.Error())
+	}
+
+	if exists, err := secondaryTopic(topic.ID()).Exists(context.Background()); err != nil || !exists {
+		configErrorf("Secondary topic %s doesn't exist.", topic.ID())
+	}
+}
+
+// secondaryTopic returns the cached handle of the secondary topic of the id
+func secondaryTopic(id string) pubsubTopic {
+	secondaryTopicsMu.Lock()
+	defer secondaryTopicsMu.Unlock()
+
+	t, ok := secondaryTopics[id]
+	if !ok {
+		t = openClientTopic(secondaryClient, secondaryREST, secondaryProject, id)
+		secondaryTopics[id] = t
+	}
+	return t
+}
+
+// publishReplicated publishes the message to the topic, and to its secondary topic if enabled
+func publishReplicated(ctx context.Context, t pubsubTopic, msg *pubsub.Message) error {
+	if secondaryClient == nil && secondaryREST == nil {
+		return t.Publish(ctx, msg)
+	}
+
+	secondary := secondaryTopic(t.ID())
+
+	// Mirroring falls back to failover when flagged off
+	if replicationMode == replicationMirror && flagEnabled(flagMirroring) {
+		var wg sync.WaitGroup
+		var primaryErr, secondaryErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			primaryErr = t.Publish(ctx, withPublishRegion(msg, primaryRegion))
+		}()
+		go func() {
+			defer wg.Done()
+			secondaryErr = secondary.Publish(ctx, withPublishRegion(msg, secondaryRegion))
+		}()
+		wg.Wait()
+
+		if primaryErr != nil {
+			replicaFailures.Inc(primaryRegion)
+			logWarning(ctx, "Failed mirroring to %s: %s", primaryRegion, primaryErr.Error())
+		}
+		if secondaryErr != nil {
+			replicaFailures.Inc(secondaryRegion)
+			logWarning(ctx, "Failed mirroring to %s: %s", secondaryRegion, secondaryErr.Error())
+		}
+		if primaryErr != nil && secondaryErr != nil {
+			return errors.Join(primaryErr, secondaryErr)
+		}
+		return nil
+	}
+
+	// The primary gets half of the time left, so a timeout still leaves the secondary its own share
+	primaryCtx, cancel := ctx, context.CancelFunc(func() {})
+	if deadline, ok := ctx.Deadline(); ok {
+		primaryCtx, cancel = context.WithTimeout(ctx, time.Until(deadline)/2)
+	}
+	err := t.Publish(primaryCtx, withPublishRegion(msg, primaryRegion))
+	cancel()
+	if err == nil {
+		return nil
+	}
+
+	replicaFailures.Inc(primaryRegion)
+	logWarning(ctx, "Failed publishing to %s, failing over to %s: %s", primaryRegion, secondaryRegion, err.Error())
+
+	if secondaryErr := secondary.Publish(ctx, withPublishRegion(msg, secondaryRegion)); secondaryErr != nil {
+		replicaFailures.Inc(secondaryRegion)
+		return errors.Join(err, secondaryErr)
+	}
+	return nil
+}
+
+// withPublishRegion returns a copy of the message, marked with the region it's published to
+func withPublishRegion(msg *pubsub.Message, region string) *pubsub.Message {
+	attributes := make(map[string]string, len(msg.Attributes)+1)
+	for name, value := range msg.Attributes {
+		attributes[name] = value
+	}
+	attributes[attrPublishRegion] = region
+
+	return &pubsub.Message{Data: msg.Data, Attributes: attributes, OrderingKey: msg.OrderingKey}
+}
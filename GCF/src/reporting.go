@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error reporting backends
+const (
+	reportingNone   = ""
+	reportingGoogle = "google"
+	reportingSentry = "sentry"
+)
+
+var (
+	errorReporting string
+
+	// Sentry store endpoint and auth header, parsed from the DSN
+	sentryStoreURL string
+	sentryAuth     string
+
+	sentryClient = &http.Client{Timeout: time.Second}
+)
+
+// deferredReports holds the Sentry reports of a request until it's answered
+type deferredReports struct {
+	mu     sync.Mutex
+	events [][]byte
+	sent   bool
+}
+
+type deferredReportsKey struct{}
+
+// deferReports holds the Sentry reports of the request until sendDeferredReports is called
+func deferReports(r *http.Request) *http.Request {
+	if errorReporting != reportingSentry {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), deferredReportsKey{}, &deferredReports{}))
+}
+
+// hold holds the report, unless the request's reports were sent already
+func (reports *deferredReports) hold(event []byte) bool {
+	reports.mu.Lock()
+	defer reports.mu.Unlock()
+
+	if reports.sent {
+		return false
+	}
+	reports.events = append(reports.events, event)
+	return true
+}
+
+// sendDeferredReports answers the request, unless w is nil, then sends the reports held for it.
+// Runs before the request ends, so the reports aren't lost to an instance stopped or throttled after it.
+// Reports of work still running past it, e.g. ACK_FIRST publishes, are sent right away.
+func sendDeferredReports(w http.ResponseWriter, r *http.Request) {
+	reports, _ := r.Context().Value(deferredReportsKey{}).(*deferredReports)
+	if reports == nil {
+		return
+	}
+
+	reports.mu.Lock()
+	events := reports.events
+	reports.events, reports.sent = nil, true
+	reports.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	for _, event := range events {
+		sendToSentry(event)
+	}
+}
+
+// setupErrorReporting configures the error reporting backend from the environment.
+// SENTRY_DSN enables Sentry, ERROR_REPORTING=google enables Google Error Reporting.
+func setupErrorReporting() {
+	if dsn := getenv("SENTRY_DSN"); dsn != "" {
+		if err := parseSentryDSN(dsn); err != nil {
+			configErrorf("Invalid SENTRY_DSN: %s.", err.Error())
+			return
+		}
+		errorReporting = reportingSentry
+		return
+	}
+
+	switch backend := getenv("ERROR_REPORTING"); backend {
+	case reportingNone, reportingGoogle:
+		errorReporting = backend
+	default:
+		configErrorf("Unknown ERROR_REPORTING backend: %s.", backend)
+	}
+}
+
+// parseSentryDSN parses a DSN of the form https://<key>@<host>/<project>
+func parseSentryDSN(dsn string) error {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return err
+	}
+
+	if u.User == nil || u.User.Username() == "" {
+		return fmt.Errorf("missing public key")
+	}
+
+	path, project, ok := cutLast(strings.TrimSuffix(u.Path, "/"), "/")
+	if !ok || project == "" {
+		return fmt.Errorf("missing project id")
+	}
+
+	sentryStoreURL = fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path, project)
+	sentryAuth = fmt.Sprintf("Sentry sentry_version=7, sentry_client=slack-proxy/1.0, sentry_key=%s",
+		u.User.Username())
+	return nil
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// reportError reports an unexpected error with the request context attached.
+// The request may be nil for errors happening outside of a request.
+func reportError(err error, r *http.Request) {
+	switch errorReporting {
+	case reportingGoogle:
+		reportToGoogle(err.Error(), r, 2)
+	case reportingSentry:
+		reportToSentry(err.Error(), r)
+	}
+}
+
+// reportPanic reports a recovered panic along with its stack trace
+func reportPanic(p any, r *http.Request) {
+	message := fmt.Sprintf("panic: %v\n\n%s", p, debug.Stack())
+	switch errorReporting {
+	case reportingGoogle:
+		reportToGoogle(message, r, 2)
+	case reportingSentry:
+		reportToSentry(message, r)
+	}
+}
+
+// httpRequestContext is the request context as understood by Error Reporting
+type httpRequestContext struct {
+	Method    string `json:"method"`
+	URL       string `json:"url"`
+	UserAgent string `json:"userAgent,omitempty"`
+	RemoteIP  string `json:"remoteIp,omitempty"`
+}
+
+// reportToGoogle writes a ReportedErrorEvent structured log entry,
+// which Cloud Logging forwards to Error Reporting.
+// https://cloud.google.com/error-reporting/docs/formatting-error-messages
+func reportToGoogle(message string, r *http.Request, skip int) {
+	entry := map[string]any{
+		"@type":   "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",
+		"message": message,
+		"serviceContext": map[string]string{
+			"service": serviceName(),
+		},
+	}
+
+	errorContext := map[string]any{}
+	if pc, file, line, ok := runtime.Caller(skip); ok {
+		errorContext["reportLocation"] = map[string]any{
+			"filePath":     file,
+			"lineNumber":   line,
+			"functionName": runtime.FuncForPC(pc).Name(),
+		}
+	}
+	if r != nil {
+		errorContext["httpRequest"] = httpRequestContext{
+			Method:    r.Method,
+			URL:       r.URL.String(),
+			UserAgent: r.UserAgent(),
+			RemoteIP:  clientIP(r),
+		}
+	}
+	entry["context"] = errorContext
+
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	writeStructured(ctx, os.Stderr, severityError, entry)
+}
+
+// reportToSentry sends an event to the Sentry store endpoint.
+// Reports of requests whose reports are deferred are held until they're answered, others are sent right away.
+// https://develop.sentry.dev/sdk/store/
+func reportToSentry(message string, r *http.Request) {
+	eventID := make([]byte, 16)
+	rand.Read(eventID)
+
+	event := map[string]any{
+		"event_id":    hex.EncodeToString(eventID),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       "error",
+		"platform":    "go",
+		"logger":      "slack-proxy",
+		"server_name": serviceName(),
+		"message":     map[string]string{"formatted": message},
+	}
+	if r != nil {
+		headers := map[string]string{}
+		for name := range r.Header {
+			if !isSensitiveHeader(name) {
+				headers[name] = r.Header.Get(name)
+			}
+		}
+		event["request"] = map[string]any{
+			"method":  r.Method,
+			"url":     r.URL.String(),
+			"headers": headers,
+		}
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logError(context.Background(), "Failed encoding error report: %s", err.Error())
+		return
+	}
+
+	if r != nil {
+		if reports, _ := r.Context().Value(deferredReportsKey{}).(*deferredReports); reports != nil && reports.hold(body) {
+			return
+		}
+	}
+	sendToSentry(body)
+}
+
+// sendToSentry posts an encoded event to the Sentry store endpoint
+func sendToSentry(body []byte) {
+	req, err := http.NewRequest(http.MethodPost, sentryStoreURL, bytes.NewReader(body))
+	if err != nil {
+		logError(context.Background(), "Failed creating error report: %s", err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", sentryAuth)
+
+	resp, err := sentryClient.Do(req)
+	if err != nil {
+		logError(context.Background(), "Failed sending error report: %s", err.Error())
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logWarning(context.Background(), "Sentry rejected error report. Returned status: %d", resp.StatusCode)
+	}
+}
+
+// serviceName returns the name of the deployed function, if known
+func serviceName() string {
+	if name := getenv("K_SERVICE"); name != "" {
+		return name
+	}
+	return "slack-proxy"
+}
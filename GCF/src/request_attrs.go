@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Message attributes holding the request path, query parameters and headers
+const (
+	attrRequestPath = "request_path"
+
+	// Followed by the parameter name
+	attrQueryPrefix = "query_"
+
+	// Followed by the lowercased header name, with dashes replaced by underscores
+	attrHeaderPrefix = "header_"
+)
+
+var (
+	// Attach the request path
+	forwardRequestPath bool
+
+	// Query parameters to attach, nil for none
+	forwardQueryParams []string
+
+	// Canonical names of the headers to attach, nil for none
+	forwardHeaders []string
+)
+
+// setupRequestAttributes configures the request attributes from the environment
+func setupRequestAttributes() {
+	forwardRequestPath = getenv("ATTRIBUTE_REQUEST_PATH") == "true"
+
+	// ATTRIBUTE_QUERY_PARAMS is a comma separated list of parameter names, e.g. app,env
+	if params := getenv("ATTRIBUTE_QUERY_PARAMS"); params != "" {
+		for _, param := range strings.Split(params, ",") {
+			if param = strings.TrimSpace(param); param != "" {
+				forwardQueryParams = append(forwardQueryParams, param)
+			}
+		}
+	}
+
+	// ATTRIBUTE_HEADERS is a comma separated list of header names, e.g. X-Slack-Retry-Num,User-Agent
+	if headers := getenv("ATTRIBUTE_HEADERS"); headers != "" {
+		for _, header := range strings.Split(headers, ",") {
+			if header = strings.TrimSpace(header); header == "" {
+				continue
+			}
+			// Credentials of the request must not reach the consumers
+			if isSensitiveHeader(header) {
+				configErrorf("ATTRIBUTE_HEADERS can't include %s, which holds credentials.", http.CanonicalHeaderKey(header))
+				continue
+			}
+			forwardHeaders = append(forwardHeaders, http.CanonicalHeaderKey(header))
+		}
+	}
+}
+
+// attachRequestAttributes attaches the path, and the configured query parameters and headers of the request.
+// Distinguishes requests of Slack apps sharing a single proxy URL, e.g. by ?app=
+func attachRequestAttributes(r *http.Request, attributes map[string]string) {
+	if forwardRequestPath {
+		attributes[attrRequestPath] = r.URL.Path
+	}
+
+	for _, header := range forwardHeaders {
+		// Repeated headers are joined, as in X-Forwarded-For
+		if values := r.Header.Values(header); len(values) != 0 {
+			attributes[headerAttribute(header)] = strings.Join(values, ", ")
+		}
+	}
+
+	if len(forwardQueryParams) == 0 {
+		return
+	}
+
+	query := r.URL.Query()
+	for _, param := range forwardQueryParams {
+		if value := query.Get(param); value != "" {
+			attributes[attrQueryPrefix+param] = value
+		}
+	}
+}
+
+// headerAttribute returns the attribute name of the header, e.g. header_x_slack_retry_num
+func headerAttribute(header string) string {
+	return attrHeaderPrefix + strings.ReplaceAll(strings.ToLower(header), "-", "_")
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Slack considers the app unresponsive after 3 seconds.
+	// Leave some of it for the network on both ends, and for answering before REQUEST_TIMEOUT.
+	defaultPublishBudget = 2200 * time.Millisecond
+
+	defaultPublishAttempts = 2
+	retryBaseBackoff       = 100 * time.Millisecond
+)
+
+// Message attribute holding the attempt that published the message, counted from 1.
+// Timed out attempts may still have been published, so consumers should drop duplicates by idempotency_key.
+const attrPublishAttempt = "publish_attempt"
+
+var (
+	publishAttempts = defaultPublishAttempts
+	publishBudget   = defaultPublishBudget
+)
+
+// setupRetry configures the publish retries from the environment
+func setupRetry() {
+	publishAttempts = configInt("PUBLISH_ATTEMPTS", defaultPublishAttempts)
+	publishBudget = configDuration("PUBLISH_BUDGET", defaultPublishBudget, false)
+}
+
+// isRetryable returns true for transient errors worth another attempt
+func isRetryable(err error) bool {
+	var statusErr *webhookStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status >= http.StatusInternalServerError || statusErr.status == http.StatusTooManyRequests
+	}
+
+	// The attempt ran out of its share of the budget
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	// Network errors of the webhook backend
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return !errors.Is(err, context.Canceled)
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return true
+	}
+	return false
+}
+
+// publishDeadline returns the deadline of the publish budget, or the context's own deadline
+// if earlier, or once detached from the request, e.g. to publish after acknowledging the event under ACK_FIRST
+func publishDeadline(ctx context.Context) time.Time {
+	deadline := budgetFrom(ctx).deadline
+	if d, ok := ctx.Deadline(); ok && (isDetached(ctx) || d.Before(deadline)) {
+		return d
+	}
+	return deadline
+}
+
+// withPublishAttempt returns a fresh copy of the message tagged with the attempt number.
+// Published messages shouldn't be reused, and a timed out attempt may still be read by the publisher,
+// so each attempt has its own attributes.
+func withPublishAttempt(msg *pubsub.Message, attempt int) *pubsub.Message {
+	attributes := make(map[string]string, len(msg.Attributes)+1)
+	for name, value := range msg.Attributes {
+		attributes[name] = value
+	}
+	attributes[attrPublishAttempt] = strconv.Itoa(attempt)
+
+	return &pubsub.Message{Data: msg.Data, Attributes: attributes, OrderingKey: msg.OrderingKey}
+}
+
+// forwardWithRetry forwards the message, retrying transient failures with jittered
+// exponential backoff within the publish budget of the request.
+// Each attempt gets an equal share of the remaining budget.
+func forwardWithRetry(ctx context.Context, msg *pubsub.Message) error {
+	deadline := publishDeadline(ctx)
+
+	var err error
+	for attempt := 0; attempt < publishAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := retryBaseBackoff << (attempt - 1)
+			backoff += time.Duration(rand.Int63n(int64(backoff)))
+			if time.Until(deadline) < 2*backoff {
+				logWarning(ctx, "Not retrying, publish budget exhausted.")
+				break
+			}
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+
+		share := time.Until(deadline) / time.Duration(publishAttempts-attempt)
+		attemptCtx, cancel := context.WithTimeout(ctx, share)
+		err = forward(attemptCtx, withPublishAttempt(msg, attempt+1))
+		cancel()
+
+		if err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		logWarning(ctx, "Forwarding attempt %d failed: %s", attempt+1, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retainingPublisher fails the first attempts, and keeps reading each message after returning like the Pub/Sub bundler
+type retainingPublisher struct {
+	failures int
+
+	mu       sync.Mutex
+	attempts []map[string]string
+	reads    sync.WaitGroup
+}
+
+func (p *retainingPublisher) Publish(ctx context.Context, msg *pubsub.Message) error {
+	p.reads.Add(1)
+	go func() {
+		defer p.reads.Done()
+		for range msg.Attributes {
+		}
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	attributes := map[string]string{}
+	for name, value := range msg.Attributes {
+		attributes[name] = value
+	}
+	p.attempts = append(p.attempts, attributes)
+
+	if len(p.attempts) <= p.failures {
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	return nil
+}
+
+func withPublisher(t *testing.T, p Publisher) {
+	t.Helper()
+	prevBackend, prevPublisher := backend, publisher
+	backend, publisher = backendCustom, p
+	t.Cleanup(func() { backend, publisher = prevBackend, prevPublisher })
+}
+
+func TestForwardWithRetryTagsEachAttempt(t *testing.T) {
+	p := &retainingPublisher{failures: 1}
+	withPublisher(t, p)
+
+	msg := &pubsub.Message{Data: []byte("{}"), Attributes: map[string]string{"event_type": "message"}}
+	if err := forwardWithRetry(context.Background(), msg); err != nil {
+		t.Fatalf("forwardWithRetry() = %v", err)
+	}
+	p.reads.Wait()
+
+	if len(p.attempts) != 2 {
+		t.Fatalf("got %d attempts, want 2", len(p.attempts))
+	}
+	for i, attributes := range p.attempts {
+		if want := string(rune('1' + i)); attributes[attrPublishAttempt] != want {
+			t.Errorf("attempt %d: %s = %q, want %q", i+1, attrPublishAttempt, attributes[attrPublishAttempt], want)
+		}
+		if attributes["event_type"] != "message" {
+			t.Errorf("attempt %d: lost the message's attributes: %v", i+1, attributes)
+		}
+	}
+
+	if _, ok := msg.Attributes[attrPublishAttempt]; ok {
+		t.Errorf("the caller's attributes were modified: %v", msg.Attributes)
+	}
+}
+
+func TestWithPublishAttemptCopiesAttributes(t *testing.T) {
+	msg := &pubsub.Message{Data: []byte("{}"), Attributes: map[string]string{"a": "1"}, OrderingKey: "T1"}
+
+	first, second := withPublishAttempt(msg, 1), withPublishAttempt(msg, 2)
+	first.Attributes["a"] = "changed"
+
+	if msg.Attributes["a"] != "1" || second.Attributes["a"] != "1" {
+		t.Errorf("attempts share their attributes: %v, %v", msg.Attributes, second.Attributes)
+	}
+	if second.Attributes[attrPublishAttempt] != "2" || second.OrderingKey != "T1" {
+		t.Errorf("withPublishAttempt(msg, 2) = %+v", second)
+	}
+}
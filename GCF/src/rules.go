@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// routingRule routes the payloads it matches to a topic, or answers them right away without publishing
+type routingRule struct {
+	// Name identifying the rule in the logs
+	Name string `json:"name"`
+
+	// Conditions the payload must all meet. An empty match matches every payload.
+	Match ruleMatch `json:"match"`
+
+	// Topic id the payloads are published to
+	Topic string `json:"topic,omitempty"`
+
+	// Response answering the payloads, which are then not published
+	Respond *ruleResponse `json:"respond,omitempty"`
+
+	// What to do when the topic can't be resolved, e.g. if it doesn't exist
+	OnError string `json:"on_error,omitempty"`
+
+	// Topic id of the fallback policy, defaults to the default route
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// Error policies of the rules
+const (
+	// Publish to the rule's fallback topic, or the default route
+	onErrorFallback = "fallback"
+
+	// Publish to ROUTING_DEAD_LETTER_TOPIC
+	onErrorDeadLetter = "dead_letter"
+
+	// Fail the request, so Slack retries it
+	onErrorFail = "fail"
+)
+
+const errorRouting = "routing_failed"
+
+// Attributes of the messages dead-lettered by their rule
+const (
+	attrRoutingRule  = "routing_rule"
+	attrRoutingError = "routing_error"
+)
+
+// ruleMatch holds the conditions of a rule, empty ones are ignored
+type ruleMatch struct {
+	// Payload type, e.g. event_callback or block_actions
+	Type string `json:"type"`
+
+	// Events API event type, or the payload type of other payloads
+	EventType string `json:"event_type"`
+
+	// Slash command, e.g. /deploy
+	Command string `json:"command"`
+
+	// Callback id of the view or shortcut
+	CallbackID string `json:"callback_id"`
+
+	TeamID string `json:"team_id"`
+
+	// Glob of the channel id, or of the channel name if prefixed with #
+	Channel string `json:"channel"`
+}
+
+// ruleResponse is the immediate response of a rule
+type ruleResponse struct {
+	// Status code, defaults to 200
+	Status int `json:"status"`
+
+	// Body, sent as text if a JSON string, e.g. the message shown to the user of a slash command,
+	// or as JSON otherwise, e.g. {"response_type": "ephemeral", "text": "..."}
+	Body json.RawMessage `json:"body"`
+}
+
+// Rule label of the payloads matching no rule
+const noRule = "none"
+
+var (
+	ruleHits = newCounterVec("slack_proxy_routing_rule_hits_total",
+		"Payloads matched by each routing rule, none for those matching no rule.", "rule")
+	proposedRuleDiffs = newCounterVec("slack_proxy_routing_rule_dry_run_diffs_total",
+		"Payloads ROUTING_RULES_DRY_RUN routes differently than ROUTING_RULES, by proposed rule.", "rule")
+)
+
+// setupRoutingRules configures the routing rules from the environment.
+// ROUTING_RULES is a JSON array of rules, e.g. [{"name":"legacy","match":{"command":"/old"},"respond":{"body":"Use /new."}}]
+// ROUTING_RULES_DRY_RUN holds a proposed ruleset in the same format, evaluated against the traffic but not applied.
+func setupRoutingRules(live *liveConfig) {
+	config, proposed := getenv("ROUTING_RULES"), getenv("ROUTING_RULES_DRY_RUN")
+	if config == "" && proposed == "" {
+		return
+	}
+
+	live.routingDefaultTopic = getenv("ROUTING_DEFAULT_TOPIC")
+	if live.routingDefaultTopic != "" && !topicNamePattern.MatchString(live.routingDefaultTopic) {
+		configErrorf("Invalid ROUTING_DEFAULT_TOPIC: %s.", live.routingDefaultTopic)
+	}
+	live.routingDeadLetterTopic = getenv("ROUTING_DEAD_LETTER_TOPIC")
+	if live.routingDeadLetterTopic != "" && !topicNamePattern.MatchString(live.routingDeadLetterTopic) {
+		configErrorf("Invalid ROUTING_DEAD_LETTER_TOPIC: %s.", live.routingDeadLetterTopic)
+	}
+
+	live.routingRules = parseRoutingRules("ROUTING_RULES", config, live.routingDeadLetterTopic)
+	live.proposedRoutingRules = parseRoutingRules("ROUTING_RULES_DRY_RUN", proposed, live.routingDeadLetterTopic)
+}
+
+// parseRoutingRules parses and validates the ruleset of the setting, nil if empty
+func parseRoutingRules(name, config, deadLetterTopic string) []*routingRule {
+	if config == "" {
+		return nil
+	}
+
+	var rules, valid []*routingRule
+	if err := json.Unmarshal([]byte(config), &rules); err != nil {
+		configErrorf("Invalid %s: %s.", name, err.Error())
+		return nil
+	}
+
+	for i, rule := range rules {
+		if err := rule.validate(deadLetterTopic); err != nil {
+			configErrorf("Invalid %s rule %d (%s): %s.", name, i, rule.Name, err.Error())
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	return valid
+}
+
+// validate checks the rule against the dead letter topic, defaulting its response status
+func (rule *routingRule) validate(deadLetterTopic string) error {
+	if rule.Name == "" {
+		return errors.New("missing name")
+	}
+	if rule.Match.Channel != "" {
+		if err := checkChannelPattern(rule.Match.Channel); err != nil {
+			return fmt.Errorf("invalid channel pattern: %w", err)
+		}
+	}
+
+	switch {
+	case rule.Topic != "" && rule.Respond != nil:
+		return errors.New("topic and respond are exclusive")
+	case rule.Topic != "":
+		if !topicNamePattern.MatchString(rule.Topic) {
+			return fmt.Errorf("invalid topic %s", rule.Topic)
+		}
+	case rule.Respond != nil:
+		if rule.Respond.Status == 0 {
+			rule.Respond.Status = http.StatusOK
+		}
+		if rule.Respond.Status < 200 || rule.Respond.Status > 599 {
+			return fmt.Errorf("invalid status %d", rule.Respond.Status)
+		}
+	default:
+		return errors.New("missing topic or respond")
+	}
+
+	switch rule.OnError {
+	case "":
+		rule.OnError = onErrorFallback
+	case onErrorFallback, onErrorFail:
+	case onErrorDeadLetter:
+		if deadLetterTopic == "" {
+			return errors.New("dead_letter requires ROUTING_DEAD_LETTER_TOPIC")
+		}
+	default:
+		return fmt.Errorf("unknown on_error %s", rule.OnError)
+	}
+	if rule.Fallback != "" && !topicNamePattern.MatchString(rule.Fallback) {
+		return fmt.Errorf("invalid fallback %s", rule.Fallback)
+	}
+	return nil
+}
+
+// matches returns true if the payload meets all conditions of the rule
+func (m *ruleMatch) matches(payload *slackPayload, attributes map[string]string) bool {
+	callbackID := payload.CallbackID
+	if callbackID == "" {
+		callbackID = payload.View.CallbackID
+	}
+
+	switch {
+	case m.Type != "" && m.Type != payload.Type:
+	case m.EventType != "" && m.EventType != payload.eventType():
+	case m.Command != "" && m.Command != payload.Command:
+	case m.CallbackID != "" && m.CallbackID != callbackID:
+	case m.TeamID != "" && m.TeamID != payload.TeamID:
+	case m.Channel != "" && !matchesChannel(m.Channel, payload.channelID(), channelName(payload, attributes)):
+	default:
+		return true
+	}
+	return false
+}
+
+// matchRule returns the first rule of the ruleset matching the payload, nil if none
+func matchRule(rules []*routingRule, payload *slackPayload, attributes map[string]string) *routingRule {
+	for _, rule := range rules {
+		if rule.Match.matches(payload, attributes) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// evaluateRules returns the live rule matching the payload, counting the hit.
+// If a ruleset is proposed, logs the payloads it would route differently.
+func evaluateRules(ctx context.Context, payload *slackPayload, attributes map[string]string) *routingRule {
+	var rule *routingRule
+	live := liveConfigFrom(ctx)
+	if live.routingRules != nil {
+		rule = matchRule(live.routingRules, payload, attributes)
+		ruleHits.Inc(rule.name())
+	}
+
+	if live.proposedRoutingRules != nil {
+		proposed := matchRule(live.proposedRoutingRules, payload, attributes)
+		if applied, dryRun := rule.outcome(live.routingDefaultTopic), proposed.outcome(live.routingDefaultTopic); applied != dryRun {
+			proposedRuleDiffs.Inc(proposed.name())
+			logInfo(ctx, "Dry run routes %s payload differently: live rule %s would %s, proposed rule %s would %s",
+				payload.eventType(), rule.name(), applied, proposed.name(), dryRun)
+		}
+	}
+	return rule
+}
+
+// name returns the name of the rule, none if nil
+func (rule *routingRule) name() string {
+	if rule == nil {
+		return noRule
+	}
+	return rule.Name
+}
+
+// outcome describes what the rule does with the payloads it matches, e.g. "publish to billing"
+func (rule *routingRule) outcome(defaultTopic string) string {
+	switch {
+	case rule == nil && defaultTopic != "":
+		return "publish to " + defaultTopic
+	case rule == nil:
+		return "publish to the destination"
+	case rule.Respond != nil:
+		return fmt.Sprintf("respond %d", rule.Respond.Status)
+	default:
+		return "publish to " + rule.Topic
+	}
+}
+
+// write answers the request with the response
+func (resp *ruleResponse) write(w http.ResponseWriter) {
+	var text string
+	switch {
+	case len(resp.Body) == 0:
+	case json.Unmarshal(resp.Body, &text) == nil:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		text = byteSliceToString(resp.Body)
+	}
+
+	w.WriteHeader(resp.Status)
+	io.WriteString(w, text)
+}
+
+// routeByRule resolves the destination of the payload from its rule, or the default route if it matched none.
+// Tenants with a topic of their own always publish to it.
+// The default route only replaces the default topic: payloads matching no rule keep the destination
+// picked by OPS_TOPIC, LINK_SHARED_TOPIC, APP_HOME_OPENED_TOPIC, CHANNEL_ROUTES or the topic template.
+// Returns false if the rule's error policy failed the request.
+func routeByRule(ctx context.Context, w http.ResponseWriter, e *Event) bool {
+	if t := tenantFromContext(ctx); t != nil && t.Topic != "" {
+		return true
+	}
+
+	live := liveConfigFrom(ctx)
+	if e.rule == nil {
+		if live.routingDefaultTopic != "" && e.topic == topic {
+			e.topic = resolveRouteTopic(ctx, live.routingDefaultTopic, "the default route", e.topic)
+		}
+		return true
+	}
+
+	rule := e.rule
+	t, err := namedTopic(ctx, rule.Topic)
+	if err == nil {
+		e.topic = t
+		return true
+	}
+
+	logWarning(ctx, "Failed resolving topic %s of rule %s, applying its %s policy: %s", rule.Topic, rule.Name, rule.OnError, err.Error())
+	switch rule.OnError {
+	case onErrorFallback:
+		fallback, what := rule.Fallback, "the fallback of rule "+rule.Name
+		if fallback == "" {
+			fallback, what = live.routingDefaultTopic, "the default route"
+		}
+		if fallback != "" {
+			e.topic = resolveRouteTopic(ctx, fallback, what, e.topic)
+		}
+		return true
+
+	case onErrorDeadLetter:
+		deadLetter, dlErr := namedTopic(ctx, live.routingDeadLetterTopic)
+		if dlErr == nil {
+			e.topic = deadLetter
+			e.Message.Attributes[attrRoutingRule] = rule.Name
+			e.Message.Attributes[attrRoutingError] = err.Error()
+			return true
+		}
+		logError(ctx, "Failed resolving dead letter topic %s: %s", live.routingDeadLetterTopic, dlErr.Error())
+	}
+
+	writeError(w, http.StatusInternalServerError, errorRouting)
+	return false
+}
+
+// resolveRouteTopic returns the named topic, or the destination if it can't be resolved
+func resolveRouteTopic(ctx context.Context, name, what string, destination pubsubTopic) pubsubTopic {
+	t, err := namedTopic(ctx, name)
+	if err != nil {
+		logWarning(ctx, "Failed resolving topic %s of %s, using the destination topic: %s", name, what, err.Error())
+		return destination
+	}
+	return t
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Message attribute holding the sample rate the event was forwarded at
+const attrSampleRate = "sample_rate"
+
+var sampledOutEvents = newCounterVec("slack_proxy_events_sampled_out_total",
+	"Valid events dropped by sampling, by event type.", "event_type")
+
+// setupSampling configures the sampling rules from the environment.
+// SAMPLE_RATES is a comma separated list of event_type=rate, e.g. message=0.01
+func setupSampling(live *liveConfig) {
+	rules := getenv("SAMPLE_RATES")
+	if rules == "" {
+		return
+	}
+
+	live.sampleRates = map[string]float64{}
+	for _, rule := range strings.Split(rules, ",") {
+		eventType, value, ok := strings.Cut(strings.TrimSpace(rule), "=")
+		rate, err := strconv.ParseFloat(value, 64)
+		if !ok || eventType == "" || err != nil || rate < 0 || rate > 1 {
+			configErrorf("Invalid SAMPLE_RATES rule: %s.", rule)
+			continue
+		}
+		live.sampleRates[eventType] = rate
+	}
+}
+
+// sample decides whether to forward the event.
+// Forwarded events of sampled types are marked with their sample rate,
+// so consumers can scale their counts back up.
+func (live *liveConfig) sample(payload *slackPayload, attributes map[string]string) bool {
+	rate, ok := live.sampleRates[payload.Event.Type]
+	if !ok {
+		return true
+	}
+
+	if rand.Float64() >= rate {
+		sampledOutEvents.Inc(payload.Event.Type)
+		return false
+	}
+
+	attributes[attrSampleRate] = strconv.FormatFloat(rate, 'g', -1, 64)
+	return true
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Schema definition types
+var schemaTypes = map[string]pubsub.SchemaType{
+	"avro":     pubsub.SchemaAvro,
+	"protobuf": pubsub.SchemaProtocolBuffer,
+}
+
+// setupSchema checks the published messages against a Pub/Sub schema, registering it if needed.
+// Drift is rejected at startup, rather than by the publishes of the payloads.
+func setupSchema() {
+	schemaID := getenv("PUBSUB_SCHEMA")
+	if schemaID == "" {
+		return
+	}
+	if backend != backendPubSub || topic == nil {
+		configErrorf("PUBSUB_SCHEMA is only supported by the pubsub backend.")
+		return
+	}
+
+	ctx := context.Background()
+	client, err := pubsub.NewSchemaClient(ctx, gcpProject, pubsubClientOptions()...)
+	if err != nil {
+		configErrorf("Failed creating a Pub/Sub schema client: %s.", err.Error())
+		return
+	}
+	defer client.Close()
+
+	if file := getenv("PUBSUB_SCHEMA_DEFINITION_FILE"); file != "" {
+		if !registerSchema(ctx, client, schemaID, file) {
+			return
+		}
+	} else if _, err := client.Schema(ctx, schemaID, pubsub.SchemaViewBasic); err != nil {
+		configErrorf("Failed getting schema %s: %s.", schemaID, err.Error())
+		return
+	}
+
+	// The payloads are published as JSON
+	name, jsonEncoded, err := topicSchema(ctx, topic)
+	if err != nil {
+		configErrorf("Failed getting the schema of topic %s: %s.", topic.ID(), err.Error())
+		return
+	}
+	if name != fmt.Sprintf("projects/%s/schemas/%s", gcpProject, schemaID) || !jsonEncoded {
+		configErrorf("Topic %s must be bound to schema %s with JSON encoding.", topic.ID(), schemaID)
+		return
+	}
+
+	if file := getenv("PUBSUB_SCHEMA_SAMPLE_FILE"); file != "" {
+		sample, err := os.ReadFile(file)
+		if err != nil {
+			configErrorf("Failed reading PUBSUB_SCHEMA_SAMPLE_FILE: %s.", err.Error())
+			return
+		}
+		if _, err := client.ValidateMessageWithID(ctx, sample, pubsub.EncodingJSON, schemaID); err != nil {
+			configErrorf("Sample payload doesn't conform to schema %s: %s.", schemaID, err.Error())
+			return
+		}
+	}
+
+	logInfo(ctx, "Published messages are validated against schema %s.", schemaID)
+}
+
+// registerSchema creates the schema from its definition if missing, returning false on configuration errors.
+// A registered schema that differs is an error, as revisions are committed deliberately.
+func registerSchema(ctx context.Context, client *pubsub.SchemaClient, schemaID, file string) bool {
+	definition, err := os.ReadFile(file)
+	if err != nil {
+		configErrorf("Failed reading PUBSUB_SCHEMA_DEFINITION_FILE: %s.", err.Error())
+		return false
+	}
+
+	schemaType := pubsub.SchemaAvro
+	if name := getenv("PUBSUB_SCHEMA_TYPE"); name != "" {
+		var ok bool
+		if schemaType, ok = schemaTypes[name]; !ok {
+			configErrorf("Unknown PUBSUB_SCHEMA_TYPE: %s.", name)
+			return false
+		}
+	}
+
+	config := pubsub.SchemaConfig{Type: schemaType, Definition: string(definition)}
+
+	registered, err := client.Schema(ctx, schemaID, pubsub.SchemaViewFull)
+	switch {
+	case status.Code(err) == codes.NotFound:
+		if _, err := client.ValidateSchema(ctx, config); err != nil {
+			configErrorf("Invalid schema definition: %s.", err.Error())
+			return false
+		}
+		if _, err := client.CreateSchema(ctx, schemaID, config); err != nil {
+			configErrorf("Failed creating schema %s: %s.", schemaID, err.Error())
+			return false
+		}
+		logInfo(ctx, "Registered schema %s.", schemaID)
+	case err != nil:
+		configErrorf("Failed getting schema %s: %s.", schemaID, err.Error())
+		return false
+	case registered.Type != schemaType ||
+		strings.TrimSpace(registered.Definition) != strings.TrimSpace(config.Definition):
+		configErrorf("Schema %s differs from PUBSUB_SCHEMA_DEFINITION_FILE. Commit a new revision first.", schemaID)
+		return false
+	}
+	return true
+}
+
+// topicSchema returns the name of the schema bound to the topic, and whether it is JSON-encoded
+func topicSchema(ctx context.Context, t pubsubTopic) (string, bool, error) {
+	if rt, ok := t.(*restTopic); ok {
+		pt, err := rt.client.GetTopic(ctx, &pubsubpb.GetTopicRequest{Topic: rt.name})
+		if err != nil || pt.SchemaSettings == nil {
+			return "", false, err
+		}
+		return pt.SchemaSettings.Schema, pt.SchemaSettings.Encoding == pubsubpb.Encoding_JSON, nil
+	}
+
+	config, err := t.(*grpcTopic).topic.Config(ctx)
+	if err != nil || config.SchemaSettings == nil {
+		return "", false, err
+	}
+	return config.SchemaSettings.Schema, config.SchemaSettings.Encoding == pubsub.EncodingJSON, nil
+}
@@ -0,0 +1,122 @@
+// Package slackfixture holds a golden corpus of Slack payloads, and generates signed HTTP requests out of them,
+// for tests of both the proxy and downstream services.
+package slackfixture
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of payloads, by the Slack surface sending them
+const (
+	// KindEvent is an Events API payload, sent as JSON
+	KindEvent = "events"
+
+	// KindCommand is a slash command, sent form-encoded
+	KindCommand = "commands"
+
+	// KindInteractivity is an interactivity payload, such as a block action or view submission,
+	// sent as JSON in the payload field of a form
+	KindInteractivity = "interactivity"
+)
+
+//go:embed golden
+var golden embed.FS
+
+// Fixture is a payload of the corpus
+type Fixture struct {
+	// Name of the fixture, such as "message" or "view_submission"
+	Name string
+
+	// Kind of the payload
+	Kind string
+
+	// ContentType of the request
+	ContentType string
+
+	// Body of the request, as Slack sends it
+	Body []byte
+}
+
+// All returns the fixtures of the corpus, sorted by name
+func All() []Fixture {
+	var all []Fixture
+	for _, kind := range []string{KindEvent, KindCommand, KindInteractivity} {
+		entries, _ := fs.ReadDir(golden, path.Join("golden", kind))
+		for _, entry := range entries {
+			data, _ := golden.ReadFile(path.Join("golden", kind, entry.Name()))
+			all = append(all, newFixture(kind, strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())), data))
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// Get returns a fixture by name
+func Get(name string) (Fixture, bool) {
+	for _, f := range All() {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Fixture{}, false
+}
+
+// newFixture encodes a payload of the corpus the way Slack sends it
+func newFixture(kind, name string, data []byte) Fixture {
+	data = bytes.TrimSpace(data)
+
+	switch kind {
+	case KindCommand:
+		return Fixture{Name: name, Kind: kind, ContentType: "application/x-www-form-urlencoded", Body: data}
+	case KindInteractivity:
+		body := url.Values{"payload": {string(data)}}.Encode()
+		return Fixture{Name: name, Kind: kind, ContentType: "application/x-www-form-urlencoded", Body: []byte(body)}
+	default:
+		return Fixture{Name: name, Kind: kind, ContentType: "application/json", Body: data}
+	}
+}
+
+// Request returns a request of the fixture, signed with the secret at the given time
+func (f Fixture) Request(target, secret string, at time.Time) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(f.Body))
+	r.Header.Set("Content-Type", f.ContentType)
+	Sign(r, f.Body, secret, at)
+	return r
+}
+
+// NewRequest returns a JSON request of the body, signed with the secret now
+func NewRequest(target, secret string, body []byte) *http.Request {
+	return Fixture{ContentType: "application/json", Body: body}.Request(target, secret, time.Now())
+}
+
+// NewFormRequest returns a form-encoded request of the fields, signed with the secret now
+func NewFormRequest(target, secret string, form url.Values) *http.Request {
+	f := Fixture{ContentType: "application/x-www-form-urlencoded", Body: []byte(form.Encode())}
+	return f.Request(target, secret, time.Now())
+}
+
+// Sign sets the Slack signature headers of a request, as signed with the secret at the given time
+func Sign(r *http.Request, body []byte, secret string, at time.Time) {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+}
@@ -0,0 +1,66 @@
+package slackfixture
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAll(t *testing.T) {
+	all := All()
+	if len(all) == 0 {
+		t.Fatal("empty corpus")
+	}
+
+	for i, f := range all {
+		if i > 0 && all[i-1].Name >= f.Name {
+			t.Errorf("fixtures not sorted by name: %s before %s", all[i-1].Name, f.Name)
+		}
+		if len(f.Body) == 0 || f.ContentType == "" {
+			t.Errorf("fixture %s is empty", f.Name)
+		}
+		if f.Kind == KindInteractivity {
+			if form, err := url.ParseQuery(string(f.Body)); err != nil || form.Get("payload") == "" {
+				t.Errorf("interactivity fixture %s has no payload field", f.Name)
+			}
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	if f, ok := Get("message"); !ok || f.Kind != KindEvent {
+		t.Errorf("Get(message) = %+v, %v", f, ok)
+	}
+	if _, ok := Get("unknown"); ok {
+		t.Error("Get(unknown) found a fixture")
+	}
+}
+
+func TestRequestIsSigned(t *testing.T) {
+	f, _ := Get("message")
+	at := time.Unix(1700000000, 0)
+	r := f.Request("/", "secret", at)
+
+	body, _ := io.ReadAll(r.Body)
+	if !bytes.Equal(body, f.Body) {
+		t.Errorf("request body differs from the fixture's")
+	}
+	if r.Header.Get("Content-Type") != f.ContentType {
+		t.Errorf("Content-Type = %q, want %q", r.Header.Get("Content-Type"), f.ContentType)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	fmt.Fprintf(mac, "v0:%d:%s", at.Unix(), f.Body)
+	if want := "v0=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-Slack-Signature") != want {
+		t.Errorf("X-Slack-Signature = %q, want %q", r.Header.Get("X-Slack-Signature"), want)
+	}
+	if r.Header.Get("X-Slack-Request-Timestamp") != "1700000000" {
+		t.Errorf("X-Slack-Request-Timestamp = %q", r.Header.Get("X-Slack-Request-Timestamp"))
+	}
+}
@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Socket Mode envelope types
+const (
+	envelopeHello         = "hello"
+	envelopeDisconnect    = "disconnect"
+	envelopeInteractive   = "interactive"
+	envelopeSlashCommands = "slash_commands"
+)
+
+const socketModeMaxBackoff = 30 * time.Second
+
+// App-level token with the connections:write scope, empty if Socket Mode is disabled
+var slackAppToken string
+
+// socketModeEnvelope wraps the payloads received over Socket Mode
+type socketModeEnvelope struct {
+	EnvelopeID             string          `json:"envelope_id"`
+	Type                   string          `json:"type"`
+	Payload                json.RawMessage `json:"payload"`
+	AcceptsResponsePayload bool            `json:"accepts_response_payload"`
+
+	// Set on disconnect envelopes
+	Reason string `json:"reason"`
+}
+
+// socketModeAck acknowledges an envelope, optionally responding to it
+type socketModeAck struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Payload    json.RawMessage `json:"payload,omitempty"`
+}
+
+type socketModeContextKey struct{}
+
+// setupSocketMode configures the Socket Mode bridge from the environment
+func setupSocketMode() {
+	slackAppToken = getenv("SLACK_APP_TOKEN")
+	if slackAppToken != "" && !strings.HasPrefix(slackAppToken, "xapp-") {
+		configErrorf("SLACK_APP_TOKEN must be an app-level token (xapp-...).")
+	}
+}
+
+// isSocketModeRequest returns true if the request wraps a payload received over Socket Mode
+func isSocketModeRequest(r *http.Request) bool {
+	_, ok := r.Context().Value(socketModeContextKey{}).(string)
+	return ok
+}
+
+// socketModePayloadFormat returns the payload format of a request received over Socket Mode
+func socketModePayloadFormat(r *http.Request) (string, bool) {
+	format, ok := r.Context().Value(socketModeContextKey{}).(string)
+	return format, ok
+}
+
+// ServeSocketMode connects to Slack using Socket Mode, for environments without a public endpoint.
+// The payloads of the received envelopes run through the pipeline like signed requests,
+// and are acknowledged once forwarded. Reconnects until the context is done.
+func ServeSocketMode(ctx context.Context) error {
+	Setup()
+
+	if slackAppToken == "" {
+		return errors.New("SLACK_APP_TOKEN env var must be set")
+	}
+
+	backoff := time.Second
+	for {
+		connected, err := runSocketMode(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Slack asked to reconnect
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+
+		if connected {
+			backoff = time.Second
+		}
+		logWarning(ctx, "Socket Mode connection failed: %s. Reconnecting in %s.", err.Error(), backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > socketModeMaxBackoff {
+			backoff = socketModeMaxBackoff
+		}
+	}
+}
+
+// runSocketMode handles the envelopes of a single connection until it closes.
+// Returns whether the connection was established, and a nil error if Slack asked to reconnect.
+func runSocketMode(ctx context.Context) (bool, error) {
+	data, err := callSlackAPI(ctx, slackAppToken, "apps.connections.open", url.Values{})
+	if err != nil {
+		return false, fmt.Errorf("failed opening connection: %w", err)
+	}
+
+	var result struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return false, fmt.Errorf("failed decoding connection: %w", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, result.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Unblock the reads once done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// Let in-flight envelopes be acknowledged before closing
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+
+	var writeMu sync.Mutex
+	connected := false
+
+	for {
+		var envelope socketModeEnvelope
+		if err := conn.ReadJSON(&envelope); err != nil {
+			return connected, err
+		}
+
+		switch envelope.Type {
+		case envelopeHello:
+			connected = true
+			logInfo(ctx, "Socket Mode connected.")
+		case envelopeDisconnect:
+			logInfo(ctx, "Socket Mode disconnect requested (%s).", envelope.Reason)
+			return connected, nil
+		default:
+			if envelope.EnvelopeID == "" {
+				continue
+			}
+
+			handlers.Add(1)
+			go func(envelope socketModeEnvelope) {
+				defer handlers.Done()
+
+				ack := handleEnvelope(ctx, envelope)
+				if ack == nil {
+					return
+				}
+
+				writeMu.Lock()
+				err := conn.WriteJSON(ack)
+				writeMu.Unlock()
+				if err != nil {
+					logError(ctx, "Failed acknowledging envelope %s: %s", envelope.EnvelopeID, err.Error())
+				}
+			}(envelope)
+		}
+	}
+}
+
+// handleEnvelope runs the payload through the pipeline.
+// Returns nil if it wasn't forwarded, leaving the envelope unacknowledged so Slack retries it.
+func handleEnvelope(ctx context.Context, envelope socketModeEnvelope) *socketModeAck {
+	format := ""
+	switch envelope.Type {
+	case envelopeInteractive:
+		format = formatInteractivity
+	case envelopeSlashCommands:
+		format = formatCommand
+	}
+
+	r, err := http.NewRequestWithContext(context.WithValue(ctx, socketModeContextKey{}, format),
+		http.MethodPost, "/", bytes.NewReader(envelope.Payload))
+	if err != nil {
+		logError(ctx, "Failed handling envelope %s: %s", envelope.EnvelopeID, err.Error())
+		return nil
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	w := &envelopeResponse{header: http.Header{}}
+	withAccessLog(withRecovery("socketmode", withRequestTimeout("socketmode", runPipeline)))(w, withTrace(withBudget(r, withBudgetTotal(publishBudget))))
+
+	if w.status < 200 || w.status >= 300 {
+		logWarning(ctx, "Envelope %s was not forwarded. Returned status: %d", envelope.EnvelopeID, w.status)
+		return nil
+	}
+
+	ack := &socketModeAck{EnvelopeID: envelope.EnvelopeID}
+	if envelope.AcceptsResponsePayload && json.Valid(w.body.Bytes()) {
+		ack.Payload = w.body.Bytes()
+	}
+	return ack
+}
+
+// envelopeResponse records the response of the pipeline to an envelope
+type envelopeResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *envelopeResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *envelopeResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *envelopeResponse) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
@@ -0,0 +1,387 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store backends
+const (
+	storeMemory    = "memory"
+	storeFirestore = "firestore"
+	storeRedis     = "redis"
+)
+
+// Default Firestore collection holding the keys
+const defaultStoreCollection = "slack-proxy-cache"
+
+const (
+	// Keys held in memory before expired entries are swept, every memorySweepInterval new keys
+	memorySweepSize     = 10000
+	memorySweepInterval = 1000
+
+	// Keys held in memory at most, evicting arbitrary ones past it
+	memoryMaxEntries = 100000
+)
+
+// Store is a key-value store with expiring keys, backing the dedup window, usage metering and caches
+type Store interface {
+	// Get returns the value of the key, and whether it was found
+	Get(ctx context.Context, key string) (string, bool, error)
+
+	// Set sets the key, expiring it after ttl
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+
+	// SetNX sets the key, expiring it after ttl, unless it's already set.
+	// Returns whether it was set.
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+
+	// Incr increments the key, expiring it after ttl if new, and returns its new value
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+
+	// Delete removes the key
+	Delete(ctx context.Context, key string) error
+}
+
+// Custom store, nil if unset
+var customStore Store
+
+// SetStore replaces the stores configured by the environment with the store, e.g. to use another database.
+// Must be called before Setup.
+func SetStore(s Store) {
+	customStore = s
+}
+
+// newStoreFromEnv creates the store selected by the <prefix> env var.
+// Shared stores let all function instances see the same keys.
+func newStoreFromEnv(prefix string) Store {
+	if customStore != nil {
+		return customStore
+	}
+
+	switch backend := getenv(prefix); backend {
+	case "", storeMemory:
+		return newMemoryStore()
+	case storeFirestore:
+		return &firestoreStore{collection: firestoreCollection(prefix)}
+	case storeRedis:
+		return &redisStore{client: redisClient(prefix)}
+	default:
+		configErrorf("Unknown %s backend: %s.", prefix, backend)
+		return newMemoryStore()
+	}
+}
+
+// newCounterStoreFromEnv creates the store selected by the <prefix> env var, for counters incremented on each request.
+// Firestore isn't supported, as a single document sustains about one write per second.
+func newCounterStoreFromEnv(prefix string) Store {
+	if customStore == nil && getenv(prefix) == storeFirestore {
+		configErrorf("%s can't be firestore, as its counters are written on each request. Use memory or redis.", prefix)
+		return newMemoryStore()
+	}
+	return newStoreFromEnv(prefix)
+}
+
+// memoryStore is a concurrency-safe in-process store.
+// Each instance only sees its own keys.
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+
+	// New keys set since the last sweep
+	added int
+}
+
+type memoryEntry struct {
+	value   string
+	expires time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{entries: map[string]memoryEntry{}}
+}
+
+// get returns the unexpired entry of the key. Must be called with the lock held.
+func (s *memoryStore) get(key string, now time.Time) (memoryEntry, bool) {
+	entry, ok := s.entries[key]
+	if ok && now.After(entry.expires) {
+		delete(s.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, ok
+}
+
+// set sets the entry of the key. Must be called with the lock held.
+func (s *memoryStore) set(key string, entry memoryEntry, now time.Time) {
+	if _, ok := s.entries[key]; !ok {
+		s.added++
+
+		// Sweep expired entries once the store grows, every so many new keys so unique keys can't accumulate
+		if len(s.entries) >= memorySweepSize && s.added >= memorySweepInterval {
+			s.added = 0
+			for k, e := range s.entries {
+				if now.After(e.expires) {
+					delete(s.entries, k)
+				}
+			}
+		}
+
+		// Past the maximum, unexpired keys are evicted too, as if they expired
+		for k := range s.entries {
+			if len(s.entries) < memoryMaxEntries {
+				break
+			}
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = entry
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.get(key, clock.Now())
+	return entry.value, ok, nil
+}
+
+func (s *memoryStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := clock.Now()
+	s.set(key, memoryEntry{value: value, expires: now.Add(ttl)}, now)
+	return nil
+}
+
+func (s *memoryStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := clock.Now()
+	if _, ok := s.get(key, now); ok {
+		return false, nil
+	}
+	s.set(key, memoryEntry{value: value, expires: now.Add(ttl)}, now)
+	return true, nil
+}
+
+func (s *memoryStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := clock.Now()
+	entry, ok := s.get(key, now)
+	if !ok {
+		entry.expires = now.Add(ttl)
+	}
+
+	count, _ := strconv.ParseInt(entry.value, 10, 64)
+	count++
+	entry.value = strconv.FormatInt(count, 10)
+	s.set(key, entry, now)
+	return count, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+var (
+	sharedFirestoreClient     *firestore.Client
+	sharedFirestoreClientOnce sync.Once
+)
+
+// firestoreClient returns a Firestore client for the GCP project, shared by all users
+func firestoreClient() *firestore.Client {
+	sharedFirestoreClientOnce.Do(func() {
+		var err error
+		sharedFirestoreClient, err = firestore.NewClient(context.Background(), getenv("GCP_PROJECT"))
+		if err != nil {
+			log.Panicf("Failed creating a Firestore client: %s.", err.Error())
+		}
+	})
+	return sharedFirestoreClient
+}
+
+// firestoreCollection returns the collection at <prefix>_COLLECTION, defaulting to slack-proxy-cache
+func firestoreCollection(prefix string) *firestore.CollectionRef {
+	collection := getenv(prefix + "_COLLECTION")
+	if collection == "" {
+		collection = defaultStoreCollection
+	}
+	return firestoreClient().Collection(collection)
+}
+
+// firestoreEntry is the document stored per key.
+// A Firestore TTL policy on the expires field deletes stale documents.
+type firestoreEntry struct {
+	Value   string    `firestore:"value"`
+	Expires time.Time `firestore:"expires"`
+}
+
+// firestoreStore stores keys as documents, keyed by the escaped key.
+// Each key is a single document, sustaining roughly one write per second.
+type firestoreStore struct {
+	collection *firestore.CollectionRef
+}
+
+// firestoreKey escapes a key into a valid document id
+func firestoreKey(key string) string {
+	return url.PathEscape(key)
+}
+
+// getFirestoreEntry returns the unexpired entry of the document
+func getFirestoreEntry(doc *firestore.DocumentSnapshot, err error) (firestoreEntry, bool, error) {
+	var entry firestoreEntry
+	if status.Code(err) == codes.NotFound {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+	if err := doc.DataTo(&entry); err != nil || clock.Now().After(entry.Expires) {
+		return firestoreEntry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+func (s *firestoreStore) Get(ctx context.Context, key string) (string, bool, error) {
+	entry, ok, err := getFirestoreEntry(s.collection.Doc(firestoreKey(key)).Get(ctx))
+	return entry.Value, ok, err
+}
+
+func (s *firestoreStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	entry := firestoreEntry{Value: value, Expires: clock.Now().Add(ttl)}
+	_, err := s.collection.Doc(firestoreKey(key)).Set(ctx, entry)
+	return err
+}
+
+func (s *firestoreStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	ref := s.collection.Doc(firestoreKey(key))
+
+	var set bool
+	err := firestoreClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		_, ok, err := getFirestoreEntry(tx.Get(ref))
+		if err != nil || ok {
+			set = false
+			return err
+		}
+
+		set = true
+		return tx.Set(ref, firestoreEntry{Value: value, Expires: clock.Now().Add(ttl)})
+	})
+	return set, err
+}
+
+func (s *firestoreStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	ref := s.collection.Doc(firestoreKey(key))
+
+	var count int64
+	err := firestoreClient().RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		entry, ok, err := getFirestoreEntry(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			entry.Expires = clock.Now().Add(ttl)
+		}
+
+		count, _ = strconv.ParseInt(entry.Value, 10, 64)
+		count++
+		entry.Value = strconv.FormatInt(count, 10)
+		return tx.Set(ref, entry)
+	})
+	return count, err
+}
+
+func (s *firestoreStore) Delete(ctx context.Context, key string) error {
+	_, err := s.collection.Doc(firestoreKey(key)).Delete(ctx)
+	return err
+}
+
+// Clients by URL, shared by the stores that use the same server.
+// Setup runs again on reload, so the map is guarded.
+var (
+	redisClientsMu sync.Mutex
+	redisClients   = map[string]*redis.Client{}
+)
+
+// redisClient returns a client for the server at <prefix>_REDIS_URL,
+// e.g. redis://:password@10.0.0.3:6379/0
+func redisClient(prefix string) *redis.Client {
+	redisURL := getenv(prefix + "_REDIS_URL")
+	if redisURL == "" {
+		configErrorf("%s_REDIS_URL env var must be set.", prefix)
+	}
+
+	redisClientsMu.Lock()
+	defer redisClientsMu.Unlock()
+
+	if client, ok := redisClients[redisURL]; ok {
+		return client
+	}
+
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		configErrorf("Invalid %s_REDIS_URL: %s.", prefix, err.Error())
+		options = &redis.Options{}
+	}
+
+	redisClients[redisURL] = redis.NewClient(options)
+	return redisClients[redisURL]
+}
+
+// Prefix of all keys stored in Redis
+const redisKeyPrefix = "slack-proxy:"
+
+// Increments the key, setting its TTL in milliseconds if new
+var redisIncrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// redisStore stores keys as expiring Redis (or Memorystore) keys
+type redisStore struct {
+	client *redis.Client
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := s.client.Get(ctx, redisKeyPrefix+key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s *redisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return s.client.Set(ctx, redisKeyPrefix+key, value, ttl).Err()
+}
+
+func (s *redisStore) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, redisKeyPrefix+key, value, ttl).Result()
+}
+
+func (s *redisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return redisIncrScript.Run(ctx, s.client, []string{redisKeyPrefix + key}, ttl.Milliseconds()).Int64()
+}
+
+func (s *redisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, redisKeyPrefix+key).Err()
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreSweepsExpiredKeys(t *testing.T) {
+	c := withFakeClock(t)
+	ctx := context.Background()
+	s := newMemoryStore()
+
+	for i := 0; i < memorySweepSize; i++ {
+		s.Set(ctx, strconv.Itoa(i), "1", time.Second)
+	}
+	c.Advance(time.Minute)
+
+	// Expired keys are swept as new keys are written
+	for i := 0; i < memorySweepInterval; i++ {
+		s.Set(ctx, "new:"+strconv.Itoa(i), "1", time.Minute)
+	}
+	if n := len(s.entries); n > memorySweepInterval {
+		t.Errorf("holding %d keys, want at most %d", n, memorySweepInterval)
+	}
+}
+
+func TestMemoryStoreCapsKeys(t *testing.T) {
+	withFakeClock(t)
+	ctx := context.Background()
+	s := newMemoryStore()
+
+	for i := 0; i < memoryMaxEntries+100; i++ {
+		s.Set(ctx, strconv.Itoa(i), "1", time.Hour)
+	}
+	if n := len(s.entries); n > memoryMaxEntries {
+		t.Errorf("holding %d keys, want at most %d", n, memoryMaxEntries)
+	}
+
+	// Keys set last are kept
+	if _, ok, _ := s.Get(ctx, strconv.Itoa(memoryMaxEntries+99)); !ok {
+		t.Errorf("the last key set was evicted")
+	}
+}
+
+func TestMemoryStoreSetNXAndIncr(t *testing.T) {
+	c := withFakeClock(t)
+	ctx := context.Background()
+	s := newMemoryStore()
+
+	if ok, _ := s.SetNX(ctx, "k", "1", time.Second); !ok {
+		t.Errorf("SetNX() of a new key = false")
+	}
+	if ok, _ := s.SetNX(ctx, "k", "2", time.Second); ok {
+		t.Errorf("SetNX() of an existing key = true")
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		if n, _ := s.Incr(ctx, "n", time.Second); n != want {
+			t.Errorf("Incr() = %d, want %d", n, want)
+		}
+	}
+
+	c.Advance(2 * time.Second)
+	if ok, _ := s.SetNX(ctx, "k", "3", time.Second); !ok {
+		t.Errorf("SetNX() of an expired key = false")
+	}
+	if n, _ := s.Incr(ctx, "n", time.Second); n != 1 {
+		t.Errorf("Incr() of an expired key = %d, want 1", n)
+	}
+}
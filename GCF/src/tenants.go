@@ -0,0 +1,380 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Tenant registry backends
+const (
+	tenantRegistryFirestore = "firestore"
+	tenantRegistryDynamoDB  = "dynamodb"
+)
+
+const (
+	defaultTenantCollection = "slack-proxy-tenants"
+	defaultTenantTable      = "slack-proxy-tenants"
+	defaultTenantCacheTTL   = 5 * time.Minute
+
+	// Unknown teams cached at most, the oldest being evicted first
+	maxUnknownTenants = 1000
+
+	// Known teams cached before expired entries are swept
+	tenantCacheSweepSize = 10000
+
+	// Time allowed to a registry lookup, shared by the requests waiting for it
+	tenantLookupTimeout = time.Second
+
+	// Time a failed lookup is answered from the cache, sparing a failing registry the retries
+	tenantFailureTTL = 5 * time.Second
+)
+
+const reasonBadTeamID = "bad_team_id"
+
+// Team ids of workspaces (T) and Enterprise Grid organizations (E)
+var teamIDPattern = regexp.MustCompile(`^[TE][A-Z0-9]+$`)
+
+// errBadTeamID is returned by resolveTenant for requests whose team id isn't one
+var errBadTeamID = errors.New("bad team id")
+
+// tenant is the configuration of a single Slack workspace
+type tenant struct {
+	TeamID        string          `firestore:"-"`
+	SigningSecret string          `firestore:"signing_secret"`
+	BotToken      string          `firestore:"bot_token"`
+	Topic         string          `firestore:"topic"`
+	Flags         map[string]bool `firestore:"flags"`
+	DailyQuota    int64           `firestore:"daily_quota"`
+}
+
+// flag returns the value of a tenant feature flag, or the fallback if it isn't set
+func (t *tenant) flag(name string, fallback bool) bool {
+	if t == nil {
+		return fallback
+	}
+	if value, ok := t.Flags[name]; ok {
+		return value
+	}
+	return fallback
+}
+
+// tenantRegistry stores tenants by team id
+type tenantRegistry interface {
+	// Lookup returns the tenant, or nil if the team is unknown
+	Lookup(ctx context.Context, teamID string) (*tenant, error)
+
+	// Install creates or updates the tenant installing the app.
+	// Only the bot token and topic are updated, keeping any other settings.
+	Install(ctx context.Context, t *tenant) error
+}
+
+// setupTenantRegistry configures the tenant registry from the environment
+func setupTenantRegistry(live *liveConfig) {
+	backend := getenv("TENANT_REGISTRY")
+	if backend == "" {
+		return
+	}
+
+	ttl := configDuration("TENANT_CACHE_TTL", defaultTenantCacheTTL, false)
+
+	switch backend {
+	case tenantRegistryFirestore:
+		collection := getenv("TENANT_COLLECTION")
+		if collection == "" {
+			collection = defaultTenantCollection
+		}
+		live.tenants = newCachedTenantRegistry(&firestoreTenantRegistry{
+			collection: firestoreClient().Collection(collection),
+		}, ttl)
+	case tenantRegistryDynamoDB:
+		creds, ok := awsCredentialsFromEnv()
+		if !ok {
+			return
+		}
+		table := getenv("TENANT_TABLE")
+		if table == "" {
+			table = defaultTenantTable
+		}
+		live.tenants = newCachedTenantRegistry(newDynamoDBTenantRegistry(table, dynamoDBEndpoint(), creds), ttl)
+	default:
+		configErrorf("Unknown TENANT_REGISTRY backend: %s.", backend)
+	}
+}
+
+type tenantContextKey struct{}
+
+// withTenant attaches the tenant of the request to the context
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// tenantFromContext returns the tenant of the request, nil if unknown
+func tenantFromContext(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantContextKey{}).(*tenant)
+	return t
+}
+
+// resolveTenant looks up the tenant of a request, by the team id in its JSON or form-encoded body.
+// The body isn't verified yet at this point, so the team id is only used to pick the signing secret,
+// and is checked to be well-formed before reaching the registry: errBadTeamID is returned otherwise.
+// Reads the body but restores it before returning.
+func (live *liveConfig) resolveTenant(r *http.Request) (*tenant, error) {
+	// Leave invalid requests to the validation
+	if r.Body == nil || r.ContentLength <= 0 || r.ContentLength > maxBodySize {
+		return nil, nil
+	}
+
+	body := make([]byte, r.ContentLength)
+	if _, err := io.ReadFull(r.Body, body); err != nil {
+		return nil, nil
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	teamID := requestTeamID(r, body)
+	if teamID == "" {
+		return nil, nil
+	}
+	if !teamIDPattern.MatchString(teamID) {
+		return nil, errBadTeamID
+	}
+
+	return live.tenants.Lookup(r.Context(), teamID)
+}
+
+// requestTeamID returns the team id of a body, empty if not found: the team_id field of Events API payloads
+// and slash commands, or the team of the interactivity payloads, which are JSON in the payload form field
+func requestTeamID(r *http.Request, body []byte) string {
+	if isFormRequest(r) {
+		form, err := url.ParseQuery(byteSliceToString(body))
+		if err != nil {
+			return ""
+		}
+		if teamID := form.Get("team_id"); teamID != "" {
+			return teamID
+		}
+		body = []byte(form.Get("payload"))
+	}
+
+	var ids struct {
+		TeamID string          `json:"team_id"`
+		Team   json.RawMessage `json:"team"`
+	}
+	if json.Unmarshal(body, &ids) != nil {
+		return ""
+	}
+	if ids.TeamID != "" {
+		return ids.TeamID
+	}
+
+	var team struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(ids.Team, &team)
+	return team.ID
+}
+
+// signingSecretFor returns the secret verifying the requests of the tenant.
+// Unknown tenants use the default secret, which may be empty.
+func signingSecretFor(t *tenant) []byte {
+	if t != nil && t.SigningSecret != "" {
+		return []byte(t.SigningSecret)
+	}
+	return slackSigningSecret
+}
+
+// firestoreTenantRegistry keeps a document per tenant, keyed by team id
+type firestoreTenantRegistry struct {
+	collection *firestore.CollectionRef
+}
+
+func (reg *firestoreTenantRegistry) Lookup(ctx context.Context, teamID string) (*tenant, error) {
+	doc, err := reg.collection.Doc(teamID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var t tenant
+	if err := doc.DataTo(&t); err != nil {
+		return nil, err
+	}
+	t.TeamID = teamID
+	return &t, nil
+}
+
+func (reg *firestoreTenantRegistry) Install(ctx context.Context, t *tenant) error {
+	fields := map[string]any{"bot_token": t.BotToken}
+	if t.Topic != "" {
+		fields["topic"] = t.Topic
+	}
+	_, err := reg.collection.Doc(t.TeamID).Set(ctx, fields, firestore.MergeAll)
+	return err
+}
+
+// cachedTenantRegistry caches the lookups of another registry in-process.
+// Unknown teams are cached as well, up to maxUnknownTenants, so retried made-up team ids don't reach the registry,
+// and failed lookups for tenantFailureTTL, up to as many.
+// Concurrent lookups of a team share a single registry read.
+type cachedTenantRegistry struct {
+	registry tenantRegistry
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	entries  map[string]cachedTenant
+	unknown  map[string]time.Time
+	failures map[string]cachedFailure
+
+	lookups singleflight.Group
+}
+
+type cachedTenant struct {
+	tenant  *tenant
+	expires time.Time
+}
+
+type cachedFailure struct {
+	err     error
+	expires time.Time
+}
+
+func newCachedTenantRegistry(registry tenantRegistry, ttl time.Duration) *cachedTenantRegistry {
+	return &cachedTenantRegistry{
+		registry: registry,
+		ttl:      ttl,
+		entries:  map[string]cachedTenant{},
+		unknown:  map[string]time.Time{},
+		failures: map[string]cachedFailure{},
+	}
+}
+
+func (reg *cachedTenantRegistry) Lookup(ctx context.Context, teamID string) (*tenant, error) {
+	if t, ok, err := reg.cached(teamID); ok {
+		return t, err
+	}
+
+	// Detached from the request, as the lookup is shared by the requests of the team arriving meanwhile
+	lookup := reg.lookups.DoChan(teamID, func() (any, error) {
+		lookupCtx, cancel := context.WithTimeout(detachedContext{ctx}, tenantLookupTimeout)
+		defer cancel()
+
+		t, err := reg.registry.Lookup(lookupCtx, teamID)
+		if err != nil {
+			reg.storeFailure(teamID, err)
+			return nil, err
+		}
+		reg.store(teamID, t)
+		return t, nil
+	})
+
+	select {
+	case result := <-lookup:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*tenant), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// cached returns the cached tenant, nil if cached as unknown, or the cached failure, and whether any was cached
+func (reg *cachedTenantRegistry) cached(teamID string) (*tenant, bool, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	now := clock.Now()
+	if entry, ok := reg.entries[teamID]; ok && now.Before(entry.expires) {
+		return entry.tenant, true, nil
+	}
+	if expires, ok := reg.unknown[teamID]; ok && now.Before(expires) {
+		return nil, true, nil
+	}
+	if failure, ok := reg.failures[teamID]; ok && now.Before(failure.expires) {
+		return nil, true, failure.err
+	}
+	return nil, false, nil
+}
+
+// storeFailure caches a failed lookup of the team for tenantFailureTTL
+func (reg *cachedTenantRegistry) storeFailure(teamID string, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	now := clock.Now()
+	if len(reg.failures) >= maxUnknownTenants {
+		for k, failure := range reg.failures {
+			if now.After(failure.expires) {
+				delete(reg.failures, k)
+			}
+		}
+		// Still full: the registry is failing for every team, which the cached ones already spare it
+		if len(reg.failures) >= maxUnknownTenants {
+			return
+		}
+	}
+	reg.failures[teamID] = cachedFailure{err: err, expires: now.Add(tenantFailureTTL)}
+}
+
+// store caches the looked up tenant, or the team as unknown if nil
+func (reg *cachedTenantRegistry) store(teamID string, t *tenant) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	now := clock.Now()
+	delete(reg.failures, teamID)
+	if t != nil {
+		// Sweep expired entries once the cache grows
+		if len(reg.entries) >= tenantCacheSweepSize {
+			for k, entry := range reg.entries {
+				if now.After(entry.expires) {
+					delete(reg.entries, k)
+				}
+			}
+		}
+		delete(reg.unknown, teamID)
+		reg.entries[teamID] = cachedTenant{tenant: t, expires: now.Add(reg.ttl)}
+		return
+	}
+
+	// Evict the oldest unknown team once full, as made-up team ids are unbounded
+	if len(reg.unknown) >= maxUnknownTenants {
+		oldest, oldestExpires := "", time.Time{}
+		for k, expires := range reg.unknown {
+			if oldest == "" || expires.Before(oldestExpires) {
+				oldest, oldestExpires = k, expires
+			}
+		}
+		delete(reg.unknown, oldest)
+	}
+	delete(reg.entries, teamID)
+	reg.unknown[teamID] = now.Add(reg.ttl)
+}
+
+func (reg *cachedTenantRegistry) Install(ctx context.Context, t *tenant) error {
+	if err := reg.registry.Install(ctx, t); err != nil {
+		return err
+	}
+
+	reg.mu.Lock()
+	delete(reg.entries, t.TeamID)
+	delete(reg.unknown, t.TeamID)
+	delete(reg.failures, t.TeamID)
+	reg.mu.Unlock()
+	return nil
+}
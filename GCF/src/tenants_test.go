@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock advanced by the tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func withFakeClock(t *testing.T) *fakeClock {
+	t.Helper()
+	prev := clock
+	c := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	clock = c
+	t.Cleanup(func() { clock = prev })
+	return c
+}
+
+// fakeTenantRegistry counts its lookups, which wait for release if set
+type fakeTenantRegistry struct {
+	tenants map[string]*tenant
+	err     error
+	release chan struct{}
+
+	lookups atomic.Int32
+	ctxErr  atomic.Value
+}
+
+func (reg *fakeTenantRegistry) Lookup(ctx context.Context, teamID string) (*tenant, error) {
+	reg.lookups.Add(1)
+	if reg.release != nil {
+		<-reg.release
+	}
+	if err := ctx.Err(); err != nil {
+		reg.ctxErr.Store(err)
+	}
+	if reg.err != nil {
+		return nil, reg.err
+	}
+	return reg.tenants[teamID], nil
+}
+
+func (reg *fakeTenantRegistry) Install(ctx context.Context, t *tenant) error {
+	return nil
+}
+
+func TestCachedTenantRegistrySharesLookups(t *testing.T) {
+	withFakeClock(t)
+	registry := &fakeTenantRegistry{
+		tenants: map[string]*tenant{"T1": {TeamID: "T1"}},
+		release: make(chan struct{}),
+	}
+	reg := newCachedTenantRegistry(registry, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := reg.Lookup(context.Background(), "T1"); err != nil || got == nil || got.TeamID != "T1" {
+				t.Errorf("Lookup() = %v, %v", got, err)
+			}
+		}()
+	}
+	for registry.lookups.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(registry.release)
+	wg.Wait()
+
+	if n := registry.lookups.Load(); n != 1 {
+		t.Errorf("registry read %d times, want 1", n)
+	}
+}
+
+func TestCachedTenantRegistryCachesUnknownTeams(t *testing.T) {
+	c := withFakeClock(t)
+	registry := &fakeTenantRegistry{}
+	reg := newCachedTenantRegistry(registry, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if got, err := reg.Lookup(context.Background(), "T404"); got != nil || err != nil {
+			t.Fatalf("Lookup() = %v, %v, want unknown", got, err)
+		}
+	}
+	if n := registry.lookups.Load(); n != 1 {
+		t.Errorf("registry read %d times, want 1", n)
+	}
+
+	c.Advance(2 * time.Minute)
+	reg.Lookup(context.Background(), "T404")
+	if n := registry.lookups.Load(); n != 2 {
+		t.Errorf("registry read %d times once expired, want 2", n)
+	}
+}
+
+func TestCachedTenantRegistryCachesFailures(t *testing.T) {
+	c := withFakeClock(t)
+	failure := errors.New("unavailable")
+	registry := &fakeTenantRegistry{err: failure}
+	reg := newCachedTenantRegistry(registry, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := reg.Lookup(context.Background(), "T1"); !errors.Is(err, failure) {
+			t.Fatalf("Lookup() = %v, want %v", err, failure)
+		}
+	}
+	if n := registry.lookups.Load(); n != 1 {
+		t.Errorf("registry read %d times, want 1", n)
+	}
+
+	// Retried once the failure expires, and not cached as unknown meanwhile
+	c.Advance(tenantFailureTTL + time.Second)
+	registry.err = nil
+	registry.tenants = map[string]*tenant{"T1": {TeamID: "T1"}}
+	if got, err := reg.Lookup(context.Background(), "T1"); err != nil || got == nil {
+		t.Errorf("Lookup() after the failure expired = %v, %v", got, err)
+	}
+}
+
+func TestCachedTenantRegistryDetachesLookup(t *testing.T) {
+	withFakeClock(t)
+	registry := &fakeTenantRegistry{
+		tenants: map[string]*tenant{"T1": {TeamID: "T1"}},
+		release: make(chan struct{}),
+	}
+	reg := newCachedTenantRegistry(registry, time.Minute)
+
+	// The first request gives up, while the lookup carries on for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := reg.Lookup(ctx, "T1")
+		done <- err
+	}()
+	for registry.lookups.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Lookup() of the canceled request = %v, want %v", err, context.Canceled)
+	}
+	close(registry.release)
+
+	got, err := reg.Lookup(context.Background(), "T1")
+	if err != nil || got == nil {
+		t.Errorf("Lookup() = %v, %v", got, err)
+	}
+	if err, _ := registry.ctxErr.Load().(error); err != nil {
+		t.Errorf("the shared lookup ended with its first request: %v", err)
+	}
+	if n := registry.lookups.Load(); n != 1 {
+		t.Errorf("registry read %d times, want 1", n)
+	}
+}
+
+func TestResolveTenantChecksTeamID(t *testing.T) {
+	withFakeClock(t)
+	registry := &fakeTenantRegistry{tenants: map[string]*tenant{"T0123ABC": {TeamID: "T0123ABC"}}}
+	live := &liveConfig{tenants: newCachedTenantRegistry(registry, time.Minute)}
+
+	tests := []struct {
+		body    string
+		wantErr error
+		lookups int32
+	}{
+		{body: `{"team_id":"T0123ABC"}`, lookups: 1},
+		{body: `{"team":{"id":"E0123ABC"}}`, lookups: 2},
+		{body: `{"team_id":"../T0123ABC"}`, wantErr: errBadTeamID, lookups: 2},
+		{body: `{"team_id":"t0123abc"}`, wantErr: errBadTeamID, lookups: 2},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		r.Header.Set("Content-Type", "application/json")
+
+		if _, err := live.resolveTenant(r); !errors.Is(err, test.wantErr) {
+			t.Errorf("resolveTenant(%s) = %v, want %v", test.body, err, test.wantErr)
+		}
+		if n := registry.lookups.Load(); n != test.lookups {
+			t.Errorf("resolveTenant(%s): registry read %d times, want %d", test.body, n, test.lookups)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+const (
+	errorRequestTimeout = "request_timeout"
+
+	// Slightly under Slack's 3 seconds, so Slack gets an answer rather than a dropped connection
+	defaultRequestTimeout = 2800 * time.Millisecond
+
+	// Time kept between the publish budget and the request deadline, for answering a failed publish
+	minRequestTimeoutMargin = 200 * time.Millisecond
+)
+
+var (
+	requestTimeout = defaultRequestTimeout
+
+	timedOutRequests = newCounterVec("slack_proxy_requests_timed_out_total",
+		"Requests answered with a 503 after running past REQUEST_TIMEOUT, by handler.", "handler")
+)
+
+// setupRequestTimeout configures the overall deadline of the requests from the environment
+func setupRequestTimeout() {
+	requestTimeout = configDuration("REQUEST_TIMEOUT", defaultRequestTimeout, true)
+	if requestTimeout > 0 && publishBudget > requestTimeout-minRequestTimeoutMargin {
+		logWarning(context.Background(), "PUBLISH_BUDGET leaves less than %s before REQUEST_TIMEOUT, failed publishes may time out instead.",
+			minRequestTimeoutMargin)
+	}
+}
+
+// withRequestTimeout wraps a handler, setting the deadline of the request's context to REQUEST_TIMEOUT since its arrival.
+// The stages run with the deadline, and the pipeline answers with a 503 once it passes.
+func withRequestTimeout(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestTimeout <= 0 {
+			handler(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithDeadline(r.Context(), requestStart(r.Context()).Add(requestTimeout))
+		defer cancel()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		handler(rec, r.WithContext(ctx))
+
+		if rec.status == http.StatusServiceUnavailable && requestTimedOut(ctx) {
+			timedOutRequests.Inc(name)
+			logError(ctx, "Request %s timed out after %s: %s", requestID(r), requestTimeout, budgetFrom(ctx))
+		}
+	}
+}
+
+// requestTimedOut returns true once the request ran past REQUEST_TIMEOUT
+func requestTimedOut(ctx context.Context) bool {
+	return requestTimeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// writeRequestTimeout answers a request that ran past REQUEST_TIMEOUT
+func writeRequestTimeout(w http.ResponseWriter) {
+	writeError(w, http.StatusServiceUnavailable, errorRequestTimeout)
+}
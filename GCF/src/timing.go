@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message attribute holding the milliseconds spent by each stage before the publish,
+// e.g. verify=1.2,filter=0.0,route=14.5,transform=0.1,total=16.3
+const attrStageTimings = "stage_timings"
+
+// Attach the stage timings to the messages
+var stageTimingsEnabled bool
+
+// setupStageTimings configures the stage timings attribute from the environment
+func setupStageTimings() {
+	stageTimingsEnabled = getenv("STAGE_TIMINGS") == "true"
+}
+
+// attachStageTimings sets the stage timings attribute, right before the message is published.
+// total is the time since the request was received, including the publish stage so far.
+func attachStageTimings(ctx context.Context, attributes map[string]string) {
+	b := budgetFrom(ctx)
+
+	b.mu.Lock()
+	timings := make([]string, 0, len(b.stages)+1)
+	for _, timing := range b.stages {
+		timings = append(timings, timing.stage+"="+formatMillis(timing.duration))
+	}
+	b.mu.Unlock()
+
+	timings = append(timings, "total="+formatMillis(time.Since(b.start)))
+	attributes[attrStageTimings] = strings.Join(timings, ",")
+}
+
+// formatMillis formats the duration in milliseconds, to a tenth of a millisecond
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', 1, 64)
+}
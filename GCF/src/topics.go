@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Time allowed for checking or creating a topic, shared by the requests waiting for it
+	topicLookupTimeout = 5 * time.Second
+
+	// Time a failed topic lookup is remembered, so a missing topic doesn't cost an RPC per request
+	topicNegativeTTL = 10 * time.Second
+)
+
+var (
+	// Handles of the topics resolved from the template or tenants, by name
+	topicsMu sync.Mutex
+	topics   = map[string]pubsubTopic{}
+
+	// Failed lookups, by name
+	failedTopics = map[string]failedTopic{}
+
+	// Lookups in flight, one per topic
+	topicLookups singleflight.Group
+)
+
+// failedTopic is a remembered failure of a topic lookup
+type failedTopic struct {
+	err     error
+	expires time.Time
+}
+
+// Valid Pub/Sub topic ids
+// https://cloud.google.com/pubsub/docs/create-topic#resource_names
+var topicNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9\-_.~+%]{2,254}$`)
+
+// setupTopicTemplate configures the templated destination from the environment.
+// PUBSUB_TOPIC_TEMPLATE is a Go template over the payload, e.g. slack-{{.team_id}}-{{.event.type}}.
+// The message attributes are available under .attributes, e.g. slack-{{.attributes.query_app}}
+func setupTopicTemplate(live *liveConfig) {
+	live.autoCreateTopics = getenv("PUBSUB_TOPIC_AUTO_CREATE") == "true"
+
+	text := getenv("PUBSUB_TOPIC_TEMPLATE")
+	if text == "" {
+		return
+	}
+
+	var err error
+	live.topicTemplate, err = template.New("topic").Option("missingkey=error").Parse(text)
+	if err != nil {
+		configErrorf("Invalid PUBSUB_TOPIC_TEMPLATE: %s.", err.Error())
+	}
+}
+
+// renderTopicName renders the topic template over the payload and attributes of the message
+func (live *liveConfig) renderTopicName(msg *pubsub.Message) (string, error) {
+	fields, err := decodeFields(msg.Data)
+	if err != nil {
+		return "", err
+	}
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	fields["attributes"] = msg.Attributes
+
+	var name strings.Builder
+	if err := live.topicTemplate.Execute(&name, fields); err != nil {
+		return "", err
+	}
+
+	if !topicNamePattern.MatchString(name.String()) || strings.HasPrefix(name.String(), "goog") {
+		return "", fmt.Errorf("invalid topic name %q", name.String())
+	}
+
+	return name.String(), nil
+}
+
+// destinationTopic returns the topic to publish the payload to.
+// Topics already resolved by the pipeline take precedence.
+// Tenants with a topic of their own always publish to it, then channel routes take precedence over the template.
+// Payloads that can't be rendered into an existing topic go to the default topic.
+func destinationTopic(ctx context.Context, msg *pubsub.Message) pubsubTopic {
+	if t, ok := ctx.Value(destinationContextKey{}).(pubsubTopic); ok {
+		return t
+	}
+
+	if t := tenantFromContext(ctx); t != nil && t.Topic != "" {
+		tenantTopic, err := namedTopic(ctx, t.Topic)
+		if err == nil {
+			return tenantTopic
+		}
+		logError(ctx, "Failed resolving topic %s of tenant %s: %s", t.Topic, t.TeamID, err.Error())
+	}
+
+	live := liveConfigFrom(ctx)
+	if live.channelRoutes != nil {
+		if name, ok := live.channelRouteTopic(msg); ok {
+			t, err := namedTopic(ctx, name)
+			if err == nil {
+				return t
+			}
+			logWarning(ctx, "Failed resolving topic %s of the channel route: %s", name, err.Error())
+		}
+	}
+
+	if live.topicTemplate == nil {
+		return topic
+	}
+
+	name, err := live.renderTopicName(msg)
+	if err != nil {
+		logWarning(ctx, "Failed rendering topic name, using the default topic: %s", err.Error())
+		return topic
+	}
+
+	t, err := namedTopic(ctx, name)
+	if err != nil {
+		logWarning(ctx, "Failed resolving topic %s, using the default topic: %s", name, err.Error())
+		return topic
+	}
+
+	return t
+}
+
+// namedTopic returns the cached handle of a topic, checking it exists on first use.
+// Concurrent requests for the same topic share a single lookup, and failures are remembered for a short while.
+func namedTopic(ctx context.Context, name string) (pubsubTopic, error) {
+	topicsMu.Lock()
+	t, ok := topics[name]
+	failed, failedOK := failedTopics[name]
+	topicsMu.Unlock()
+
+	if ok {
+		return t, nil
+	}
+	if failedOK && clock.Now().Before(failed.expires) {
+		return nil, failed.err
+	}
+
+	autoCreate := liveConfigFrom(ctx).autoCreateTopics
+	lookup := topicLookups.DoChan(name, func() (any, error) {
+		return lookupTopic(ctx, name, autoCreate)
+	})
+
+	select {
+	case result := <-lookup:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(pubsubTopic), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lookupTopic checks the topic exists, creating it if allowed, and caches the outcome.
+// Detached from the request starting it, as other requests may be waiting for it too.
+func lookupTopic(logCtx context.Context, name string, autoCreate bool) (pubsubTopic, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), topicLookupTimeout)
+	defer cancel()
+
+	t, err := checkTopic(ctx, logCtx, name, autoCreate)
+
+	topicsMu.Lock()
+	defer topicsMu.Unlock()
+	if err != nil {
+		failedTopics[name] = failedTopic{err: err, expires: clock.Now().Add(topicNegativeTTL)}
+		return nil, err
+	}
+	delete(failedTopics, name)
+	topics[name] = t
+	return t, nil
+}
+
+// checkTopic returns the handle of the topic, if it exists or was created
+func checkTopic(ctx, logCtx context.Context, name string, autoCreate bool) (pubsubTopic, error) {
+	t := openTopic(name)
+	exists, err := t.Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		if !autoCreate {
+			return nil, fmt.Errorf("topic doesn't exist")
+		}
+		if t, err = createTopic(ctx, name); err != nil {
+			return nil, err
+		}
+		logInfo(logCtx, "Created topic %s.", name)
+	}
+	return t, nil
+}
@@ -0,0 +1,344 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Message attribute holding why the payload of a dead-lettered message couldn't be transformed
+const attrTransformError = "transform_error"
+
+// Transformer is a step of the transform stage, modifying the payload before it's published
+type Transformer interface {
+	// Transform modifies the decoded JSON payload in place
+	Transform(payload map[string]any) error
+}
+
+// TransformerFactory creates a transformer from the JSON configuration of its step
+type TransformerFactory func(config json.RawMessage) (Transformer, error)
+
+var (
+	// Factories of the transformers available to TRANSFORMS, by step name
+	transformerFactories = map[string]TransformerFactory{
+		"drop_fields":   newDropFields,
+		"rename_keys":   newRenameKeys,
+		"flatten_event": newFlattenEvent,
+		"add_metadata":  newAddMetadata,
+	}
+
+	// Transformers of the transform stage, in order. Empty if disabled.
+	transformers []Transformer
+
+	// Topic receiving the payloads failing the transforms, untransformed. Nil to drop them.
+	transformDeadLetterTopic pubsubTopic
+
+	failedTransforms = newCounterVec("slack_proxy_transforms_failed_total",
+		"Payloads failing the transforms, by outcome.", "outcome")
+)
+
+// RegisterTransformer makes a custom transformer available to TRANSFORMS under the step name.
+// Must be called before Setup.
+func RegisterTransformer(name string, factory TransformerFactory) {
+	transformerFactories[name] = factory
+}
+
+// setupTransforms configures the transform steps from the environment.
+// TRANSFORMS is a JSON array of steps, e.g. [{"step":"drop_fields","fields":["event.blocks"]}]
+func setupTransforms() {
+	config := getenv("TRANSFORMS")
+	if config == "" {
+		return
+	}
+
+	var steps []json.RawMessage
+	if err := json.Unmarshal([]byte(config), &steps); err != nil {
+		configErrorf("Invalid TRANSFORMS: %s.", err.Error())
+		return
+	}
+
+	for i, step := range steps {
+		var header struct {
+			Step string `json:"step"`
+		}
+		if err := json.Unmarshal(step, &header); err != nil {
+			configErrorf("Invalid TRANSFORMS step %d: %s.", i, err.Error())
+			continue
+		}
+
+		factory, ok := transformerFactories[header.Step]
+		if !ok {
+			configErrorf("Unknown TRANSFORMS step %d: %s.", i, header.Step)
+			continue
+		}
+
+		t, err := factory(step)
+		if err != nil {
+			configErrorf("Invalid TRANSFORMS step %d (%s): %s.", i, header.Step, err.Error())
+			continue
+		}
+		transformers = append(transformers, t)
+	}
+
+	if topicName := getenv("TRANSFORM_DEAD_LETTER_TOPIC"); topicName != "" {
+		if backend != backendPubSub {
+			configErrorf("TRANSFORM_DEAD_LETTER_TOPIC is only supported by the pubsub backend.")
+			return
+		}
+		transformDeadLetterTopic = openExistingTopic(topicName)
+	}
+}
+
+// transform runs the transform steps over the message payload.
+// Failures are deterministic, so the payload is dead-lettered or dropped rather than failing the request,
+// which Slack would retry in vain.
+func transform(w http.ResponseWriter, e *Event) bool {
+	if len(transformers) == 0 || !flagEnabled(flagTransforms) {
+		return true
+	}
+
+	ctx := e.Request.Context()
+	data, err := transformPayloadSteps(e.Message.Data)
+	if err == nil {
+		e.Message.Data = data
+		return true
+	}
+
+	if transformDeadLetterTopic != nil {
+		logError(ctx, "Failed transforming payload, publishing it to %s: %s", transformDeadLetterTopic.ID(), err.Error())
+		failedTransforms.Inc("dead_lettered")
+		e.topic = transformDeadLetterTopic
+		e.Topic = transformDeadLetterTopic.ID()
+		e.Message.Attributes[attrTransformError] = err.Error()
+		return true
+	}
+
+	logError(ctx, "Failed transforming payload, dropping it: %s", err.Error())
+	logPayload(ctx, e.Message.Data)
+	failedTransforms.Inc("dropped")
+	w.WriteHeader(http.StatusOK)
+	e.release()
+	return false
+}
+
+// decodeFields decodes a JSON object, keeping the precision of numeric ids and timestamps
+func decodeFields(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// transformPayloadSteps decodes the payload, runs the steps over it and encodes it back
+func transformPayloadSteps(data []byte) ([]byte, error) {
+	payload, err := decodeFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, fmt.Errorf("payload isn't an object")
+	}
+
+	for _, t := range transformers {
+		if err := t.Transform(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(payload)
+}
+
+// fieldPath is a dot separated path to a field of nested objects, e.g. event.user
+type fieldPath []string
+
+func parseFieldPath(path string) (fieldPath, error) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid field path %q", path)
+		}
+	}
+	return parts, nil
+}
+
+// parent returns the object holding the field, creating missing objects if create is set.
+// Returns nil if the path doesn't go through objects.
+func (p fieldPath) parent(payload map[string]any, create bool) map[string]any {
+	obj := payload
+	for _, key := range p[:len(p)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			if _, exists := obj[key]; exists || !create {
+				return nil
+			}
+			next = map[string]any{}
+			obj[key] = next
+		}
+		obj = next
+	}
+	return obj
+}
+
+func (p fieldPath) key() string {
+	return p[len(p)-1]
+}
+
+// dropFields removes fields, e.g. bulky blocks or user identifiers
+type dropFields struct {
+	fields []fieldPath
+}
+
+func newDropFields(config json.RawMessage) (Transformer, error) {
+	var step struct {
+		Fields []string `json:"fields"`
+	}
+	if err := json.Unmarshal(config, &step); err != nil {
+		return nil, err
+	}
+
+	t := &dropFields{}
+	for _, field := range step.Fields {
+		path, err := parseFieldPath(field)
+		if err != nil {
+			return nil, err
+		}
+		t.fields = append(t.fields, path)
+	}
+	return t, nil
+}
+
+func (t *dropFields) Transform(payload map[string]any) error {
+	for _, path := range t.fields {
+		if obj := path.parent(payload, false); obj != nil {
+			delete(obj, path.key())
+		}
+	}
+	return nil
+}
+
+// renameKeys moves fields to new paths
+type renameKeys struct {
+	from, to []fieldPath
+}
+
+func newRenameKeys(config json.RawMessage) (Transformer, error) {
+	var step struct {
+		Keys map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(config, &step); err != nil {
+		return nil, err
+	}
+
+	// Renamed in the order of their source paths, so chained renames apply the same way every time
+	sources := make([]string, 0, len(step.Keys))
+	for from := range step.Keys {
+		sources = append(sources, from)
+	}
+	sort.Strings(sources)
+
+	t := &renameKeys{}
+	for _, from := range sources {
+		fromPath, err := parseFieldPath(from)
+		if err != nil {
+			return nil, err
+		}
+		toPath, err := parseFieldPath(step.Keys[from])
+		if err != nil {
+			return nil, err
+		}
+		t.from = append(t.from, fromPath)
+		t.to = append(t.to, toPath)
+	}
+	return t, nil
+}
+
+func (t *renameKeys) Transform(payload map[string]any) error {
+	for i, from := range t.from {
+		obj := from.parent(payload, false)
+		if obj == nil {
+			continue
+		}
+		value, ok := obj[from.key()]
+		if !ok {
+			continue
+		}
+
+		target := t.to[i].parent(payload, true)
+		if target == nil {
+			return fmt.Errorf("can't rename %s to %s", strings.Join(from, "."), strings.Join(t.to[i], "."))
+		}
+		delete(obj, from.key())
+		target[t.to[i].key()] = value
+	}
+	return nil
+}
+
+// flattenEvent lifts the fields of the inner event to the top level, prefixed with event_
+type flattenEvent struct{}
+
+func newFlattenEvent(json.RawMessage) (Transformer, error) {
+	return flattenEvent{}, nil
+}
+
+func (flattenEvent) Transform(payload map[string]any) error {
+	event, ok := payload["event"].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	delete(payload, "event")
+	for key, value := range event {
+		payload["event_"+key] = value
+	}
+	return nil
+}
+
+// addMetadata sets static fields, e.g. the source or environment
+type addMetadata struct {
+	fields map[string]any
+}
+
+func newAddMetadata(config json.RawMessage) (Transformer, error) {
+	var step struct {
+		Fields map[string]any `json:"fields"`
+	}
+	if err := json.Unmarshal(config, &step); err != nil {
+		return nil, err
+	}
+	return &addMetadata{fields: step.Fields}, nil
+}
+
+// Each payload gets its own copy of the fields, as later steps may modify them in place
+func (t *addMetadata) Transform(payload map[string]any) error {
+	for key, value := range t.fields {
+		payload[key] = copyJSONValue(value)
+	}
+	return nil
+}
+
+// copyJSONValue deep-copies a decoded JSON value
+func copyJSONValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(value))
+		for key, field := range value {
+			copied[key] = copyJSONValue(field)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(value))
+		for i, item := range value {
+			copied[i] = copyJSONValue(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRenameKeysOrder(t *testing.T) {
+	// Chained renames apply in the sorted order of their sources, whatever the map's iteration order
+	for i := 0; i < 20; i++ {
+		step, err := newRenameKeys(json.RawMessage(`{"keys":{"b":"c","a":"b"}}`))
+		if err != nil {
+			t.Fatalf("newRenameKeys() = %v", err)
+		}
+
+		payload := map[string]any{"a": 1.0}
+		if err := step.Transform(payload); err != nil {
+			t.Fatalf("Transform() = %v", err)
+		}
+		if want := map[string]any{"c": 1.0}; !reflect.DeepEqual(payload, want) {
+			t.Fatalf("Transform() = %v, want %v", payload, want)
+		}
+	}
+}
+
+func TestAddMetadataCopiesFields(t *testing.T) {
+	step, err := newAddMetadata(json.RawMessage(`{"fields":{"meta":{"tags":["a"]}}}`))
+	if err != nil {
+		t.Fatalf("newAddMetadata() = %v", err)
+	}
+
+	first, second := map[string]any{}, map[string]any{}
+	step.Transform(first)
+	first["meta"].(map[string]any)["tags"].([]any)[0] = "changed"
+	first["meta"].(map[string]any)["source"] = "changed"
+	step.Transform(second)
+
+	if want := map[string]any{"meta": map[string]any{"tags": []any{"a"}}}; !reflect.DeepEqual(second, want) {
+		t.Errorf("Transform() after modifying an earlier payload = %v, want %v", second, want)
+	}
+}
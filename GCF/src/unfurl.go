@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Event type of links posted to a domain the app unfurls
+const eventLinkShared = "link_shared"
+
+const defaultUnfurlTimeout = time.Second
+
+var (
+	// Topic receiving the link_shared events instead of the destination topic, nil if unset
+	linkSharedTopic pubsubTopic
+
+	// URL of the unfurl service receiving the link_shared events directly, empty if disabled
+	unfurlURL     string
+	unfurlTimeout = defaultUnfurlTimeout
+)
+
+// setupUnfurls configures the link_shared fast path from the environment
+func setupUnfurls() {
+	if topicName := getenv("LINK_SHARED_TOPIC"); topicName != "" {
+		linkSharedTopic = openExistingTopic(topicName)
+	}
+
+	unfurlURL = getenv("UNFURL_URL")
+	if unfurlURL == "" {
+		return
+	}
+	if u, err := url.Parse(unfurlURL); err != nil || u.Host == "" {
+		configErrorf("UNFURL_URL must be a valid URL.")
+	}
+	unfurlTimeout = configDuration("UNFURL_TIMEOUT", defaultUnfurlTimeout, false)
+}
+
+// forwardUnfurl posts a link_shared event to the unfurl service, skipping Pub/Sub.
+// Returns false if the service failed, leaving the event to be published.
+func forwardUnfurl(w http.ResponseWriter, e *Event) bool {
+	ctx := e.Request.Context()
+	if err := postUnfurl(ctx, e.Message.Data); err != nil {
+		logError(ctx, "Failed forwarding link_shared event to the unfurl service, publishing it instead: %s", err.Error())
+		return false
+	}
+
+	e.forwarded = true
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// postUnfurl posts the payload to the unfurl service, signed and authenticated as the webhook requests
+func postUnfurl(ctx context.Context, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, stageTimeout(ctx, unfurlTimeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, unfurlURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhookSigningSecret != nil {
+		signWebhookRequest(req, data)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+	return nil
+}
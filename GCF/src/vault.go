@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultVaultMount = "secret"
+
+var (
+	vaultClient = &http.Client{Timeout: 10 * time.Second}
+
+	// Renews the token once, not on every reload
+	vaultRenewal sync.Once
+)
+
+// loadVaultSecrets reads the secret at VAULT_SECRET_PATH from HashiCorp Vault's KV v2 engine.
+// Its keys are variable names, e.g. SLACK_SIGNING_SECRET or WEBHOOK_SIGNING_SECRET.
+func loadVaultSecrets() {
+	path := getenv("VAULT_SECRET_PATH")
+	if path == "" {
+		return
+	}
+
+	addr := strings.TrimSuffix(getenv("VAULT_ADDR"), "/")
+	token := getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		configErrorf("VAULT_ADDR and VAULT_TOKEN env vars must be set when VAULT_SECRET_PATH is set.")
+		return
+	}
+
+	mount := getenv("VAULT_KV_MOUNT")
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vaultClient.Timeout)
+	defer cancel()
+
+	var secret struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	url := fmt.Sprintf("%s/v1/%s/data/%s", addr, mount, strings.TrimPrefix(path, "/"))
+	if err := callVault(ctx, http.MethodGet, url, token, &secret); err != nil {
+		configErrorf("Failed reading Vault secret %s: %s.", path, err.Error())
+		return
+	}
+
+	configMu.Lock()
+	configVault = configFromMap(secret.Data.Data)
+	configMu.Unlock()
+
+	vaultRenewal.Do(func() { go renewVaultToken(addr, token) })
+}
+
+// renewVaultToken renews the lease of a renewable token at half its TTL, so reloads can read the secrets again
+func renewVaultToken(addr, token string) {
+	ctx := context.Background()
+
+	var lookup struct {
+		Data struct {
+			Renewable bool  `json:"renewable"`
+			TTL       int64 `json:"ttl"`
+		} `json:"data"`
+	}
+	if err := callVault(ctx, http.MethodGet, addr+"/v1/auth/token/lookup-self", token, &lookup); err != nil {
+		logWarning(ctx, "Failed looking up the Vault token: %s", err.Error())
+		return
+	}
+	if !lookup.Data.Renewable || lookup.Data.TTL <= 0 {
+		return
+	}
+
+	ttl := time.Duration(lookup.Data.TTL) * time.Second
+	for {
+		time.Sleep(ttl / 2)
+
+		var renewal struct {
+			Auth struct {
+				LeaseDuration int64 `json:"lease_duration"`
+			} `json:"auth"`
+		}
+		if err := callVault(ctx, http.MethodPost, addr+"/v1/auth/token/renew-self", token, &renewal); err != nil {
+			// Retried before the lease ends
+			logWarning(ctx, "Failed renewing the Vault token: %s", err.Error())
+			ttl /= 2
+			if ttl < 2*time.Second {
+				logError(ctx, "Vault token expired.")
+				return
+			}
+			continue
+		}
+		ttl = time.Duration(renewal.Auth.LeaseDuration) * time.Second
+		if ttl <= 0 {
+			return
+		}
+	}
+}
+
+// callVault calls the Vault API, decoding the response into result
+func callVault(ctx context.Context, method, url, token string, result any) error {
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader("{}")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build info, set at build time using
+// -ldflags "-X github.com/bharel/SlackFunctionsProxy.Version=... -X ...Commit=... -X ...BuildDate=..."
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Message attribute identifying the proxy build that forwarded the message
+const attrProxyVersion = "proxy_version"
+
+// Path serving the build info
+const versionPath = "/version"
+
+// buildInfo is the build info served at /version
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// setupBuildInfo completes missing build info from the VCS info embedded by the Go toolchain,
+// and logs it
+func setupBuildInfo() {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && Commit == "":
+				Commit = setting.Value
+			case setting.Key == "vcs.time" && BuildDate == "":
+				BuildDate = setting.Value
+			}
+		}
+	}
+
+	logInfo(context.Background(), "Slack proxy version %s (commit %s, built %s).", Version, Commit, BuildDate)
+}
+
+// versionString identifies the build in message attributes
+func versionString() string {
+	if Commit == "" {
+		return Version
+	}
+	if len(Commit) > 12 {
+		return Version + "+" + Commit[:12]
+	}
+	return Version + "+" + Commit
+}
+
+// isVersionRequest returns true if the request should be served the build info
+func isVersionRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.URL.Path == versionPath
+}
+
+// serveVersion writes the build info
+func serveVersion(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	})
+}
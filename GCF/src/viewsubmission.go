@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Payload type of modal submissions
+const payloadViewSubmission = "view_submission"
+
+const (
+	defaultViewSubmissionTimeout = 2 * time.Second
+
+	// Slack limits view responses well below this
+	maxViewSubmissionResponseSize = 1024 * 1024
+)
+
+var (
+	// URL answering modal submissions synchronously, empty if disabled
+	viewSubmissionURL string
+
+	// Callback ids of the views answered synchronously, all if nil
+	viewSubmissionCallbacks map[string]bool
+
+	viewSubmissionTimeout = defaultViewSubmissionTimeout
+)
+
+// setupViewSubmissions configures the synchronous modal submissions from the environment
+func setupViewSubmissions() {
+	viewSubmissionURL = getenv("VIEW_SUBMISSION_URL")
+	if viewSubmissionURL == "" {
+		return
+	}
+
+	if u, err := url.Parse(viewSubmissionURL); err != nil || u.Host == "" {
+		configErrorf("VIEW_SUBMISSION_URL must be a valid URL.")
+	}
+	if !formPayloads {
+		configErrorf("FORM_PAYLOADS must be enabled when VIEW_SUBMISSION_URL is set.")
+	}
+
+	viewSubmissionTimeout = configDuration("VIEW_SUBMISSION_TIMEOUT", defaultViewSubmissionTimeout, false)
+
+	viewSubmissionCallbacks = nil
+	if ids := getenv("VIEW_SUBMISSION_CALLBACK_IDS"); ids != "" {
+		viewSubmissionCallbacks = map[string]bool{}
+		for _, id := range strings.Split(ids, ",") {
+			viewSubmissionCallbacks[strings.TrimSpace(id)] = true
+		}
+	}
+}
+
+// isSyncViewSubmission returns true if the payload is a modal submission answered by the backend
+func isSyncViewSubmission(payload *slackPayload) bool {
+	if viewSubmissionURL == "" || payload.Type != payloadViewSubmission {
+		return false
+	}
+	return viewSubmissionCallbacks == nil || viewSubmissionCallbacks[payload.View.CallbackID]
+}
+
+// serveViewSubmission relays the backend's response_action reply to a modal submission, e.g. validation errors.
+// Returns false if the backend had nothing to answer, or failed, leaving the submission to the async path.
+func serveViewSubmission(w http.ResponseWriter, r *http.Request, payload *slackPayload, data []byte) bool {
+	ctx := r.Context()
+
+	response, err := submitView(ctx, data)
+	if err != nil {
+		logError(ctx, "Failed submitting view %s, publishing it instead: %s", payload.View.CallbackID, err.Error())
+		return false
+	}
+	if len(response) == 0 {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+	return true
+}
+
+// submitView posts the payload to the view submission backend, returning its response.
+// The request is signed and authenticated as those of the webhook backend.
+// The response is empty if the backend closes the modal with a 200 or 204 without a body.
+func submitView(ctx context.Context, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, stageTimeout(ctx, viewSubmissionTimeout))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, viewSubmissionURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhookSigningSecret != nil {
+		signWebhookRequest(req, data)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+
+	response, err := io.ReadAll(io.LimitReader(resp.Body, maxViewSubmissionResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	response = bytes.TrimSpace(response)
+	if len(response) > 0 && !json.Valid(response) {
+		return nil, errors.New("returned invalid JSON")
+	}
+	return response, nil
+}
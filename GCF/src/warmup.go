@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// Path and header identifying warm-up requests, disabled if empty
+	warmupPath   string
+	warmupHeader string
+
+	warmupOnce sync.Once
+)
+
+// setupWarmup configures the warm-up requests from the environment
+func setupWarmup() {
+	warmupPath = getenv("WARMUP_PATH")
+	warmupHeader = getenv("WARMUP_HEADER")
+}
+
+// isWarmupRequest returns true if the request was sent by a scheduler keeping the instance warm
+func isWarmupRequest(r *http.Request) bool {
+	if warmupPath != "" && r.URL.Path == warmupPath {
+		return true
+	}
+	return warmupHeader != "" && r.Header.Get(warmupHeader) != ""
+}
+
+// serveWarmup responds to a warm-up request, skipping validation and publishing.
+// The first warm-up establishes the backend connections, so the first real
+// Slack event doesn't pay the connection setup cost.
+func serveWarmup(w http.ResponseWriter, r *http.Request) {
+	warmupOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		start := time.Now()
+		if topic != nil {
+			if _, err := topic.Exists(ctx); err != nil {
+				logWarning(ctx, "Failed warming up the Pub/Sub connection: %s", err.Error())
+			}
+		}
+		logInfo(ctx, "Warmed up in %s.", time.Since(start))
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Schemes of the webhook signatures
+const (
+	// Slack's own scheme, verifiable by the Slack SDKs using the downstream secret
+	signatureSchemeSlack = "slack"
+
+	// An HMAC-SHA256 of the body in a single header, e.g. X-Signature-256: sha256=...
+	signatureSchemeHMAC = "hmac-sha256"
+)
+
+const defaultSignatureHeader = "X-Signature-256"
+
+var (
+	// Secret shared with the webhook, nil if its requests aren't signed
+	webhookSigningSecret []byte
+
+	webhookSignatureScheme = signatureSchemeSlack
+	webhookSignatureHeader = defaultSignatureHeader
+)
+
+// setupWebhookSigning configures the webhook signatures from the environment
+func setupWebhookSigning() {
+	webhookSigningSecret = []byte(getenv("WEBHOOK_SIGNING_SECRET"))
+	if len(webhookSigningSecret) == 0 {
+		webhookSigningSecret = nil
+		return
+	}
+
+	switch scheme := getenv("WEBHOOK_SIGNATURE_SCHEME"); scheme {
+	case "", signatureSchemeSlack:
+	case signatureSchemeHMAC:
+		webhookSignatureScheme = scheme
+	default:
+		configErrorf("Unknown WEBHOOK_SIGNATURE_SCHEME: %s.", scheme)
+	}
+
+	if header := getenv("WEBHOOK_SIGNATURE_HEADER"); header != "" {
+		webhookSignatureHeader = header
+	}
+}
+
+// signWebhookRequest signs the forwarded body with the downstream secret,
+// so the webhook can verify the request came from the proxy
+func signWebhookRequest(req *http.Request, body []byte) {
+	mac := hmac.New(sha256.New, webhookSigningSecret)
+
+	if webhookSignatureScheme == signatureSchemeHMAC {
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		return
+	}
+
+	timestamp := strconv.FormatInt(clock.Now().Unix(), 10)
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+}
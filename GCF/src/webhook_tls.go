@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+)
+
+// setupWebhookTLS configures the client certificate and pinned CAs of the webhook requests from the environment,
+// so the hop to internal webhooks is mutually authenticated
+func setupWebhookTLS() {
+	certFile := getenv("WEBHOOK_CLIENT_CERT_FILE")
+	keyFile := getenv("WEBHOOK_CLIENT_KEY_FILE")
+	caFile := getenv("WEBHOOK_CA_FILE")
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return
+	}
+
+	// Keeps the proxy and connection settings of the default transport, and the egress CAs
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if egressTransport != nil {
+		transport = egressTransport.Clone()
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if transport.TLSClientConfig != nil {
+		config = transport.TLSClientConfig.Clone()
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			configErrorf("Invalid WEBHOOK_CLIENT_CERT_FILE or WEBHOOK_CLIENT_KEY_FILE: %s.", err.Error())
+		} else {
+			config.Certificates = []tls.Certificate{cert}
+		}
+	}
+
+	// Only the pinned CAs are trusted, rather than the system roots
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		pool := x509.NewCertPool()
+		if err != nil || !pool.AppendCertsFromPEM(pem) {
+			configErrorf("WEBHOOK_CA_FILE must be a file of PEM certificates.")
+		} else {
+			config.RootCAs = pool
+		}
+	}
+
+	transport.TLSClientConfig = config
+	webhookClient.Transport = transport
+}